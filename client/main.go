@@ -2,29 +2,82 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
 	"github.com/sirupsen/logrus"
 	"go-upload-chunk/server/drivers/logger"
 	"io"
+	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
 	"sync"
+	"time"
 )
 
+// retryableError is error of chunk upload which is worth to retry
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (r *retryableError) Error() string {
+	return r.err.Error()
+}
+
+// apiKeyTransport sets api key and tenant header to every request
+type apiKeyTransport struct {
+	apiKey string
+	tenant string
+	next   http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.apiKey != "" || t.tenant != "" {
+		req = req.Clone(req.Context())
+	}
+
+	if t.apiKey != "" {
+		req.Header.Set("X-API-Key", t.apiKey)
+	}
+
+	if t.tenant != "" {
+		req.Header.Set("X-Tenant-ID", t.tenant)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
 func main() {
 	logger.SetupLogger()
 
 	var (
-		filename   = "sample.jpeg"
-		totalChunk = 100
-		mu         = &sync.Mutex{}
+		filename    = flag.String("file", "sample.jpeg", "file name inside ./upload folder")
+		totalChunk  = flag.Int("chunks", 100, "total chunk")
+		concurrency = flag.Int("concurrency", 4, "number of chunks uploaded concurrently")
+		maxRetry    = flag.Int("retry", 5, "max retry of each failed chunk")
+		serverURL   = flag.String("server", "http://localhost:4000", "server base URL")
+		apiKey      = flag.String("api-key", "", "api key sent in X-API-Key header")
+		tenant      = flag.String("tenant", "", "tenant sent in X-Tenant-ID header, picked only by api key of admin scope or bound to it. empty uses tenant of api key or default tenant")
+		encoding    = flag.String("encoding", "gzip", "compress chunk when it makes chunk smaller : gzip, deflate, zstd or none")
 	)
 
+	flag.Parse()
+
+	if *totalChunk < 1 || *concurrency < 1 || *maxRetry < 0 {
+		fmt.Fprintln(flag.CommandLine.Output(), "chunks and concurrency must be at least 1, retry must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	// open file
-	f, err := os.Open(fmt.Sprintf("./upload/%s", filename))
+	f, err := os.Open(fmt.Sprintf("./upload/%s", *filename))
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -39,83 +92,299 @@ func main() {
 	// get file size
 	fileSize := fileInfo.Size()
 	logrus.Infof("file size : %d 🗂️", fileSize)
-	chunkSize := fileSize / int64(totalChunk)
 
-	// read and upload each chunk
-	for i := 0; i < totalChunk; i++ {
-		// lock mutex
-		mu.Lock()
+	// every chunk has one byte at least, empty file is sent as one empty chunk
+	if int64(*totalChunk) > max(fileSize, 1) {
+		logrus.Warnf("file has %d bytes only, upload it in %d chunks instead of %d ⚠️", fileSize, max(fileSize, 1), *totalChunk)
+		*totalChunk = int(max(fileSize, 1))
+	}
+
+	chunkSize := fileSize / int64(*totalChunk)
+
+	// create checksum of whole file
+	hashChecksum := sha256.New()
+	if _, err = io.Copy(hashChecksum, f); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// one http client is shared by all workers, it authenticates every request by api key
+	httpClient := &http.Client{
+		Timeout:   time.Minute,
+		Transport: &apiKeyTransport{apiKey: *apiKey, tenant: *tenant, next: http.DefaultTransport},
+	}
+
+	// create upload session
+	uploadID, completed, err := createSession(httpClient, *serverURL, *filename, fileSize, *totalChunk, hex.EncodeToString(hashChecksum.Sum(nil)))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	logrus.Infof("upload id : %s 🎫", uploadID)
 
+	// server already stores file of same checksum, nothing to upload
+	if completed {
+		logrus.Infof("file already stored, upload completed without sending chunks")
+		return
+	}
+
+	var (
+		wg           = &sync.WaitGroup{}
+		mu           = &sync.Mutex{}
+		failedChunks []int
+		chunkIndexes = make(chan int)
+	)
+
+	// sendChunk reads chunk at its offset and uploads it with retry, failed chunk is recorded
+	sendChunk := func(i int) {
 		var (
 			start = int64(i) * chunkSize
 			end   int64
 		)
 
-		if i == totalChunk-1 {
+		if i == *totalChunk-1 {
 			// last chunk
 			end = fileSize
 		} else {
 			end = start + chunkSize
 		}
 
-		// set offset to start value
-		if _, err = f.Seek(start, io.SeekStart); err != nil {
-			logrus.Errorf("Error seeking to chunk %d: %v", i, err)
-			break
+		// read per chunk at its offset, safe for concurrent read
+		content := make([]byte, end-start)
+		if _, err := f.ReadAt(content, start); err != nil && err != io.EOF {
+			logrus.Errorf("Error reading chunk %d: %v", i, err)
+			mu.Lock()
+			failedChunks = append(failedChunks, i)
+			mu.Unlock()
+			return
 		}
 
-		// read per chunk and save []byte to variable content
-		content := make([]byte, end-start)
-		if _, err = f.Read(content); err != nil {
-			logrus.Error(err)
-			break
+		// upload each chunk with retry
+		if err := uploadChunkWithRetry(httpClient, *serverURL, uploadID, content, i, *maxRetry, *encoding); err != nil {
+			logrus.Errorf("failed upload chunk %d : %v", i, err)
+			mu.Lock()
+			failedChunks = append(failedChunks, i)
+			mu.Unlock()
 		}
+	}
+
+	// first chunk tells content type of upload, server refuses other chunks until it is received
+	sendChunk(0)
+	if len(failedChunks) > 0 {
+		logrus.Fatalf("failed upload first chunk, upload is not continued")
+	}
 
-		// upload each chunk
-		uploadChunk(filename, content, strconv.Itoa(i), strconv.Itoa(totalChunk))
-		content = nil
+	// spawn workers : each worker uploads rest of chunks from channel
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-		// unlock mutex
-		mu.Unlock()
+			for i := range chunkIndexes {
+				sendChunk(i)
+			}
+		}()
+	}
+
+	for i := 1; i < *totalChunk; i++ {
+		chunkIndexes <- i
+	}
+
+	close(chunkIndexes)
+	wg.Wait()
+
+	if len(failedChunks) > 0 {
+		logrus.Fatalf("failed upload %d chunks : %v", len(failedChunks), failedChunks)
 	}
 
 	logrus.Infof("success upload")
 }
 
-// uploadChunk uploads file for each chunk
-func uploadChunk(filename string, content []byte, chunkIndex, totalChunk string) {
-	// create http client
-	httpClient := &http.Client{}
+// createSession creates upload session and returns its upload ID, and whether upload is already completed
+func createSession(httpClient *http.Client, serverURL, filename string, totalSize int64, totalChunk int, checksum string) (string, bool, error) {
+	body, err := json.Marshal(map[string]any{
+		"filename":    filename,
+		"total_size":  totalSize,
+		"total_chunk": totalChunk,
+		"check_sum":   checksum,
+	})
+	if err != nil {
+		return "", false, err
+	}
 
-	// create checksum
-	hashChecksum := sha256.New()
-	if _, err := io.Copy(hashChecksum, bytes.NewReader(content)); err != nil {
-		logrus.Error(err)
-		return
+	// execute http call
+	resp, err := httpClient.Post(serverURL+"/v1/file/session", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", false, err
+	}
+
+	defer resp.Body.Close()
+
+	var result struct {
+		Data struct {
+			ID        string `json:"id"`
+			Completed bool   `json:"completed"`
+		} `json:"data"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", false, err
 	}
 
-	checksum := hex.EncodeToString(hashChecksum.Sum(nil))
+	if result.Data.ID == "" {
+		return "", false, fmt.Errorf("failed create upload session, status code %d", resp.StatusCode)
+	}
+
+	return result.Data.ID, result.Data.Completed, nil
+}
+
+// uploadChunkWithRetry uploads chunk, retries with exponential backoff and jitter when failed
+func uploadChunkWithRetry(httpClient *http.Client, serverURL, uploadID string, content []byte, chunkIndex, maxRetry int, encoding string) error {
+	// chunk is compressed once, checksum is always of original content
+	body, contentEncoding, err := encodeChunk(content, encoding)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := uploadChunk(httpClient, serverURL, uploadID, content, body, contentEncoding, chunkIndex)
+		if err == nil {
+			return nil
+		}
+
+		retryable, ok := err.(*retryableError)
+		if !ok || attempt >= maxRetry {
+			return err
+		}
+
+		// respect Retry-After sent by server, otherwise full jitter backoff
+		wait := retryable.retryAfter
+		if wait == 0 {
+			wait = backoff(attempt)
+		}
+
+		logrus.Warnf("retry upload chunk %d in %s : %v ⚠️", chunkIndex, wait, err)
+		time.Sleep(wait)
+	}
+}
+
+// backoff returns random duration between 0 and exponential backoff of given attempt, max 30s
+func backoff(attempt int) time.Duration {
+	maxBackoff := 30 * time.Second
+	d := 500 * time.Millisecond << attempt
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// encodeChunk compresses chunk by given encoding, chunk is sent as is if compression saves less than 10%
+func encodeChunk(content []byte, encoding string) ([]byte, string, error) {
+	var (
+		buf    bytes.Buffer
+		writer io.WriteCloser
+		err    error
+	)
+
+	switch encoding {
+	case "gzip":
+		writer, err = gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	case "deflate":
+		writer, err = zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+	case "zstd":
+		writer, err = zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	case "", "none":
+		return content, "", nil
+	default:
+		return nil, "", fmt.Errorf("unknown encoding %s", encoding)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err = writer.Write(content); err != nil {
+		return nil, "", err
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	if buf.Len() > len(content)*9/10 {
+		return content, "", nil
+	}
+
+	return buf.Bytes(), encoding, nil
+}
+
+// uploadChunk uploads file for each chunk, body is content encoded by contentEncoding
+func uploadChunk(httpClient *http.Client, serverURL, uploadID string, content, body []byte, contentEncoding string, chunkIndex int) error {
+	// create checksum
+	hashChecksum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(hashChecksum[:])
 
 	// create http request
-	req, err := http.NewRequest(http.MethodPost, "http://localhost:4000/v1/file/chunk", bytes.NewReader(content))
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/v1/file/chunk", bytes.NewReader(body))
 	if err != nil {
-		logrus.Error(err)
-		return
+		return err
 	}
 
 	// set header
 	req.Header.Add("Content-Type", "application/octet-stream")
-	req.Header.Add("filename", filename)
+	if contentEncoding != "" {
+		req.Header.Add("Content-Encoding", contentEncoding)
+	}
+
+	req.Header.Add("upload-id", uploadID)
 	req.Header.Add("check-sum", checksum)
-	req.Header.Add("chunk-index", chunkIndex)
-	req.Header.Add("total-chunk", totalChunk)
+	req.Header.Add("chunk-index", strconv.Itoa(chunkIndex))
 
-	// execute http call
+	// execute http call, network error is worth to retry
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		logrus.Error(err)
-		return
+		return &retryableError{err: err}
 	}
 
 	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+
+	// error body tells whether chunk should be sent again
+	var result struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		TraceID   string `json:"trace_id"`
+		Retryable bool   `json:"retryable"`
+	}
+
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result)
+	err = fmt.Errorf("status code %d : %s %s (trace id %s)", resp.StatusCode, result.Code, result.Message, result.TraceID)
+
+	if result.Retryable || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	return err
+}
+
+// parseRetryAfter parses Retry-After header, either delay seconds or http date
+func parseRetryAfter(retryAfter string) time.Duration {
+	if retryAfter == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(retryAfter); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
 }
@@ -1,18 +1,25 @@
 package config
 
 import (
+	"fmt"
 	"github.com/joho/godotenv"
-	"github.com/sirupsen/logrus"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// GetEnv retrieves value from env
-func GetEnv(k string) string {
+// Load loads .env into env once at startup, variables already set in env are kept
+func Load() error {
 	if err := godotenv.Load(".env"); err != nil {
-		logrus.Fatalf("failed load env : %s", err.Error())
+		return fmt.Errorf("failed load env : %w", err)
 	}
 
+	return nil
+}
+
+// GetEnv retrieves value from env, .env is loaded by Load
+func GetEnv(k string) string {
 	return os.Getenv(k)
 }
 
@@ -42,3 +49,394 @@ func FolderUploadChunk() string {
 func FolderUploadFinal() string {
 	return GetEnv("FOLDER_UPLOAD_FINAL")
 }
+
+// FolderUploadTenant retrieves path folder to save chunk and final folders of each tenant other than default
+func FolderUploadTenant() string {
+	if val := GetEnv("FOLDER_UPLOAD_TENANT"); val != "" {
+		return val
+	}
+
+	// default folder
+	return "./upload/tenant"
+}
+
+// TenantsFile retrieves json file of tenants and their config, empty means default tenant only
+func TenantsFile() string {
+	return GetEnv("TENANTS_FILE")
+}
+
+// TusExpiration retrieves how long unfinished tus upload is kept
+func TusExpiration() time.Duration {
+	if val := GetEnv("TUS_EXPIRATION"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+
+	// default expiration
+	return 24 * time.Hour
+}
+
+// StorageDriver retrieves storage driver to save chunk and final files. local, memory or s3
+func StorageDriver() string {
+	return GetEnv("STORAGE_DRIVER")
+}
+
+// S3Endpoint retrieves endpoint of S3 compatible storage, e.g. http://localhost:9000
+func S3Endpoint() string {
+	return GetEnv("S3_ENDPOINT")
+}
+
+// S3Region retrieves region of S3 bucket
+func S3Region() string {
+	return GetEnv("S3_REGION")
+}
+
+// S3Bucket retrieves bucket name to save chunk and final objects
+func S3Bucket() string {
+	return GetEnv("S3_BUCKET")
+}
+
+// S3AccessKey retrieves access key of S3 compatible storage
+func S3AccessKey() string {
+	return GetEnv("S3_ACCESS_KEY")
+}
+
+// S3SecretKey retrieves secret key of S3 compatible storage
+func S3SecretKey() string {
+	return GetEnv("S3_SECRET_KEY")
+}
+
+// MaxChunkSize retrieves max size in bytes of one chunk request body
+func MaxChunkSize() int64 {
+	if val := GetEnv("MAX_CHUNK_SIZE"); val != "" {
+		if size, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return size
+		}
+	}
+
+	// default max chunk size, 32 MiB
+	return 32 << 20
+}
+
+// MaxDecompressionRatio retrieves max ratio of decoded to encoded size of compressed chunk, 0 means unlimited
+func MaxDecompressionRatio() int64 {
+	if val := GetEnv("MAX_DECOMPRESSION_RATIO"); val != "" {
+		if ratio, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return ratio
+		}
+	}
+
+	// default max ratio
+	return 100
+}
+
+// JanitorTTL retrieves how long unfinished upload is kept since its last chunk received
+func JanitorTTL() time.Duration {
+	if val := GetEnv("JANITOR_TTL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+
+	// default ttl
+	return 24 * time.Hour
+}
+
+// JanitorInterval retrieves how often janitor looks for abandoned uploads
+func JanitorInterval() time.Duration {
+	if val := GetEnv("JANITOR_INTERVAL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+
+	// default interval
+	return time.Hour
+}
+
+// AuthEnabled retrieves whether every request must be authenticated
+func AuthEnabled() bool {
+	if val := GetEnv("AUTH_ENABLED"); val != "" {
+		if enabled, err := strconv.ParseBool(val); err == nil {
+			return enabled
+		}
+	}
+
+	// default enabled
+	return true
+}
+
+// AuthAPIKeys retrieves static API keys, comma separated "key=subject:scope|scope"
+func AuthAPIKeys() string {
+	return GetEnv("AUTH_API_KEYS")
+}
+
+// AuthJWKSFile retrieves path of JSON Web Key Set to verify HS256 and RS256 JWT
+func AuthJWKSFile() string {
+	return GetEnv("AUTH_JWKS_FILE")
+}
+
+// AuthJWTIssuer retrieves expected "iss" claim of JWT, empty means not checked
+func AuthJWTIssuer() string {
+	return GetEnv("AUTH_JWT_ISSUER")
+}
+
+// AuthJWTAudience retrieves expected "aud" claim of JWT, empty means not checked
+func AuthJWTAudience() string {
+	return GetEnv("AUTH_JWT_AUDIENCE")
+}
+
+// RateLimitChunksPerSecond retrieves chunk requests allowed per second for each client, 0 means unlimited
+func RateLimitChunksPerSecond() float64 {
+	if val := GetEnv("RATE_LIMIT_CHUNKS_PER_SECOND"); val != "" {
+		if rate, err := strconv.ParseFloat(val, 64); err == nil {
+			return rate
+		}
+	}
+
+	// default rate
+	return 50
+}
+
+// RateLimitChunkBurst retrieves chunk requests allowed at once for each client
+func RateLimitChunkBurst() int {
+	if val := GetEnv("RATE_LIMIT_CHUNK_BURST"); val != "" {
+		if burst, err := strconv.Atoi(val); err == nil {
+			return burst
+		}
+	}
+
+	// default burst
+	return 100
+}
+
+// RateLimitBytesPerSecond retrieves uploaded bytes allowed per second for each client, 0 means unlimited
+func RateLimitBytesPerSecond() int64 {
+	if val := GetEnv("RATE_LIMIT_BYTES_PER_SECOND"); val != "" {
+		if rate, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return rate
+		}
+	}
+
+	// default rate, 100 MiB
+	return 100 << 20
+}
+
+// RateLimitConcurrentUploads retrieves upload sessions each client may keep open until completed or aborted, 0 means unlimited
+func RateLimitConcurrentUploads() int {
+	if val := GetEnv("RATE_LIMIT_CONCURRENT_UPLOADS"); val != "" {
+		if concurrent, err := strconv.Atoi(val); err == nil {
+			return concurrent
+		}
+	}
+
+	// default concurrent uploads
+	return 16
+}
+
+// UploadAllowedTypes retrieves comma separated content types allowed to upload, e.g. "image/*,application/pdf". empty allows all
+func UploadAllowedTypes() string {
+	return GetEnv("UPLOAD_ALLOWED_TYPES")
+}
+
+// UploadDeniedTypes retrieves comma separated content types never allowed to upload
+func UploadDeniedTypes() string {
+	return GetEnv("UPLOAD_DENIED_TYPES")
+}
+
+// EncryptionEnabled retrieves whether chunks and final files are encrypted at rest
+func EncryptionEnabled() bool {
+	if val := GetEnv("ENCRYPTION_ENABLED"); val != "" {
+		if enabled, err := strconv.ParseBool(val); err == nil {
+			return enabled
+		}
+	}
+
+	// default disabled
+	return false
+}
+
+// EncryptionKeyProvider retrieves key provider of master keys wrapping data keys. local only
+func EncryptionKeyProvider() string {
+	return GetEnv("ENCRYPTION_KEY_PROVIDER")
+}
+
+// EncryptionKeyFile retrieves json key file of local key provider
+func EncryptionKeyFile() string {
+	if val := GetEnv("ENCRYPTION_KEY_FILE"); val != "" {
+		return val
+	}
+
+	// default key file
+	return "./keys.json"
+}
+
+// FilenameMaxLength retrieves max length of filename in bytes, 0 means unlimited
+func FilenameMaxLength() int {
+	if val := GetEnv("FILENAME_MAX_LENGTH"); val != "" {
+		if length, err := strconv.Atoi(val); err == nil {
+			return length
+		}
+	}
+
+	// default length, max file name length of most file systems
+	return 255
+}
+
+// FilenameAllowedPattern retrieves regex every filename must match, empty allows all
+func FilenameAllowedPattern() string {
+	return GetEnv("FILENAME_ALLOWED_PATTERN")
+}
+
+// FilenameDeniedPattern retrieves regex no filename may match
+func FilenameDeniedPattern() string {
+	return GetEnv("FILENAME_DENIED_PATTERN")
+}
+
+// WebhookURLs retrieves comma separated URLs receiving upload events, empty disables webhooks
+func WebhookURLs() string {
+	return GetEnv("WEBHOOK_URLS")
+}
+
+// WebhookSecret retrieves secret signing body of webhook requests
+func WebhookSecret() string {
+	return GetEnv("WEBHOOK_SECRET")
+}
+
+// WebhookQueueFolder retrieves path folder to keep pending deliveries and dead letter log
+func WebhookQueueFolder() string {
+	if val := GetEnv("WEBHOOK_QUEUE_FOLDER"); val != "" {
+		return val
+	}
+
+	// default folder
+	return "./upload/webhook"
+}
+
+// WebhookMaxAttempts retrieves how many times delivery is attempted before it is dead-lettered
+func WebhookMaxAttempts() int {
+	if val := GetEnv("WEBHOOK_MAX_ATTEMPTS"); val != "" {
+		if attempts, err := strconv.Atoi(val); err == nil && attempts > 0 {
+			return attempts
+		}
+	}
+
+	// default attempts, about 8 minutes of retries
+	return 10
+}
+
+// WebhookTimeout retrieves how long one webhook request may take
+func WebhookTimeout() time.Duration {
+	if val := GetEnv("WEBHOOK_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+
+	// default timeout
+	return 10 * time.Second
+}
+
+// Processors retrieves comma separated processors run on final files, e.g. "thumbnail". empty disables processing
+func Processors() string {
+	return GetEnv("PROCESSORS")
+}
+
+// ProcessingWorkers retrieves how many final files are processed at once
+func ProcessingWorkers() int {
+	if val := GetEnv("PROCESSING_WORKERS"); val != "" {
+		if workers, err := strconv.Atoi(val); err == nil && workers > 0 {
+			return workers
+		}
+	}
+
+	// default workers
+	return 2
+}
+
+// ProcessingQueueSize retrieves how many final files may wait for worker, file beyond it stays pending until next run
+func ProcessingQueueSize() int {
+	if val := GetEnv("PROCESSING_QUEUE_SIZE"); val != "" {
+		if size, err := strconv.Atoi(val); err == nil && size >= 0 {
+			return size
+		}
+	}
+
+	// default queue size
+	return 100
+}
+
+// ThumbnailSizes retrieves comma separated max width and height of thumbnails
+func ThumbnailSizes() []int {
+	if val := GetEnv("THUMBNAIL_SIZES"); val != "" {
+		var sizes []int
+		for _, s := range strings.Split(val, ",") {
+			size, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || size <= 0 {
+				sizes = nil
+				break
+			}
+
+			sizes = append(sizes, size)
+		}
+
+		if len(sizes) > 0 {
+			return sizes
+		}
+	}
+
+	// default sizes
+	return []int{128, 512}
+}
+
+// ThumbnailMaxPixels retrieves max width x height of image decoded for thumbnails, 0 means unlimited
+func ThumbnailMaxPixels() int64 {
+	if val := GetEnv("THUMBNAIL_MAX_PIXELS"); val != "" {
+		if pixels, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return pixels
+		}
+	}
+
+	// default max pixels, decoded image takes 4 bytes per pixel
+	return 40_000_000
+}
+
+// Scanner retrieves malware scanner of final files, clamd only. empty disables scanning
+func Scanner() string {
+	return GetEnv("SCANNER")
+}
+
+// ClamdAddress retrieves address of clamd, "tcp://host:port" or "unix:///path/to/clamd.sock"
+func ClamdAddress() string {
+	if val := GetEnv("CLAMD_ADDRESS"); val != "" {
+		return val
+	}
+
+	// default address
+	return "tcp://127.0.0.1:3310"
+}
+
+// ClamdTimeout retrieves how long clamd may take to accept content or reply verdict
+func ClamdTimeout() time.Duration {
+	if val := GetEnv("CLAMD_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+
+	// default timeout
+	return time.Minute
+}
+
+// ScanTimeout retrieves how long scan of one final file may take, its upload can't be aborted meanwhile
+func ScanTimeout() time.Duration {
+	if val := GetEnv("SCAN_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+
+	// default timeout
+	return 5 * time.Minute
+}
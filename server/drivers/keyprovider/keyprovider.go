@@ -0,0 +1,17 @@
+package keyprovider
+
+import (
+	"fmt"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/internal/entity"
+)
+
+// NewKeyProvider creates key provider implementation selected by given provider
+func NewKeyProvider(provider string) (entity.KeyProvider, error) {
+	switch provider {
+	case "", "local":
+		return NewLocalKeyProvider(config.EncryptionKeyFile())
+	default:
+		return nil, fmt.Errorf("unknown key provider [%s]", provider)
+	}
+}
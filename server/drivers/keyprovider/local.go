@@ -0,0 +1,231 @@
+package keyprovider
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	keyAlgorithm = "AES-256-GCM"
+	keySize      = 32
+)
+
+// keyFile is content of local key file, every master key ever used is kept so old data keys can be unwrapped
+type keyFile struct {
+	CurrentKeyID string            `json:"current_key_id"`
+	Keys         map[string][]byte `json:"keys"`
+}
+
+type localKeyProvider struct {
+	mu       sync.RWMutex
+	filePath string
+	keys     keyFile
+}
+
+// NewLocalKeyProvider creates new instance of localKeyProvider. it implements from interface KeyProvider.
+// master keys are read from json key file, missing key file has no key until one generated
+func NewLocalKeyProvider(filePath string) (entity.KeyProvider, error) {
+	provider := &localKeyProvider{filePath: filePath}
+	if err := provider.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+// CurrentKeyID retrieves ID of master key wrapping new data keys
+func (l *localKeyProvider) CurrentKeyID() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.keys.CurrentKeyID
+}
+
+// WrapKey wraps data key with current master key
+func (l *localKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (*entity.DataKey, error) {
+	keyID := l.CurrentKeyID()
+	if keyID == "" {
+		return nil, fmt.Errorf("no master key in key file %s", l.filePath)
+	}
+
+	aead, err := l.cipher(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &entity.DataKey{
+		KeyID:      keyID,
+		Algorithm:  keyAlgorithm,
+		WrappedKey: aead.Seal(nonce, nonce, dataKey, []byte(keyID)),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}, nil
+}
+
+// UnwrapKey unwraps data key with master key it was wrapped by
+func (l *localKeyProvider) UnwrapKey(ctx context.Context, dataKey *entity.DataKey) ([]byte, error) {
+	if dataKey.Algorithm != keyAlgorithm {
+		return nil, fmt.Errorf("unsupported key algorithm [%s]", dataKey.Algorithm)
+	}
+
+	aead, err := l.cipher(dataKey.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dataKey.WrappedKey) < aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+
+	nonce, wrapped := dataKey.WrappedKey[:aead.NonceSize()], dataKey.WrappedKey[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, wrapped, []byte(dataKey.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed unwrap data key by master key [%s]", dataKey.KeyID)
+	}
+
+	return key, nil
+}
+
+// GenerateKey adds new random master key to key file and makes it current, older keys are kept
+func (l *localKeyProvider) GenerateKey(ctx context.Context) (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keyID := uuid.NewString()
+	keys := keyFile{
+		CurrentKeyID: keyID,
+		Keys:         map[string][]byte{keyID: key},
+	}
+
+	for id, key := range l.keys.Keys {
+		keys.Keys[id] = key
+	}
+
+	content, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// write temp file then rename it, so key file is never half written
+	if err = os.MkdirAll(filepath.Dir(l.filePath), 0700); err != nil {
+		return "", err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(l.filePath), ".keys-*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+
+	if _, err = tempFile.Write(content); err != nil {
+		return "", err
+	}
+
+	if err = tempFile.Sync(); err != nil {
+		return "", err
+	}
+
+	if err = tempFile.Close(); err != nil {
+		return "", err
+	}
+
+	if err = os.Rename(tempFile.Name(), l.filePath); err != nil {
+		return "", err
+	}
+
+	l.keys = keys
+	logrus.Infof("generate master key [%s] into %s 🔑", keyID, l.filePath)
+	return keyID, nil
+}
+
+// cipher creates AEAD of master key, key file is reloaded once if key is unknown, e.g. it was rotated by other process
+func (l *localKeyProvider) cipher(keyID string) (cipher.AEAD, error) {
+	l.mu.RLock()
+	key, ok := l.keys.Keys[keyID]
+	l.mu.RUnlock()
+
+	if !ok {
+		if err := l.load(); err != nil {
+			return nil, err
+		}
+
+		l.mu.RLock()
+		key, ok = l.keys.Keys[keyID]
+		l.mu.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("master key [%s] not found in key file %s", keyID, l.filePath)
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// load reads key file, it is rejected if it can be read by others
+func (l *localKeyProvider) load() error {
+	fileInfo, err := os.Stat(l.filePath)
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("key file %s is accessible by others, its mode must be 0600", l.filePath)
+	}
+
+	content, err := os.ReadFile(l.filePath)
+	if err != nil {
+		return err
+	}
+
+	var keys keyFile
+	if err = json.Unmarshal(content, &keys); err != nil {
+		return err
+	}
+
+	if _, ok := keys.Keys[keys.CurrentKeyID]; !ok && keys.CurrentKeyID != "" {
+		return fmt.Errorf("current master key [%s] not found in key file %s", keys.CurrentKeyID, l.filePath)
+	}
+
+	for keyID, key := range keys.Keys {
+		if len(key) != keySize {
+			return fmt.Errorf("master key [%s] must be %d bytes", keyID, keySize)
+		}
+	}
+
+	l.mu.Lock()
+	l.keys = keys
+	l.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,30 @@
+package processor
+
+import (
+	"fmt"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/internal/entity"
+	"strings"
+)
+
+// NewProcessors creates processor implementations selected by comma separated names, e.g. "thumbnail"
+func NewProcessors(names string) ([]entity.Processor, error) {
+	processors := []entity.Processor{}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+			continue
+		case "thumbnail":
+			processor, err := NewThumbnailProcessor(config.ThumbnailSizes(), config.ThumbnailMaxPixels())
+			if err != nil {
+				return nil, err
+			}
+
+			processors = append(processors, processor)
+		default:
+			return nil, fmt.Errorf("unknown processor [%s]", name)
+		}
+	}
+
+	return processors, nil
+}
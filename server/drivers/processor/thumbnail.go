@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"go-upload-chunk/server/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// thumbnailTypes are content types decoded by standard library
+var thumbnailTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+type thumbnailProcessor struct {
+	sizes     []int
+	maxPixels int64
+}
+
+// NewThumbnailProcessor creates new instance of thumbnailProcessor. it implements from interface Processor.
+// one thumbnail fitting in size x size is written for each size, image of more than maxPixels pixels is not decoded
+func NewThumbnailProcessor(sizes []int, maxPixels int64) (entity.Processor, error) {
+	if len(sizes) == 0 {
+		return nil, errors.New("thumbnail processor needs at least one size")
+	}
+
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid thumbnail size %d", size)
+		}
+	}
+
+	return &thumbnailProcessor{
+		sizes:     sizes,
+		maxPixels: maxPixels,
+	}, nil
+}
+
+func (t *thumbnailProcessor) Name() string {
+	return "thumbnail"
+}
+
+// Accepts accepts JPEG, PNG and GIF images
+func (t *thumbnailProcessor) Accepts(metadata *entity.FileMetadata) bool {
+	_, ok := thumbnailTypes[metadata.ContentType]
+	return ok
+}
+
+// Process decodes image and resizes it to every size. JPEG stays JPEG, PNG and GIF become PNG so transparency is kept.
+// first frame of animated GIF is used
+func (t *thumbnailProcessor) Process(ctx context.Context, metadata *entity.FileMetadata, content io.ReadSeeker) ([]entity.ProcessorOutput, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	// dimension is checked before decoding, small file may declare huge image
+	imageConfig, format, err := image.DecodeConfig(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if pixels := int64(imageConfig.Width) * int64(imageConfig.Height); t.maxPixels > 0 && pixels > t.maxPixels {
+		return nil, fmt.Errorf("image is %dx%d, more than %d pixels", imageConfig.Width, imageConfig.Height, t.maxPixels)
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var src image.Image
+	switch format {
+	case "jpeg":
+		src, err = jpeg.Decode(content)
+	case "png":
+		src, err = png.Decode(content)
+	case "gif":
+		src, err = gif.Decode(content)
+	default:
+		err = fmt.Errorf("unsupported image format %s", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.String("image.format", format),
+		attribute.Int("image.width", imageConfig.Width),
+		attribute.Int("image.height", imageConfig.Height),
+	)
+
+	outputs := make([]entity.ProcessorOutput, 0, len(t.sizes))
+	for _, size := range t.sizes {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		thumbnail := Resize(src, size)
+
+		var buf bytes.Buffer
+		output := entity.ProcessorOutput{}
+		if format == "jpeg" {
+			output.Name = fmt.Sprintf("thumbnail-%d.jpg", size)
+			output.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85})
+		} else {
+			output.Name = fmt.Sprintf("thumbnail-%d.png", size)
+			output.ContentType = "image/png"
+			err = png.Encode(&buf, thumbnail)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		output.Content = buf.Bytes()
+		outputs = append(outputs, output)
+	}
+
+	return outputs, nil
+}
+
+// Resize scales image down to fit in size x size keeping its aspect ratio, each pixel is average of source pixels it covers.
+// image already fitting keeps its dimension
+func Resize(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			dstWidth, dstHeight = size, max(height*size/width, 1)
+		} else {
+			dstWidth, dstHeight = max(width*size/height, 1), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for dy := 0; dy < dstHeight; dy++ {
+		y0 := bounds.Min.Y + dy*height/dstHeight
+		y1 := max(bounds.Min.Y+(dy+1)*height/dstHeight, y0+1)
+
+		for dx := 0; dx < dstWidth; dx++ {
+			x0 := bounds.Min.X + dx*width/dstWidth
+			x1 := max(bounds.Min.X+(dx+1)*width/dstWidth, x0+1)
+
+			// colors are alpha premultiplied, so average of them is premultiplied too
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := src.At(x, y).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,162 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"go-upload-chunk/server/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is max size of one chunk of INSTREAM command
+const clamdChunkSize = 64 * 1024
+
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates new instance of clamdScanner. it implements from interface Scanner.
+// address is "tcp://host:port" or "unix:///path/to/clamd.sock", timeout bounds every read and write of clamd
+func NewClamdScanner(address string, timeout time.Duration) (entity.Scanner, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clamd address [%s] : %w", address, err)
+	}
+
+	scanner := &clamdScanner{network: parsed.Scheme, timeout: timeout}
+	switch parsed.Scheme {
+	case "tcp":
+		scanner.address = parsed.Host
+	case "unix":
+		scanner.address = parsed.Path
+	default:
+		return nil, fmt.Errorf("invalid clamd address [%s], expected tcp://host:port or unix:///path", address)
+	}
+
+	return scanner, nil
+}
+
+func (c *clamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan streams content to clamd by INSTREAM command: each chunk is prefixed by its size as 4 bytes big endian,
+// zero size ends stream. clamd replies "stream: OK" or "stream: <signature> FOUND"
+func (c *clamdScanner) Scan(ctx context.Context, content io.Reader) (*entity.ScanVerdict, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	// connection is closed if caller gives up, so blocked read or write returns
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	write := func(p []byte) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		_, err := conn.Write(p)
+		return err
+	}
+
+	scanned, err := c.stream(content, write)
+	if err != nil {
+		// clamd closes stream which exceeds its StreamMaxLength, its reply tells why
+		if reply, replyErr := c.reply(conn); replyErr == nil && reply != "" {
+			return nil, fmt.Errorf("clamd : %s", reply)
+		}
+
+		return nil, err
+	}
+
+	reply, err := c.reply(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int64("scan.bytes", scanned),
+		attribute.String("scan.reply", reply),
+	)
+
+	verdict := &entity.ScanVerdict{
+		Scanner:   c.Name(),
+		ScannedAt: time.Now(),
+	}
+
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		verdict.Status = entity.ScanClean
+	case strings.HasSuffix(result, " FOUND"):
+		verdict.Status = entity.ScanInfected
+		verdict.Signature = strings.TrimSuffix(result, " FOUND")
+	default:
+		return nil, fmt.Errorf("clamd : %s", reply)
+	}
+
+	return verdict, nil
+}
+
+// stream sends INSTREAM command with content, returns number of bytes sent
+func (c *clamdScanner) stream(content io.Reader, write func(p []byte) error) (int64, error) {
+	if err := write([]byte("zINSTREAM\x00")); err != nil {
+		return 0, err
+	}
+
+	var (
+		scanned int64
+		buf     = make([]byte, 4+clamdChunkSize)
+	)
+
+	for {
+		n, err := io.ReadFull(content, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if err := write(buf[:4+n]); err != nil {
+				return scanned, err
+			}
+
+			scanned += int64(n)
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if err != nil {
+			return scanned, err
+		}
+	}
+
+	return scanned, write([]byte{0, 0, 0, 0})
+}
+
+// reply reads null terminated reply of clamd
+func (c *clamdScanner) reply(conn net.Conn) (string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(c.timeout))
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
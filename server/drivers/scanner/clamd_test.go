@@ -0,0 +1,230 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testEicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks INSTREAM command of clamd, it records chunks it received and replies by content
+type fakeClamd struct {
+	mu        sync.Mutex
+	commands  []string
+	chunks    []int
+	content   []byte
+	maxLength int  // reply size limit error once stream exceeds it, 0 is unlimited
+	silent    bool // never reply
+	reply     string
+}
+
+func newFakeClamd(t *testing.T, fake *fakeClamd) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go fake.serve(conn)
+		}
+	}()
+
+	return "tcp://" + listener.Addr().String()
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	f.commands = append(f.commands, command)
+	f.mu.Unlock()
+
+	var content []byte
+	for {
+		var size uint32
+		if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return
+		}
+
+		if size == 0 {
+			break
+		}
+
+		chunk := make([]byte, size)
+		if _, err = io.ReadFull(reader, chunk); err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.chunks = append(f.chunks, int(size))
+		f.mu.Unlock()
+
+		content = append(content, chunk...)
+		if f.maxLength > 0 && len(content) > f.maxLength {
+			// rest of stream is drained, so client reads reply rather than connection reset
+			_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			_, _ = io.Copy(io.Discard, reader)
+			return
+		}
+	}
+
+	f.mu.Lock()
+	f.content = content
+	f.mu.Unlock()
+
+	switch {
+	case f.silent:
+		_, _ = io.Copy(io.Discard, reader)
+	case f.reply != "":
+		_, _ = conn.Write([]byte(f.reply + "\x00"))
+	case bytes.Contains(content, []byte(testEicar)):
+		_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+	default:
+		_, _ = conn.Write([]byte("stream: OK\x00"))
+	}
+}
+
+func TestClamdScannerScan(t *testing.T) {
+	large := bytes.Repeat([]byte("clean content "), 15000)
+
+	tests := []struct {
+		name       string
+		fake       *fakeClamd
+		content    []byte
+		wantStatus string
+		signature  string
+		chunks     []int
+		wantErr    string
+	}{
+		{name: "clean", fake: &fakeClamd{}, content: []byte("clean content"), wantStatus: entity.ScanClean, chunks: []int{13}},
+		{name: "infected", fake: &fakeClamd{}, content: []byte(testEicar), wantStatus: entity.ScanInfected, signature: "Eicar-Test-Signature", chunks: []int{len(testEicar)}},
+		{name: "split into chunks", fake: &fakeClamd{}, content: large, wantStatus: entity.ScanClean, chunks: []int{clamdChunkSize, clamdChunkSize, clamdChunkSize, len(large) - 3*clamdChunkSize}},
+		{name: "empty", fake: &fakeClamd{}, content: nil, wantStatus: entity.ScanClean},
+		{name: "size limit of clamd", fake: &fakeClamd{maxLength: clamdChunkSize}, content: large, wantErr: "size limit exceeded"},
+		{name: "error reply", fake: &fakeClamd{reply: "stream: Can't allocate memory ERROR"}, content: []byte("content"), wantErr: "Can't allocate memory"},
+		{name: "no reply", fake: &fakeClamd{silent: true}, content: []byte("content"), wantErr: "timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner, err := NewClamdScanner(newFakeClamd(t, tt.fake), 200*time.Millisecond)
+			if err != nil {
+				t.Fatalf("NewClamdScanner() error = %v", err)
+			}
+
+			verdict, err := scanner.Scan(context.Background(), bytes.NewReader(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Scan() error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+
+			if verdict.Status != tt.wantStatus || verdict.Signature != tt.signature || verdict.Scanner != "clamd" {
+				t.Errorf("Scan() = %+v, want status %s signature %q", verdict, tt.wantStatus, tt.signature)
+			}
+
+			tt.fake.mu.Lock()
+			defer tt.fake.mu.Unlock()
+
+			if len(tt.fake.commands) != 1 || tt.fake.commands[0] != "zINSTREAM\x00" {
+				t.Errorf("commands = %q, want one zINSTREAM", tt.fake.commands)
+			}
+
+			if len(tt.fake.chunks) != len(tt.chunks) {
+				t.Fatalf("chunks = %v, want %v", tt.fake.chunks, tt.chunks)
+			}
+
+			for i := range tt.chunks {
+				if tt.fake.chunks[i] != tt.chunks[i] {
+					t.Errorf("chunks = %v, want %v", tt.fake.chunks, tt.chunks)
+				}
+			}
+
+			if !bytes.Equal(tt.fake.content, tt.content) {
+				t.Errorf("clamd received %d bytes, want %d bytes", len(tt.fake.content), len(tt.content))
+			}
+		})
+	}
+}
+
+func TestClamdScannerContextCanceled(t *testing.T) {
+	scanner, err := NewClamdScanner(newFakeClamd(t, &fakeClamd{silent: true}), time.Minute)
+	if err != nil {
+		t.Fatalf("NewClamdScanner() error = %v", err)
+	}
+
+	// caller giving up unblocks scan, whatever timeout of clamd
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err = scanner.Scan(ctx, strings.NewReader("content")); err == nil {
+		t.Fatalf("Scan() error = nil, want error of canceled scan")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Scan() returned after %s, want soon after context canceled", elapsed)
+	}
+}
+
+func TestNewClamdScanner(t *testing.T) {
+	tests := []struct {
+		address     string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{address: "tcp://localhost:3310", wantNetwork: "tcp", wantAddress: "localhost:3310"},
+		{address: "unix:///var/run/clamav/clamd.ctl", wantNetwork: "unix", wantAddress: "/var/run/clamav/clamd.ctl"},
+		{address: "localhost:3310", wantErr: true},
+		{address: "http://localhost:3310", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			scanner, err := NewClamdScanner(tt.address, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClamdScanner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if clamd := scanner.(*clamdScanner); clamd.network != tt.wantNetwork || clamd.address != tt.wantAddress {
+				t.Errorf("NewClamdScanner() = %s %s, want %s %s", clamd.network, clamd.address, tt.wantNetwork, tt.wantAddress)
+			}
+		})
+	}
+}
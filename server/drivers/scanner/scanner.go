@@ -0,0 +1,17 @@
+package scanner
+
+import (
+	"fmt"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/internal/entity"
+)
+
+// NewScanner creates scanner implementation selected by given driver
+func NewScanner(driver string) (entity.Scanner, error) {
+	switch driver {
+	case "clamd":
+		return NewClamdScanner(config.ClamdAddress(), config.ClamdTimeout())
+	default:
+		return nil, fmt.Errorf("unknown scanner [%s]", driver)
+	}
+}
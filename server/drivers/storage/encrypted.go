@@ -0,0 +1,507 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/utils"
+	"io"
+	"os"
+)
+
+// dataKeyName is metadata name of wrapped data key, both of upload and of blob
+const dataKeyName = "key.json"
+
+type encryptedStorage struct {
+	entity.Storage
+	keyProvider entity.KeyProvider
+	keyLocks    *utils.KeyedMutex
+}
+
+// NewEncryptedStorage creates new instance of encryptedStorage. it implements from interface EncryptedStorage.
+// chunks of each upload and each blob are encrypted by its own data key, stored wrapped by master key as metadata.
+// upload or blob without data key was stored before encryption enabled, it is read as plaintext
+func NewEncryptedStorage(storage entity.Storage, keyProvider entity.KeyProvider) entity.EncryptedStorage {
+	return &encryptedStorage{
+		Storage:     storage,
+		keyProvider: keyProvider,
+		keyLocks:    utils.NewKeyedMutex(),
+	}
+}
+
+// PutChunk encrypts content of chunk, data key of upload is created along with its first chunk
+func (e *encryptedStorage) PutChunk(ctx context.Context, uploadID string, chunkIndex int, content io.Reader) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	dataKey, err := e.uploadKey(ctx, uploadID, true)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if dataKey == nil {
+		return e.Storage.PutChunk(ctx, uploadID, chunkIndex, content)
+	}
+
+	counter := &countingReader{reader: content}
+	if _, err = e.Storage.PutChunk(ctx, uploadID, chunkIndex, utils.NewEncryptReader(counter, dataKey)); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	return counter.n, nil
+}
+
+// ListChunks lists chunks of upload with their plaintext size
+func (e *encryptedStorage) ListChunks(ctx context.Context, uploadID string) ([]entity.ChunkInfo, error) {
+	chunks, err := e.Storage.ListChunks(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := e.uploadKey(ctx, uploadID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataKey != nil {
+		for i := range chunks {
+			chunks[i].Size = utils.DecryptedSize(chunks[i].Size)
+		}
+	}
+
+	return chunks, nil
+}
+
+// OpenChunk opens chunk for reading its plaintext
+func (e *encryptedStorage) OpenChunk(ctx context.Context, uploadID string, chunkIndex int) (io.ReadCloser, error) {
+	dataKey, err := e.uploadKey(ctx, uploadID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := e.Storage.OpenChunk(ctx, uploadID, chunkIndex)
+	if err != nil || dataKey == nil {
+		return chunk, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{utils.NewDecryptReader(chunk, dataKey), chunk}, nil
+}
+
+// ComposeBlob combines plaintext of chunks into one blob encrypted by new data key. every plaintext byte is also written to w
+func (e *encryptedStorage) ComposeBlob(ctx context.Context, uploadID string, totalChunk int, w io.Writer, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	chunks, err := e.ListChunks(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	var size int64
+	for _, chunk := range chunks {
+		if chunk.Index < totalChunk {
+			size += chunk.Size
+		}
+	}
+
+	content := &chunksReader{
+		ctx:        ctx,
+		storage:    e,
+		uploadID:   uploadID,
+		totalChunk: totalChunk,
+	}
+
+	defer content.Close()
+
+	return e.PutBlob(ctx, io.TeeReader(content, w), size, validate)
+}
+
+// PutBlob encrypts content of given plaintext size into blob by new data key, blob of same checksum already stored is kept
+func (e *encryptedStorage) PutBlob(ctx context.Context, content io.Reader, size int64, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	dataKey, wrappedKey, err := e.newKey(ctx)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// data key is recorded once checksum is known, only if blob is not stored yet
+	_, err = e.Storage.PutBlob(ctx, utils.NewEncryptReader(content, dataKey), utils.EncryptedSize(size), func() (string, error) {
+		checksum, err := validate()
+		if err != nil {
+			return "", err
+		}
+
+		if _, err = e.Storage.StatBlob(ctx, checksum); err == nil {
+			return checksum, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		return checksum, e.Storage.PutBlobMetadata(ctx, checksum, dataKeyName, wrappedKey)
+	})
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// StatBlob retrieves plaintext size and modification time of blob
+func (e *encryptedStorage) StatBlob(ctx context.Context, checksum string) (*entity.FileInfo, error) {
+	fileInfo, err := e.Storage.StatBlob(ctx, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := e.blobKey(ctx, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataKey != nil {
+		fileInfo.Size = utils.DecryptedSize(fileInfo.Size)
+	}
+
+	return fileInfo, nil
+}
+
+// OpenBlob opens blob for reading its plaintext, any range is decrypted without reading content before it
+func (e *encryptedStorage) OpenBlob(ctx context.Context, checksum string) (io.ReadSeekCloser, error) {
+	dataKey, err := e.blobKey(ctx, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := e.Storage.OpenBlob(ctx, checksum)
+	if err != nil || dataKey == nil {
+		return blob, err
+	}
+
+	reader, err := utils.NewDecryptReadSeeker(blob, dataKey)
+	if err != nil {
+		_ = blob.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{reader, blob}, nil
+}
+
+// PutBlobFile encrypts file derived from blob by data key of blob, so it reveals no more than blob itself.
+// file of plaintext blob is stored as is
+func (e *encryptedStorage) PutBlobFile(ctx context.Context, checksum, name string, content []byte) error {
+	dataKey, err := e.blobKey(ctx, checksum)
+	if err != nil {
+		return err
+	}
+
+	if dataKey == nil {
+		return e.Storage.PutBlobFile(ctx, checksum, name, content)
+	}
+
+	encrypted, err := io.ReadAll(utils.NewEncryptReader(bytes.NewReader(content), dataKey))
+	if err != nil {
+		return err
+	}
+
+	return e.Storage.PutBlobFile(ctx, checksum, name, encrypted)
+}
+
+// GetBlobFile retrieves plaintext of file derived from blob
+func (e *encryptedStorage) GetBlobFile(ctx context.Context, checksum, name string) ([]byte, error) {
+	dataKey, err := e.blobKey(ctx, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := e.Storage.GetBlobFile(ctx, checksum, name)
+	if err != nil || dataKey == nil {
+		return content, err
+	}
+
+	return io.ReadAll(utils.NewDecryptReader(bytes.NewReader(content), dataKey))
+}
+
+// RotateKeys re-wraps data keys of uploads, blobs and quarantined blobs not wrapped by current master key,
+// content encrypted by data keys is never rewritten
+func (e *encryptedStorage) RotateKeys(ctx context.Context) (int, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	uploadIDs, err := e.Storage.ListUploads(ctx)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	checksums, err := e.Storage.ListBlobs(ctx)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// quarantined blob is kept for investigation, it must stay readable once old master key is retired
+	quarantined, err := e.Storage.ListQuarantined(ctx)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	var rotated int
+	for _, uploadID := range uploadIDs {
+		ok, err := e.rewrapKey(ctx, func() ([]byte, error) {
+			return e.Storage.GetMetadata(ctx, uploadID, dataKeyName)
+		}, func(content []byte) error {
+			return e.Storage.PutMetadata(ctx, uploadID, dataKeyName, content)
+		})
+		if err != nil {
+			logger.Errorf("failed rotate data key of upload [%s] : %s", uploadID, err.Error())
+			return rotated, err
+		}
+
+		if ok {
+			rotated++
+		}
+	}
+
+	for _, checksum := range checksums {
+		ok, err := e.rewrapKey(ctx, func() ([]byte, error) {
+			return e.Storage.GetBlobMetadata(ctx, checksum, dataKeyName)
+		}, func(content []byte) error {
+			return e.Storage.PutBlobMetadata(ctx, checksum, dataKeyName, content)
+		})
+		if err != nil {
+			logger.Errorf("failed rotate data key of blob %s : %s", checksum, err.Error())
+			return rotated, err
+		}
+
+		if ok {
+			rotated++
+		}
+	}
+
+	for _, checksum := range quarantined {
+		ok, err := e.rewrapKey(ctx, func() ([]byte, error) {
+			return e.Storage.GetQuarantineMetadata(ctx, checksum, dataKeyName)
+		}, func(content []byte) error {
+			return e.Storage.PutQuarantineMetadata(ctx, checksum, dataKeyName, content)
+		})
+		if err != nil {
+			logger.Errorf("failed rotate data key of quarantined blob %s : %s", checksum, err.Error())
+			return rotated, err
+		}
+
+		if ok {
+			rotated++
+		}
+	}
+
+	logger.Infof("rotate %d data keys to master key [%s] 🔑", rotated, e.keyProvider.CurrentKeyID())
+	return rotated, nil
+}
+
+// rewrapKey wraps data key by current master key, data key missing or already wrapped by it is kept
+func (e *encryptedStorage) rewrapKey(ctx context.Context, get func() ([]byte, error), put func(content []byte) error) (bool, error) {
+	content, err := get()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	var wrappedKey entity.DataKey
+	if err = json.Unmarshal(content, &wrappedKey); err != nil {
+		return false, err
+	}
+
+	if wrappedKey.KeyID == e.keyProvider.CurrentKeyID() {
+		return false, nil
+	}
+
+	dataKey, err := e.keyProvider.UnwrapKey(ctx, &wrappedKey)
+	if err != nil {
+		return false, err
+	}
+
+	rewrapped, err := e.keyProvider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	rewrapped.CreatedAt = wrappedKey.CreatedAt
+	if content, err = json.Marshal(rewrapped); err != nil {
+		return false, err
+	}
+
+	return true, put(content)
+}
+
+// uploadKey retrieves data key of upload, nil if upload is plaintext. create makes data key of upload without any chunk
+func (e *encryptedStorage) uploadKey(ctx context.Context, uploadID string, create bool) ([]byte, error) {
+	if create {
+		unlock := e.keyLocks.Lock(uploadID)
+		defer unlock()
+	}
+
+	content, err := e.Storage.GetMetadata(ctx, uploadID, dataKeyName)
+	if err == nil {
+		return e.unwrapKey(ctx, content)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	// upload having plaintext chunks stays plaintext, so its chunks are never mixed
+	chunks, err := e.Storage.ListChunks(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) > 0 {
+		return nil, nil
+	}
+
+	dataKey, wrappedKey, err := e.newKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = e.Storage.PutMetadata(ctx, uploadID, dataKeyName, wrappedKey); err != nil {
+		return nil, err
+	}
+
+	return dataKey, nil
+}
+
+// blobKey retrieves data key of blob, nil if blob is plaintext
+func (e *encryptedStorage) blobKey(ctx context.Context, checksum string) ([]byte, error) {
+	content, err := e.Storage.GetBlobMetadata(ctx, checksum, dataKeyName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return e.unwrapKey(ctx, content)
+}
+
+// newKey generates data key and wraps it by current master key
+func (e *encryptedStorage) newKey(ctx context.Context) ([]byte, []byte, error) {
+	dataKey, err := utils.NewDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrappedKey, err := e.keyProvider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := json.Marshal(wrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dataKey, content, nil
+}
+
+func (e *encryptedStorage) unwrapKey(ctx context.Context, content []byte) ([]byte, error) {
+	var wrappedKey entity.DataKey
+	if err := json.Unmarshal(content, &wrappedKey); err != nil {
+		return nil, err
+	}
+
+	return e.keyProvider.UnwrapKey(ctx, &wrappedKey)
+}
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// chunksReader reads plaintext of chunks in order, each chunk is opened once previous one is read
+type chunksReader struct {
+	ctx        context.Context
+	storage    entity.Storage
+	uploadID   string
+	totalChunk int
+	index      int
+	chunk      io.ReadCloser
+}
+
+func (c *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if c.chunk == nil {
+			if c.index >= c.totalChunk {
+				return 0, io.EOF
+			}
+
+			chunk, err := c.storage.OpenChunk(c.ctx, c.uploadID, c.index)
+			if err != nil {
+				return 0, err
+			}
+
+			c.chunk = chunk
+		}
+
+		n, err := c.chunk.Read(p)
+		if errors.Is(err, io.EOF) {
+			_ = c.chunk.Close()
+			c.chunk = nil
+			c.index++
+			err = nil
+		}
+
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (c *chunksReader) Close() error {
+	if c.chunk == nil {
+		return nil
+	}
+
+	return c.chunk.Close()
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"go-upload-chunk/server/drivers/keyprovider"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestEncryptedStorage creates encrypted storage over memory storage, master key is in key file of temp folder
+func newTestEncryptedStorage(t *testing.T) (entity.EncryptedStorage, entity.Storage, entity.KeyProvider, string) {
+	t.Helper()
+
+	keyFilePath := filepath.Join(t.TempDir(), "keys.json")
+	keyProvider, err := keyprovider.NewLocalKeyProvider(keyFilePath)
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider() error = %v", err)
+	}
+
+	if _, err = keyProvider.GenerateKey(context.Background()); err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	storage := NewMemoryStorage()
+	return NewEncryptedStorage(storage, keyProvider), storage, keyProvider, keyFilePath
+}
+
+func readBlob(t *testing.T, storage entity.Storage, checksum string) string {
+	t.Helper()
+
+	blob, err := storage.OpenBlob(context.Background(), checksum)
+	if err != nil {
+		t.Fatalf("OpenBlob() error = %v", err)
+	}
+
+	defer blob.Close()
+
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("read blob error = %v", err)
+	}
+
+	return string(content)
+}
+
+func TestEncryptedStorageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{name: "one chunk", chunks: []string{"secret content of one chunk"}},
+		{name: "many chunks", chunks: []string{"secret ", "content ", "of many chunks"}},
+		{name: "large chunk", chunks: []string{strings.Repeat("secret content ", 10000), "tail"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			encrypted, storage, _, _ := newTestEncryptedStorage(t)
+			want := strings.Join(tt.chunks, "")
+
+			checksum, err := composeBlob(ctx, encrypted, "upload-1", tt.chunks)
+			if err != nil {
+				t.Fatalf("ComposeBlob() error = %v", err)
+			}
+
+			// blob is named by checksum of plaintext, but stored encrypted
+			raw := readBlob(t, storage, checksum)
+			if strings.Contains(raw, "secret") {
+				t.Errorf("stored blob contains plaintext")
+			}
+
+			if got := readBlob(t, encrypted, checksum); got != want {
+				t.Errorf("OpenBlob() = %d bytes, want %d bytes", len(got), len(want))
+			}
+
+			fileInfo, err := encrypted.StatBlob(ctx, checksum)
+			if err != nil || fileInfo.Size != int64(len(want)) {
+				t.Errorf("StatBlob() = %+v, %v, want plaintext size %d", fileInfo, err, len(want))
+			}
+		})
+	}
+}
+
+func TestEncryptedStoragePlaintextUpload(t *testing.T) {
+	ctx := context.Background()
+	encrypted, storage, _, _ := newTestEncryptedStorage(t)
+
+	// chunk stored before encryption enabled keeps its upload plaintext
+	if _, err := storage.PutChunk(ctx, "upload-1", 0, strings.NewReader("plain ")); err != nil {
+		t.Fatalf("PutChunk() error = %v", err)
+	}
+
+	checksum, err := composeBlob(ctx, encrypted, "upload-1", []string{"plain ", "content"})
+	if err != nil {
+		t.Fatalf("ComposeBlob() error = %v", err)
+	}
+
+	if got := readBlob(t, encrypted, checksum); got != "plain content" {
+		t.Errorf("OpenBlob() = %q, want %q", got, "plain content")
+	}
+}
+
+func TestEncryptedStorageRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	encrypted, storage, keyProvider, keyFilePath := newTestEncryptedStorage(t)
+
+	checksum, err := composeBlob(ctx, encrypted, "upload-1", []string{"secret content"})
+	if err != nil {
+		t.Fatalf("ComposeBlob() error = %v", err)
+	}
+
+	rawBlob := readBlob(t, storage, checksum)
+
+	// quarantined blob keeps its data key, it is wrapped by old master key until rotated
+	quarantined, err := composeBlob(ctx, encrypted, "upload-2", []string{"infected content"})
+	if err != nil {
+		t.Fatalf("ComposeBlob() error = %v", err)
+	}
+
+	if err = encrypted.QuarantineBlob(ctx, quarantined); err != nil {
+		t.Fatalf("QuarantineBlob() error = %v", err)
+	}
+
+	newKeyID, err := keyProvider.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		rotated int
+	}{
+		// data keys of both uploads, of blob and of quarantined blob
+		{name: "keys of old master key", rotated: 4},
+		{name: "keys already rotated", rotated: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotated, err := encrypted.RotateKeys(ctx)
+			if err != nil || rotated != tt.rotated {
+				t.Fatalf("RotateKeys() = %d, %v, want %d", rotated, err, tt.rotated)
+			}
+
+			for name, get := range map[string]func() ([]byte, error){
+				"upload": func() ([]byte, error) { return storage.GetMetadata(ctx, "upload-1", dataKeyName) },
+				"blob":   func() ([]byte, error) { return storage.GetBlobMetadata(ctx, checksum, dataKeyName) },
+				"quarantined blob": func() ([]byte, error) {
+					return storage.GetQuarantineMetadata(ctx, quarantined, dataKeyName)
+				},
+			} {
+				content, err := get()
+				if err != nil {
+					t.Fatalf("data key of %s error = %v", name, err)
+				}
+
+				var dataKey entity.DataKey
+				if err = json.Unmarshal(content, &dataKey); err != nil || dataKey.KeyID != newKeyID {
+					t.Errorf("data key of %s is wrapped by [%s], want [%s]", name, dataKey.KeyID, newKeyID)
+				}
+			}
+
+			// only data keys are re-wrapped, content is never rewritten
+			if readBlob(t, storage, checksum) != rawBlob {
+				t.Errorf("stored blob changed by rotation")
+			}
+
+			if got := readBlob(t, encrypted, checksum); got != "secret content" {
+				t.Errorf("OpenBlob() after rotation = %q, want %q", got, "secret content")
+			}
+		})
+	}
+
+	// other process reading same key file unwraps rotated data keys
+	reloaded, err := keyprovider.NewLocalKeyProvider(keyFilePath)
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider() error = %v", err)
+	}
+
+	if got := readBlob(t, NewEncryptedStorage(storage, reloaded), checksum); got != "secret content" {
+		t.Errorf("OpenBlob() by reloaded key provider = %q, want %q", got, "secret content")
+	}
+}
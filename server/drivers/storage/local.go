@@ -0,0 +1,692 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const chunkPrefix = "chunk-"
+
+type localStorage struct {
+	chunkFolder string
+	finalFolder string
+}
+
+// NewLocalStorage creates new instance of localStorage. it implements from interface Storage
+func NewLocalStorage(chunkFolder, finalFolder string) entity.Storage {
+	return &localStorage{
+		chunkFolder: chunkFolder,
+		finalFolder: finalFolder,
+	}
+}
+
+// ListUploads lists upload IDs which have chunk folder
+func (l *localStorage) ListUploads(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(l.chunkFolder)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	uploadIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			uploadIDs = append(uploadIDs, entry.Name())
+		}
+	}
+
+	return uploadIDs, nil
+}
+
+// PutMetadata writes metadata file into chunk folder of upload
+func (l *localStorage) PutMetadata(ctx context.Context, uploadID, name string, content []byte) error {
+	return l.WriteFile(ctx, l.uploadChunkFolder(uploadID), name, content)
+}
+
+// GetMetadata reads metadata file from chunk folder of upload
+func (l *localStorage) GetMetadata(ctx context.Context, uploadID, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(l.uploadChunkFolder(uploadID), name))
+}
+
+// PutChunk creates new chunk file
+func (l *localStorage) PutChunk(ctx context.Context, uploadID string, chunkIndex int, content io.Reader) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// check local folder chunk
+	if err := l.CheckAndCreateFolder(ctx, l.uploadChunkFolder(uploadID)); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// write content to temp file first, so incomplete or invalid chunk is never visible as chunk file
+	chunkFilePath := l.chunkFilePath(uploadID, chunkIndex)
+	tempFile, err := os.CreateTemp(l.uploadChunkFolder(uploadID), fmt.Sprintf(".%s%d-*.tmp", chunkPrefix, chunkIndex))
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// don't forget to close and remove temp file at the end, removing renamed temp file is no-op
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+
+	// stream content to temp file
+	written, err := io.Copy(tempFile, content)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// sync temp file
+	if err = tempFile.Sync(); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = tempFile.Close(); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// rename temp file to chunk file
+	if err = os.Rename(tempFile.Name(), chunkFilePath); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	logger.Infof("success create chunk file [%s] 🗳️", chunkFilePath)
+	return written, nil
+}
+
+// ListChunks lists chunk files of upload ordered by chunk index
+func (l *localStorage) ListChunks(ctx context.Context, uploadID string) ([]entity.ChunkInfo, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// find all chunk files of this upload
+	matchFiles, err := filepath.Glob(filepath.Join(l.uploadChunkFolder(uploadID), chunkPrefix+"*"))
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	chunks := make([]entity.ChunkInfo, 0, len(matchFiles))
+	for _, matchFile := range matchFiles {
+		chunkIndex, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(matchFile), chunkPrefix))
+		if err != nil {
+			continue
+		}
+
+		fileInfo, err := os.Stat(matchFile)
+		if err != nil {
+			continue
+		}
+
+		chunks = append(chunks, entity.ChunkInfo{
+			Index:   chunkIndex,
+			Size:    fileInfo.Size(),
+			ModTime: fileInfo.ModTime(),
+		})
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Index < chunks[j].Index
+	})
+
+	return chunks, nil
+}
+
+// OpenChunk opens chunk file for reading
+func (l *localStorage) OpenChunk(ctx context.Context, uploadID string, chunkIndex int) (io.ReadCloser, error) {
+	return os.Open(l.chunkFilePath(uploadID, chunkIndex))
+}
+
+// ComposeBlob combines chunk files of upload into one blob. every byte written to blob is also written to w.
+// blob is written to temp file first, validate returns checksum of content which names published blob.
+// blob of same checksum already stored is kept, so same content is stored once
+func (l *localStorage) ComposeBlob(ctx context.Context, uploadID string, totalChunk int, w io.Writer, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// check folder blob
+	if err := l.CheckAndCreateFolder(ctx, l.blobRootFolder()); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// create temp file in blob folder, rename within same file system is atomic
+	tempFile, err := os.CreateTemp(l.blobRootFolder(), fmt.Sprintf(".%s-*.tmp", uploadID))
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// don't forget to close and remove temp file at the end, removing renamed temp file is no-op
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+
+	var (
+		written int64
+		writer  = io.MultiWriter(tempFile, w)
+	)
+
+	// looping each chunk files
+	for i := 0; i < totalChunk; i++ {
+		n, err := l.WriteChunkToFinalFile(ctx, l.chunkFilePath(uploadID, i), writer)
+		if err != nil {
+			logger.Error(err)
+			return 0, err
+		}
+
+		written += n
+	}
+
+	// sync temp file
+	if err = tempFile.Sync(); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = tempFile.Close(); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// blob is not published if invalid
+	checksum, err := validate()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = l.PublishBlob(ctx, tempFile.Name(), checksum); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	return written, nil
+}
+
+// PutBlob writes content of given size into blob. every byte of content is read before validate is called,
+// validate returns checksum of content which names blob, blob of same checksum already stored is kept
+func (l *localStorage) PutBlob(ctx context.Context, content io.Reader, size int64, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if err := l.CheckAndCreateFolder(ctx, l.blobRootFolder()); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	tempFile, err := os.CreateTemp(l.blobRootFolder(), ".blob-*.tmp")
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+
+	written, err := io.Copy(tempFile, content)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if written != size {
+		err = fmt.Errorf("blob size is %d bytes, expected %d bytes", written, size)
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = tempFile.Sync(); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = tempFile.Close(); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = l.PublishBlob(ctx, tempFile.Name(), checksum); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	return written, nil
+}
+
+// AdoptFinal moves final file published by older version into blob store. every byte of final file is written to w,
+// validate returns checksum of content which names blob
+func (l *localStorage) AdoptFinal(ctx context.Context, uploadID, filename string, w io.Writer, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	finalFilePath, err := l.finalFilePath(uploadID, filename)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	finalFile, err := os.Open(finalFilePath)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	written, err := io.Copy(w, finalFile)
+	_ = finalFile.Close()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = l.CheckAndCreateFolder(ctx, l.blobRootFolder()); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = l.PublishBlob(ctx, finalFilePath, checksum); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = os.RemoveAll(l.uploadFinalFolder(uploadID)); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	logger.Infof("move final file of upload [%s] into blob %s", uploadID, checksum)
+	return written, nil
+}
+
+// PublishBlob renames file into blob of given checksum. if blob already stored, file is removed instead
+func (l *localStorage) PublishBlob(ctx context.Context, path, checksum string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	blobFilePath := l.blobFilePath(checksum)
+	if _, err := os.Stat(blobFilePath); err == nil {
+		logger.Infof("blob %s already stored, skip publish 📦", checksum)
+		return os.Remove(path)
+	}
+
+	if err := l.CheckAndCreateFolder(ctx, l.blobFolder(checksum)); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err := os.Rename(path, blobFilePath); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	// sync folder, so rename survives crash
+	if err := l.SyncFolder(l.blobFolder(checksum)); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	logger.Infof("success publish blob [%s]", blobFilePath)
+	return nil
+}
+
+// SyncFolder flushes folder entries to disk
+func (l *localStorage) SyncFolder(path string) error {
+	folder, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer folder.Close()
+	return folder.Sync()
+}
+
+// WriteChunkToFinalFile writes content from chunk file to final file
+func (l *localStorage) WriteChunkToFinalFile(ctx context.Context, chunkFilePath string, finalFile io.Writer) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// open chunk file
+	chunkFile, err := os.Open(chunkFilePath)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// don't forget to close chunk file at the end
+	defer chunkFile.Close()
+
+	// stream chunk file into final file through fixed size buffer from Pool
+	buf := utils.CopyBufferPool.Get().(*[]byte)
+	defer utils.CopyBufferPool.Put(buf)
+
+	written, err := io.CopyBuffer(finalFile, chunkFile, *buf)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// success write
+	logger.Infof("success write from chunk file %s to final file", chunkFilePath)
+	return written, nil
+}
+
+// StatBlob retrieves size and modification time of blob
+func (l *localStorage) StatBlob(ctx context.Context, checksum string) (*entity.FileInfo, error) {
+	fileInfo, err := os.Stat(l.blobFilePath(checksum))
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.FileInfo{
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+	}, nil
+}
+
+// OpenBlob opens blob for reading
+func (l *localStorage) OpenBlob(ctx context.Context, checksum string) (io.ReadSeekCloser, error) {
+	return os.Open(l.blobFilePath(checksum))
+}
+
+// ListBlobs lists checksum of stored blobs
+func (l *localStorage) ListBlobs(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(l.blobRootFolder())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	checksums := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			checksums = append(checksums, entry.Name())
+		}
+	}
+
+	return checksums, nil
+}
+
+// PutBlobMetadata writes metadata file into folder of blob, it is replaced atomically
+func (l *localStorage) PutBlobMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	return l.WriteFile(ctx, l.blobFolder(checksum), name, content)
+}
+
+// GetBlobMetadata reads metadata file from folder of blob
+func (l *localStorage) GetBlobMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(l.blobFolder(checksum), name))
+}
+
+// PutBlobFile saves file derived from blob next to it, content is stored as is
+func (l *localStorage) PutBlobFile(ctx context.Context, checksum, name string, content []byte) error {
+	return l.PutBlobMetadata(ctx, checksum, name, content)
+}
+
+// GetBlobFile retrieves file derived from blob
+func (l *localStorage) GetBlobFile(ctx context.Context, checksum, name string) ([]byte, error) {
+	return l.GetBlobMetadata(ctx, checksum, name)
+}
+
+// DeleteBlob removes blob and its metadata files
+func (l *localStorage) DeleteBlob(ctx context.Context, checksum string) error {
+	return os.RemoveAll(l.blobFolder(checksum))
+}
+
+// QuarantineBlob moves folder of blob into quarantine folder, blob of same checksum quarantined before is replaced
+func (l *localStorage) QuarantineBlob(ctx context.Context, checksum string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if err := l.CheckAndCreateFolder(ctx, l.quarantineFolder()); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	target := filepath.Join(l.quarantineFolder(), checksum)
+	if err := os.RemoveAll(target); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err := os.Rename(l.blobFolder(checksum), target); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// ListQuarantined lists checksum of quarantined blobs
+func (l *localStorage) ListQuarantined(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(l.quarantineFolder())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	checksums := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			checksums = append(checksums, entry.Name())
+		}
+	}
+
+	return checksums, nil
+}
+
+// PutQuarantineMetadata writes metadata file into folder of quarantined blob, it is replaced atomically
+func (l *localStorage) PutQuarantineMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	return l.WriteFile(ctx, filepath.Join(l.quarantineFolder(), checksum), name, content)
+}
+
+// GetQuarantineMetadata reads metadata file from folder of quarantined blob
+func (l *localStorage) GetQuarantineMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(l.quarantineFolder(), checksum, name))
+}
+
+// DeleteChunks removes all chunk files of upload, metadata files are kept
+func (l *localStorage) DeleteChunks(ctx context.Context, uploadID string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	matchFiles, err := filepath.Glob(filepath.Join(l.uploadChunkFolder(uploadID), chunkPrefix+"*"))
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	for _, matchFile := range matchFiles {
+		if err = os.RemoveAll(matchFile); err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes chunk folder and final folder of upload
+func (l *localStorage) Delete(ctx context.Context, uploadID string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	for _, path := range []string{l.uploadChunkFolder(uploadID), l.uploadFinalFolder(uploadID)} {
+		if err := os.RemoveAll(path); err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteFile writes file into folder, it is replaced atomically so reader never sees partially written file
+func (l *localStorage) WriteFile(ctx context.Context, folder, name string, content []byte) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if err := l.CheckAndCreateFolder(ctx, folder); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(folder, fmt.Sprintf(".%s-*.tmp", name))
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+
+	if _, err = tempFile.Write(content); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err = tempFile.Sync(); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err = tempFile.Close(); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err = os.Rename(tempFile.Name(), filepath.Join(folder, name)); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// CheckAndCreateFolder checks folder, if not exists then create folder
+func (l *localStorage) CheckAndCreateFolder(ctx context.Context, path string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// create folder
+		if err = os.MkdirAll(path, os.ModePerm); err != nil {
+			logger.Error(err)
+			return err
+		}
+
+		logger.Infof("create new folder [%s]", path)
+	}
+
+	return nil
+}
+
+// uploadChunkFolder returns folder path to save chunk files of given upload ID
+func (l *localStorage) uploadChunkFolder(uploadID string) string {
+	return filepath.Join(l.chunkFolder, uploadID)
+}
+
+// chunkFilePath returns chunk file path of given upload ID and chunk index
+func (l *localStorage) chunkFilePath(uploadID string, chunkIndex int) string {
+	return filepath.Join(l.uploadChunkFolder(uploadID), fmt.Sprintf("%s%d", chunkPrefix, chunkIndex))
+}
+
+// uploadFinalFolder returns folder of final file published by older version, final files are stored as blobs now
+func (l *localStorage) uploadFinalFolder(uploadID string) string {
+	return filepath.Join(l.finalFolder, uploadID)
+}
+
+// finalFilePath returns path of final file published by older version
+func (l *localStorage) finalFilePath(uploadID, filename string) (string, error) {
+	name, err := finalName(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.uploadFinalFolder(uploadID), name), nil
+}
+
+// blobRootFolder returns folder path to save blobs
+func (l *localStorage) blobRootFolder() string {
+	return filepath.Join(l.finalFolder, blobPrefix)
+}
+
+// blobFolder returns folder path of blob and its metadata files
+func (l *localStorage) blobFolder(checksum string) string {
+	return filepath.Join(l.blobRootFolder(), checksum)
+}
+
+// quarantineFolder returns folder path of quarantined blobs
+func (l *localStorage) quarantineFolder() string {
+	return filepath.Join(l.finalFolder, quarantinePrefix)
+}
+
+// blobFilePath returns path of blob content
+func (l *localStorage) blobFilePath(checksum string) string {
+	return filepath.Join(l.blobFolder(checksum), blobDataName)
+}
@@ -0,0 +1,381 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	content []byte
+	modTime time.Time
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+type memoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// NewMemoryStorage creates new instance of memoryStorage. it implements from interface Storage.
+// everything is lost when process exits, it is meant for tests
+func NewMemoryStorage() entity.Storage {
+	return &memoryStorage{objects: map[string]memoryObject{}}
+}
+
+// ListUploads lists upload IDs which have chunk or metadata
+func (m *memoryStorage) ListUploads(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := map[string]bool{}
+	uploadIDs := []string{}
+	for key := range m.objects {
+		rest, ok := strings.CutPrefix(key, uploadChunkPrefix(""))
+		if !ok {
+			continue
+		}
+
+		uploadID, _, _ := strings.Cut(rest, "/")
+		if !seen[uploadID] {
+			seen[uploadID] = true
+			uploadIDs = append(uploadIDs, uploadID)
+		}
+	}
+
+	return uploadIDs, nil
+}
+
+// PutMetadata saves metadata of upload
+func (m *memoryStorage) PutMetadata(ctx context.Context, uploadID, name string, content []byte) error {
+	m.put(metadataKey(uploadID, name), bytes.Clone(content))
+	return nil
+}
+
+// GetMetadata retrieves metadata of upload
+func (m *memoryStorage) GetMetadata(ctx context.Context, uploadID, name string) ([]byte, error) {
+	object, err := m.get(metadataKey(uploadID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(object.content), nil
+}
+
+// PutChunk saves content of chunk
+func (m *memoryStorage) PutChunk(ctx context.Context, uploadID string, chunkIndex int, content io.Reader) (int64, error) {
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return 0, err
+	}
+
+	m.put(chunkKey(uploadID, chunkIndex), b)
+	return int64(len(b)), nil
+}
+
+// ListChunks lists chunks of upload ordered by chunk index
+func (m *memoryStorage) ListChunks(ctx context.Context, uploadID string) ([]entity.ChunkInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prefix := uploadChunkPrefix(uploadID) + chunkPrefix
+	chunks := []entity.ChunkInfo{}
+	for key, object := range m.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		chunkIndex, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			continue
+		}
+
+		chunks = append(chunks, entity.ChunkInfo{
+			Index:   chunkIndex,
+			Size:    int64(len(object.content)),
+			ModTime: object.modTime,
+		})
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Index < chunks[j].Index
+	})
+
+	return chunks, nil
+}
+
+// OpenChunk opens chunk for reading
+func (m *memoryStorage) OpenChunk(ctx context.Context, uploadID string, chunkIndex int) (io.ReadCloser, error) {
+	object, err := m.get(chunkKey(uploadID, chunkIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(object.content)), nil
+}
+
+// ComposeBlob combines chunks into one blob. every byte written to blob is also written to w.
+// validate returns checksum of content which names blob, blob of same checksum already stored is kept
+func (m *memoryStorage) ComposeBlob(ctx context.Context, uploadID string, totalChunk int, w io.Writer, validate func() (string, error)) (int64, error) {
+	var final bytes.Buffer
+	for i := 0; i < totalChunk; i++ {
+		object, err := m.get(chunkKey(uploadID, i))
+		if err != nil {
+			return 0, err
+		}
+
+		final.Write(object.content)
+	}
+
+	if _, err := w.Write(final.Bytes()); err != nil {
+		return 0, err
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		return 0, err
+	}
+
+	m.putIfAbsent(blobKey(checksum, blobDataName), final.Bytes())
+	return int64(final.Len()), nil
+}
+
+// PutBlob saves content of given size into blob, blob of same checksum already stored is kept
+func (m *memoryStorage) PutBlob(ctx context.Context, content io.Reader, size int64, validate func() (string, error)) (int64, error) {
+	blob, err := io.ReadAll(content)
+	if err != nil {
+		return 0, err
+	}
+
+	if int64(len(blob)) != size {
+		return 0, fmt.Errorf("blob size is %d bytes, expected %d bytes", len(blob), size)
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		return 0, err
+	}
+
+	m.putIfAbsent(blobKey(checksum, blobDataName), blob)
+	return size, nil
+}
+
+// AdoptFinal moves final file published by older version into blob store
+func (m *memoryStorage) AdoptFinal(ctx context.Context, uploadID, filename string, w io.Writer, validate func() (string, error)) (int64, error) {
+	key, err := finalKey(uploadID, filename)
+	if err != nil {
+		return 0, err
+	}
+
+	object, err := m.get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = w.Write(object.content); err != nil {
+		return 0, err
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		return 0, err
+	}
+
+	m.putIfAbsent(blobKey(checksum, blobDataName), object.content)
+	m.deletePrefix(uploadFinalPrefix(uploadID))
+	return int64(len(object.content)), nil
+}
+
+// StatBlob retrieves size and modification time of blob
+func (m *memoryStorage) StatBlob(ctx context.Context, checksum string) (*entity.FileInfo, error) {
+	object, err := m.get(blobKey(checksum, blobDataName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.FileInfo{
+		Size:    int64(len(object.content)),
+		ModTime: object.modTime,
+	}, nil
+}
+
+// OpenBlob opens blob for reading
+func (m *memoryStorage) OpenBlob(ctx context.Context, checksum string) (io.ReadSeekCloser, error) {
+	object, err := m.get(blobKey(checksum, blobDataName))
+	if err != nil {
+		return nil, err
+	}
+
+	return nopSeekCloser{bytes.NewReader(object.content)}, nil
+}
+
+// ListBlobs lists checksum of stored blobs
+func (m *memoryStorage) ListBlobs(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	checksums := []string{}
+	for key := range m.objects {
+		rest, ok := strings.CutPrefix(key, blobKeyPrefix(""))
+		if !ok {
+			continue
+		}
+
+		if checksum, name, _ := strings.Cut(rest, "/"); name == blobDataName {
+			checksums = append(checksums, checksum)
+		}
+	}
+
+	return checksums, nil
+}
+
+// PutBlobMetadata saves metadata of blob
+func (m *memoryStorage) PutBlobMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	m.put(blobKey(checksum, name), bytes.Clone(content))
+	return nil
+}
+
+// GetBlobMetadata retrieves metadata of blob
+func (m *memoryStorage) GetBlobMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	object, err := m.get(blobKey(checksum, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return object.content, nil
+}
+
+// PutBlobFile saves file derived from blob next to it, content is stored as is
+func (m *memoryStorage) PutBlobFile(ctx context.Context, checksum, name string, content []byte) error {
+	return m.PutBlobMetadata(ctx, checksum, name, content)
+}
+
+// GetBlobFile retrieves file derived from blob
+func (m *memoryStorage) GetBlobFile(ctx context.Context, checksum, name string) ([]byte, error) {
+	return m.GetBlobMetadata(ctx, checksum, name)
+}
+
+// DeleteBlob removes blob and its metadata
+func (m *memoryStorage) DeleteBlob(ctx context.Context, checksum string) error {
+	m.deletePrefix(blobKeyPrefix(checksum))
+	return nil
+}
+
+// DeleteChunks removes all chunks of upload, metadata is kept
+func (m *memoryStorage) DeleteChunks(ctx context.Context, uploadID string) error {
+	m.deletePrefix(uploadChunkPrefix(uploadID) + chunkPrefix)
+	return nil
+}
+
+// QuarantineBlob moves blob and its metadata under quarantine prefix
+func (m *memoryStorage) QuarantineBlob(ctx context.Context, checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := blobKeyPrefix(checksum)
+	for key, object := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			m.objects[quarantineKeyPrefix(checksum)+strings.TrimPrefix(key, prefix)] = object
+			delete(m.objects, key)
+		}
+	}
+
+	return nil
+}
+
+// ListQuarantined lists checksum of quarantined blobs
+func (m *memoryStorage) ListQuarantined(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	checksums := []string{}
+	for key := range m.objects {
+		rest, ok := strings.CutPrefix(key, quarantineKeyPrefix(""))
+		if !ok {
+			continue
+		}
+
+		if checksum, name, _ := strings.Cut(rest, "/"); name == blobDataName {
+			checksums = append(checksums, checksum)
+		}
+	}
+
+	return checksums, nil
+}
+
+// PutQuarantineMetadata saves metadata of quarantined blob
+func (m *memoryStorage) PutQuarantineMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	m.put(quarantineKeyPrefix(checksum)+name, bytes.Clone(content))
+	return nil
+}
+
+// GetQuarantineMetadata retrieves metadata of quarantined blob
+func (m *memoryStorage) GetQuarantineMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	object, err := m.get(quarantineKeyPrefix(checksum) + name)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.content, nil
+}
+
+// Delete removes chunks, metadata and final file of upload
+func (m *memoryStorage) Delete(ctx context.Context, uploadID string) error {
+	m.deletePrefix(uploadChunkPrefix(uploadID))
+	m.deletePrefix(uploadFinalPrefix(uploadID))
+	return nil
+}
+
+func (m *memoryStorage) put(key string, content []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[key] = memoryObject{content: content, modTime: time.Now()}
+}
+
+func (m *memoryStorage) putIfAbsent(key string, content []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.objects[key]; !ok {
+		m.objects[key] = memoryObject{content: content, modTime: time.Now()}
+	}
+}
+
+func (m *memoryStorage) get(key string) (memoryObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return memoryObject{}, fmt.Errorf("%s : %w", key, os.ErrNotExist)
+	}
+
+	return object, nil
+}
+
+func (m *memoryStorage) deletePrefix(prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.objects, key)
+		}
+	}
+}
@@ -0,0 +1,1007 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// single PUT and single copy are limited to 5 GiB, bigger object is uploaded or copied in parts.
+// multipart upload has at most 10000 parts, so part size grows with object size
+const (
+	s3PartSize    = 64 << 20
+	s3MaxCopySize = 5 << 30
+	s3MaxParts    = 10000
+)
+
+// S3Config is connection config of S3 compatible object storage, e.g. AWS S3 or MinIO
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // prefix of every key, e.g. "tenant/team-a/"
+}
+
+type s3Storage struct {
+	config      S3Config
+	httpClient  *http.Client
+	partSize    int64 // object bigger than part size is uploaded in parts
+	maxCopySize int64 // object bigger than max copy size is copied in parts
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type s3ListResult struct {
+	Contents       []s3Object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3Error is error body of S3, copy and complete multipart upload may respond it with status 200
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+type s3InitiateMultipartResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CopyResult struct {
+	ETag string `xml:"ETag"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+// s3ObjectReader reads object from its offset, body is requested lazily
+type s3ObjectReader struct {
+	ctx     context.Context
+	storage *s3Storage
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *s3ObjectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+
+		resp, err := r.storage.do(r.ctx, http.MethodGet, r.key, nil, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *s3ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = r.offset + offset
+	case io.SeekEnd:
+		next = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if next < 0 {
+		return 0, fmt.Errorf("negative position %d", next)
+	}
+
+	// body is requested again from new offset
+	if next != r.offset {
+		_ = r.Close()
+		r.offset = next
+	}
+
+	return next, nil
+}
+
+func (r *s3ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// NewS3Storage creates new instance of s3Storage. it implements from interface Storage.
+// requests are path-style and signed with AWS Signature Version 4
+func NewS3Storage(config S3Config) entity.Storage {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	return &s3Storage{
+		config:      config,
+		httpClient:  &http.Client{},
+		partSize:    s3PartSize,
+		maxCopySize: s3MaxCopySize,
+	}
+}
+
+// ListUploads lists upload IDs which have chunk or metadata object
+func (s *s3Storage) ListUploads(ctx context.Context) ([]string, error) {
+	prefix := uploadChunkPrefix("")
+	result, err := s.list(ctx, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	uploadIDs := make([]string, 0, len(result.CommonPrefixes))
+	for _, commonPrefix := range result.CommonPrefixes {
+		uploadIDs = append(uploadIDs, strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/"))
+	}
+
+	return uploadIDs, nil
+}
+
+// PutMetadata uploads metadata object of upload
+func (s *s3Storage) PutMetadata(ctx context.Context, uploadID, name string, content []byte) error {
+	return s.putObject(ctx, metadataKey(uploadID, name), strings.NewReader(string(content)), int64(len(content)))
+}
+
+// GetMetadata downloads metadata object of upload
+func (s *s3Storage) GetMetadata(ctx context.Context, uploadID, name string) ([]byte, error) {
+	body, err := s.getObject(ctx, metadataKey(uploadID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// PutChunk uploads chunk object. content is spooled to temp file first because S3 needs content length
+func (s *s3Storage) PutChunk(ctx context.Context, uploadID string, chunkIndex int, content io.Reader) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	tempFile, err := os.CreateTemp("", "s3-chunk-*")
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+
+	size, err := io.Copy(tempFile, content)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	key := chunkKey(uploadID, chunkIndex)
+	if err = s.putObject(ctx, key, tempFile, size); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	logger.Infof("success upload chunk object [%s] 🗳️", key)
+	return size, nil
+}
+
+// ListChunks lists chunk objects of upload ordered by chunk index
+func (s *s3Storage) ListChunks(ctx context.Context, uploadID string) ([]entity.ChunkInfo, error) {
+	prefix := uploadChunkPrefix(uploadID) + chunkPrefix
+	objects, err := s.listObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]entity.ChunkInfo, 0, len(objects))
+	for _, object := range objects {
+		chunkIndex, err := strconv.Atoi(strings.TrimPrefix(object.Key, prefix))
+		if err != nil {
+			continue
+		}
+
+		chunks = append(chunks, entity.ChunkInfo{
+			Index:   chunkIndex,
+			Size:    object.Size,
+			ModTime: object.LastModified,
+		})
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Index < chunks[j].Index
+	})
+
+	return chunks, nil
+}
+
+// OpenChunk downloads chunk object
+func (s *s3Storage) OpenChunk(ctx context.Context, uploadID string, chunkIndex int) (io.ReadCloser, error) {
+	return s.getObject(ctx, chunkKey(uploadID, chunkIndex))
+}
+
+// ComposeBlob streams chunk objects in order into temp object. every byte written to temp object is also written to w.
+// validate returns checksum of content, temp object is copied to blob of that checksum unless it is already stored
+func (s *s3Storage) ComposeBlob(ctx context.Context, uploadID string, totalChunk int, w io.Writer, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	chunks, err := s.ListChunks(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	// blob size is needed before upload starts
+	var size int64
+	for i := 0; i < totalChunk; i++ {
+		if i >= len(chunks) || chunks[i].Index != i {
+			err = fmt.Errorf("%s : %w", chunkKey(uploadID, i), os.ErrNotExist)
+			logger.Error(err)
+			return 0, err
+		}
+
+		size += chunks[i].Size
+	}
+
+	pr, pw := io.Pipe()
+	var (
+		done    = make(chan struct{})
+		copyErr error
+	)
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < totalChunk; i++ {
+			body, err := s.getObject(ctx, chunkKey(uploadID, i))
+			if err != nil {
+				copyErr = err
+				_ = pw.CloseWithError(err)
+				return
+			}
+
+			_, err = io.Copy(io.MultiWriter(pw, w), body)
+			_ = body.Close()
+			if err != nil {
+				copyErr = err
+				_ = pw.CloseWithError(err)
+				return
+			}
+		}
+
+		_ = pw.Close()
+	}()
+
+	// temp object is kept in chunks of upload, removed at the end
+	tempKey := metadataKey(uploadID, ".compose-blob")
+	defer func() {
+		_ = s.deleteObject(ctx, tempKey)
+	}()
+
+	if err = s.putObject(ctx, tempKey, pr, size); err != nil {
+		_ = pr.CloseWithError(err)
+		logger.Error(err)
+		return 0, err
+	}
+
+	// last bytes are written to w after uploader read them, so w is complete once goroutine returned.
+	// chunks holding more than listed size fail here rather than blocking goroutine forever
+	_ = pr.Close()
+	<-done
+
+	if copyErr != nil {
+		logger.Error(copyErr)
+		return 0, copyErr
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = s.PublishBlob(ctx, tempKey, checksum); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// PutBlob uploads content of given size into temp object, then copies it to blob named by checksum returned by validate.
+// blob of same checksum already stored is kept
+func (s *s3Storage) PutBlob(ctx context.Context, content io.Reader, size int64, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	tempKey := blobKey("", fmt.Sprintf(".blob-%d", time.Now().UnixNano()))
+	defer func() {
+		_ = s.deleteObject(ctx, tempKey)
+	}()
+
+	if err := s.putObject(ctx, tempKey, content, size); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = s.PublishBlob(ctx, tempKey, checksum); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// AdoptFinal copies final object published by older version into blob store then removes it.
+// every byte of final object is written to w, validate returns checksum of content which names blob
+func (s *s3Storage) AdoptFinal(ctx context.Context, uploadID, filename string, w io.Writer, validate func() (string, error)) (int64, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	key, err := finalKey(uploadID, filename)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	body, err := s.getObject(ctx, key)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	written, err := io.Copy(w, body)
+	_ = body.Close()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	checksum, err := validate()
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = s.PublishBlob(ctx, key, checksum); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	if err = s.deletePrefix(ctx, uploadFinalPrefix(uploadID)); err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	logger.Infof("move final object of upload [%s] into blob %s", uploadID, checksum)
+	return written, nil
+}
+
+// PublishBlob copies object into blob of given checksum, unless blob is already stored
+func (s *s3Storage) PublishBlob(ctx context.Context, key, checksum string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if _, err := s.StatBlob(ctx, checksum); err == nil {
+		logger.Infof("blob %s already stored, skip publish 📦", checksum)
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Error(err)
+		return err
+	}
+
+	if err := s.copyObject(ctx, key, blobKey(checksum, blobDataName)); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// StatBlob retrieves size and modification time of blob object
+func (s *s3Storage) StatBlob(ctx context.Context, checksum string) (*entity.FileInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, blobKey(checksum, blobDataName), nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &entity.FileInfo{
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+	}, nil
+}
+
+// OpenBlob opens blob object for reading. each read after seek is a ranged GET request
+func (s *s3Storage) OpenBlob(ctx context.Context, checksum string) (io.ReadSeekCloser, error) {
+	fileInfo, err := s.StatBlob(ctx, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3ObjectReader{
+		ctx:     ctx,
+		storage: s,
+		key:     blobKey(checksum, blobDataName),
+		size:    fileInfo.Size,
+	}, nil
+}
+
+// ListBlobs lists checksum of stored blobs
+func (s *s3Storage) ListBlobs(ctx context.Context) ([]string, error) {
+	prefix := blobKeyPrefix("")
+	result, err := s.list(ctx, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make([]string, 0, len(result.CommonPrefixes))
+	for _, commonPrefix := range result.CommonPrefixes {
+		checksums = append(checksums, strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/"))
+	}
+
+	return checksums, nil
+}
+
+// PutBlobMetadata uploads metadata object of blob
+func (s *s3Storage) PutBlobMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	return s.putObject(ctx, blobKey(checksum, name), strings.NewReader(string(content)), int64(len(content)))
+}
+
+// GetBlobMetadata downloads metadata object of blob
+func (s *s3Storage) GetBlobMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	body, err := s.getObject(ctx, blobKey(checksum, name))
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// PutBlobFile saves file derived from blob next to it, content is stored as is
+func (s *s3Storage) PutBlobFile(ctx context.Context, checksum, name string, content []byte) error {
+	return s.PutBlobMetadata(ctx, checksum, name, content)
+}
+
+// GetBlobFile retrieves file derived from blob
+func (s *s3Storage) GetBlobFile(ctx context.Context, checksum, name string) ([]byte, error) {
+	return s.GetBlobMetadata(ctx, checksum, name)
+}
+
+// DeleteBlob removes blob object and its metadata objects
+func (s *s3Storage) DeleteBlob(ctx context.Context, checksum string) error {
+	return s.deletePrefix(ctx, blobKeyPrefix(checksum))
+}
+
+// QuarantineBlob copies blob object and its metadata objects under quarantine prefix, then removes them
+func (s *s3Storage) QuarantineBlob(ctx context.Context, checksum string) error {
+	objects, err := s.listObjects(ctx, blobKeyPrefix(checksum))
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if err = s.copyObject(ctx, object.Key, quarantineKeyPrefix(checksum)+strings.TrimPrefix(object.Key, blobKeyPrefix(checksum))); err != nil {
+			return err
+		}
+	}
+
+	return s.deletePrefix(ctx, blobKeyPrefix(checksum))
+}
+
+// ListQuarantined lists checksum of quarantined blobs by common prefixes under quarantine prefix
+func (s *s3Storage) ListQuarantined(ctx context.Context) ([]string, error) {
+	prefix := quarantineKeyPrefix("")
+	result, err := s.list(ctx, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make([]string, 0, len(result.CommonPrefixes))
+	for _, commonPrefix := range result.CommonPrefixes {
+		checksums = append(checksums, strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/"))
+	}
+
+	return checksums, nil
+}
+
+// PutQuarantineMetadata uploads metadata object of quarantined blob
+func (s *s3Storage) PutQuarantineMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	return s.putObject(ctx, quarantineKeyPrefix(checksum)+name, strings.NewReader(string(content)), int64(len(content)))
+}
+
+// GetQuarantineMetadata downloads metadata object of quarantined blob
+func (s *s3Storage) GetQuarantineMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	body, err := s.getObject(ctx, quarantineKeyPrefix(checksum)+name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// DeleteChunks removes all chunk objects of upload, metadata is kept
+func (s *s3Storage) DeleteChunks(ctx context.Context, uploadID string) error {
+	return s.deletePrefix(ctx, uploadChunkPrefix(uploadID)+chunkPrefix)
+}
+
+// Delete removes chunks, metadata and final object of upload
+func (s *s3Storage) Delete(ctx context.Context, uploadID string) error {
+	if err := s.deletePrefix(ctx, uploadChunkPrefix(uploadID)); err != nil {
+		return err
+	}
+
+	return s.deletePrefix(ctx, uploadFinalPrefix(uploadID))
+}
+
+// putObject uploads body of given size, object bigger than part size is uploaded in parts
+func (s *s3Storage) putObject(ctx context.Context, key string, body io.Reader, size int64) error {
+	if size > s.partSize {
+		return s.multipartUpload(ctx, key, size, func(uploadID string, partNumber int, offset, partSize int64) (string, error) {
+			query := url.Values{}
+			query.Set("partNumber", strconv.Itoa(partNumber))
+			query.Set("uploadId", uploadID)
+
+			resp, err := s.do(ctx, http.MethodPut, key, query, nil, io.LimitReader(body, partSize), partSize)
+			if err != nil {
+				return "", err
+			}
+
+			_ = resp.Body.Close()
+			return resp.Header.Get("ETag"), nil
+		})
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, nil, nil, body, size)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// copyObject copies object server side, object bigger than max copy size is copied in parts
+func (s *s3Storage) copyObject(ctx context.Context, src, dst string) error {
+	header := http.Header{}
+	header.Set("x-amz-copy-source", "/"+s.config.Bucket+"/"+awsURIEncode(s.config.Prefix+src, false))
+
+	resp, err := s.do(ctx, http.MethodHead, src, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+	if size := resp.ContentLength; size > s.maxCopySize {
+		return s.multipartUpload(ctx, dst, size, func(uploadID string, partNumber int, offset, partSize int64) (string, error) {
+			query := url.Values{}
+			query.Set("partNumber", strconv.Itoa(partNumber))
+			query.Set("uploadId", uploadID)
+
+			partHeader := header.Clone()
+			partHeader.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", offset, offset+partSize-1))
+
+			resp, err := s.do(ctx, http.MethodPut, dst, query, partHeader, nil, 0)
+			if err != nil {
+				return "", err
+			}
+
+			var result s3CopyResult
+			if err = decodeS3Result(resp, &result); err != nil {
+				return "", fmt.Errorf("s3 copy part %d of %s : %w", partNumber, src, err)
+			}
+
+			return result.ETag, nil
+		})
+	}
+
+	if resp, err = s.do(ctx, http.MethodPut, dst, nil, header, nil, 0); err != nil {
+		return err
+	}
+
+	if err = decodeS3Result(resp, &s3CopyResult{}); err != nil {
+		return fmt.Errorf("s3 copy %s to %s : %w", src, dst, err)
+	}
+
+	return nil
+}
+
+// multipartUpload creates object of given size from parts written by putPart, which returns ETag of part.
+// upload is aborted if any part fails, so its parts are not left in bucket
+func (s *s3Storage) multipartUpload(ctx context.Context, key string, size int64, putPart func(uploadID string, partNumber int, offset, partSize int64) (string, error)) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	query := url.Values{}
+	query.Set("uploads", "")
+
+	resp, err := s.do(ctx, http.MethodPost, key, query, nil, nil, 0)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	var initiated s3InitiateMultipartResult
+	if err = decodeS3Result(resp, &initiated); err != nil {
+		err = fmt.Errorf("s3 create multipart upload %s : %w", key, err)
+		logger.Error(err)
+		return err
+	}
+
+	uploadQuery := url.Values{}
+	uploadQuery.Set("uploadId", initiated.UploadID)
+
+	complete := s3CompleteMultipartUpload{}
+	err = func() error {
+		partSize := max(s.partSize, (size+s3MaxParts-1)/s3MaxParts)
+		for offset, partNumber := int64(0), 1; offset < size; offset, partNumber = offset+partSize, partNumber+1 {
+			etag, err := putPart(initiated.UploadID, partNumber, offset, min(partSize, size-offset))
+			if err != nil {
+				return err
+			}
+
+			complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: partNumber, ETag: etag})
+		}
+
+		content, err := xml.Marshal(complete)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.do(ctx, http.MethodPost, key, uploadQuery, nil, bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return err
+		}
+
+		return decodeS3Result(resp, nil)
+	}()
+
+	if err != nil {
+		if resp, err := s.do(context.WithoutCancel(ctx), http.MethodDelete, key, uploadQuery, nil, nil, 0); err == nil {
+			_ = resp.Body.Close()
+		}
+
+		err = fmt.Errorf("s3 multipart upload %s : %w", key, err)
+		logger.Error(err)
+		return err
+	}
+
+	logger.Infof("success upload %s in %d parts", key, len(complete.Parts))
+	return nil
+}
+
+// decodeS3Result decodes xml body of successful response into v, nil v discards it.
+// error body is returned as error, even if response status is 200
+func decodeS3Result(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	var s3Err s3Error
+	if err = xml.Unmarshal(content, &s3Err); err == nil {
+		return fmt.Errorf("%s : %s", s3Err.Code, s3Err.Message)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return xml.Unmarshal(content, v)
+}
+
+func (s *s3Storage) getObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3Storage) deleteObject(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (s *s3Storage) deletePrefix(ctx context.Context, prefix string) error {
+	objects, err := s.listObjects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if err = s.deleteObject(ctx, object.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listObjects lists all objects by given prefix
+func (s *s3Storage) listObjects(ctx context.Context, prefix string) ([]s3Object, error) {
+	result, err := s.list(ctx, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Contents, nil
+}
+
+// list lists objects and common prefixes by given prefix and delimiter, following continuation token
+func (s *s3Storage) list(ctx context.Context, prefix, delimiter string) (*s3ListResult, error) {
+	var (
+		merged            s3ListResult
+		continuationToken string
+	)
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.config.Prefix+prefix)
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// keys are returned without prefix of storage, as they are requested
+		for _, object := range result.Contents {
+			object.Key = strings.TrimPrefix(object.Key, s.config.Prefix)
+			merged.Contents = append(merged.Contents, object)
+		}
+
+		for _, commonPrefix := range result.CommonPrefixes {
+			commonPrefix.Prefix = strings.TrimPrefix(commonPrefix.Prefix, s.config.Prefix)
+			merged.CommonPrefixes = append(merged.CommonPrefixes, commonPrefix)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return &merged, nil
+		}
+
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// do sends signed request to bucket. key may be empty for bucket level request
+func (s *s3Storage) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	endpoint, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := "/" + s.config.Bucket + "/"
+	if key != "" {
+		objectPath += s.config.Prefix + key
+	}
+	endpoint.Path = objectPath
+	endpoint.RawPath = awsURIEncode(objectPath, false)
+	endpoint.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, endpoint.RawPath, time.Now().UTC())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s : %w", method, key, os.ErrNotExist)
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s : status %d : %s", method, key, resp.StatusCode, message)
+	}
+
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 authorization header to request
+func (s *s3Storage) sign(req *http.Request, canonicalURI string, now time.Time) {
+	var (
+		amzDate = now.Format("20060102T150405Z")
+		date    = now.Format("20060102")
+		scope   = fmt.Sprintf("%s/%s/s3/aws4_request", date, s.config.Region)
+	)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", s3UnsignedPayload)
+
+	// host and every x-amz-* header are signed
+	signedHeaders := []string{"host"}
+	canonicalHeaders := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			signedHeaders = append(signedHeaders, lk)
+			canonicalHeaders[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+
+	sort.Strings(signedHeaders)
+
+	var headerBuilder strings.Builder
+	for _, k := range signedHeaders {
+		headerBuilder.WriteString(k + ":" + canonicalHeaders[k] + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.RawQuery,
+		headerBuilder.String(),
+		strings.Join(signedHeaders, ";"),
+		s3UnsignedPayload,
+	}, "\n")
+
+	hashRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hashRequest[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, strings.Join(signedHeaders, ";"), hex.EncodeToString(hmacSHA256(signingKey, stringToSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key as required by signature
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode encodes every byte except unreserved characters. slash is kept unless encodeSlash
+func awsURIEncode(s string, encodeSlash bool) string {
+	var builder strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			builder.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			builder.WriteByte(b)
+		default:
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+
+	return builder.String()
+}
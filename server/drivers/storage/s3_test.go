@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is in-memory S3 compatible server, it supports requests sent by s3Storage only
+type fakeS3 struct {
+	mu        sync.Mutex
+	bucket    string
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte
+	nextID    int
+	requests  []string
+	copyError bool // copy is responded 200 with error body
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{
+		bucket:  bucket,
+		objects: map[string][]byte{},
+		uploads: map[string]map[int][]byte{},
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	if r.ContentLength > 0 && int64(len(body)) != r.ContentLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RawQuery)
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, query.Get("prefix"), query.Get("delimiter"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		uploadID := strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = map[int][]byte{}
+		_, _ = fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		if src := r.Header.Get("x-amz-copy-source"); src != "" {
+			if f.copyError {
+				_, _ = fmt.Fprint(w, "<Error><Code>InternalError</Code><Message>copy failed</Message></Error>")
+				return
+			}
+
+			content, ok := f.source(src)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			var first, last int
+			_, _ = fmt.Sscanf(r.Header.Get("x-amz-copy-source-range"), "bytes=%d-%d", &first, &last)
+			parts[partNumber] = bytes.Clone(content[first : last+1])
+			_, _ = fmt.Fprintf(w, "<CopyPartResult><ETag>\"%d\"</ETag></CopyPartResult>", partNumber)
+			return
+		}
+
+		parts[partNumber] = body
+		w.Header().Set("ETag", fmt.Sprintf("\"%d\"", partNumber))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var complete s3CompleteMultipartUpload
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var content []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf("\"%d\"", part.PartNumber) {
+				_, _ = fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>invalid part</Message></Error>")
+				return
+			}
+
+			content = append(content, parts[part.PartNumber]...)
+		}
+
+		delete(f.uploads, query.Get("uploadId"))
+		f.objects[key] = content
+		_, _ = fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		if f.copyError {
+			_, _ = fmt.Fprint(w, "<Error><Code>InternalError</Code><Message>copy failed</Message></Error>")
+			return
+		}
+
+		content, ok := f.source(r.Header.Get("x-amz-copy-source"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		f.objects[key] = bytes.Clone(content)
+		_, _ = fmt.Fprint(w, "<CopyObjectResult><ETag>\"copy\"</ETag></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		http.ServeContent(w, r, key, time.Now(), bytes.NewReader(content))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// source finds object of x-amz-copy-source header, "/bucket/key"
+func (f *fakeS3) source(copySource string) ([]byte, bool) {
+	key, err := url.PathUnescape(strings.TrimPrefix(copySource, "/"+f.bucket+"/"))
+	if err != nil {
+		return nil, false
+	}
+
+	content, ok := f.objects[key]
+	return content, ok
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, delimiter string) {
+	keys := []string{}
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	var (
+		result s3ListResult
+		seen   = map[string]bool{}
+	)
+
+	for _, key := range keys {
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := key[:len(prefix)+i+1]
+			if !seen[commonPrefix] {
+				seen[commonPrefix] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct {
+					Prefix string `xml:"Prefix"`
+				}{commonPrefix})
+			}
+
+			continue
+		}
+
+		result.Contents = append(result.Contents, s3Object{Key: key, Size: int64(len(f.objects[key])), LastModified: time.Now().UTC()})
+	}
+
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		s3ListResult
+	}{s3ListResult: result})
+}
+
+// count counts requests of given method whose query has given parameter
+func (f *fakeS3) count(method, param string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var n int
+	for _, request := range f.requests {
+		requestMethod, rawQuery, _ := strings.Cut(request, " ")
+		if query, _ := url.ParseQuery(rawQuery); requestMethod == method && query.Has(param) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func newTestS3Storage(t *testing.T, prefix string, partSize, maxCopySize int64) (*s3Storage, *fakeS3) {
+	fake, server := newFakeS3(t, "bucket")
+	storage := NewS3Storage(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Prefix:    prefix,
+	}).(*s3Storage)
+
+	storage.partSize = partSize
+	storage.maxCopySize = maxCopySize
+	return storage, fake
+}
+
+func TestS3StorageLargeObjects(t *testing.T) {
+	tests := []struct {
+		name          string
+		partSize      int64
+		maxCopySize   int64
+		size          int
+		uploadedParts int
+		copiedParts   int
+	}{
+		{name: "single put and copy", partSize: 1 << 20, maxCopySize: 1 << 20, size: 1000},
+		{name: "multipart upload", partSize: 256, maxCopySize: 1 << 20, size: 1000, uploadedParts: 4},
+		{name: "multipart copy", partSize: 1 << 20, maxCopySize: 256, size: 1000, copiedParts: 1},
+		{name: "multipart upload and copy", partSize: 300, maxCopySize: 300, size: 900, uploadedParts: 3, copiedParts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			storage, fake := newTestS3Storage(t, "tenant/a/", tt.partSize, tt.maxCopySize)
+
+			content := bytes.Repeat([]byte("0123456789"), tt.size/10)
+			sum := sha256.Sum256(content)
+			checksum := hex.EncodeToString(sum[:])
+
+			size, err := storage.PutBlob(ctx, bytes.NewReader(content), int64(len(content)), func() (string, error) {
+				return checksum, nil
+			})
+			if err != nil {
+				t.Fatalf("PutBlob() error = %v", err)
+			}
+
+			if size != int64(len(content)) {
+				t.Errorf("PutBlob() size = %d, want %d", size, len(content))
+			}
+
+			blob, err := storage.OpenBlob(ctx, checksum)
+			if err != nil {
+				t.Fatalf("OpenBlob() error = %v", err)
+			}
+
+			got, err := io.ReadAll(blob)
+			_ = blob.Close()
+			if err != nil || !bytes.Equal(got, content) {
+				t.Errorf("blob content = %d bytes, err %v, want %d bytes", len(got), err, len(content))
+			}
+
+			// one part request per part, parts of upload and of copy share request type
+			if n := fake.count(http.MethodPut, "partNumber"); n != tt.uploadedParts+tt.copiedParts {
+				t.Errorf("part requests = %d, want %d", n, tt.uploadedParts+tt.copiedParts)
+			}
+
+			if n := len(fake.uploads); n != 0 {
+				t.Errorf("unfinished multipart uploads = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestS3StorageCopyErrorBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxCopySize int64
+	}{
+		{name: "single copy", maxCopySize: 1 << 20},
+		{name: "multipart copy", maxCopySize: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			storage, fake := newTestS3Storage(t, "", 1<<20, tt.maxCopySize)
+			fake.copyError = true
+
+			_, err := storage.PutBlob(ctx, strings.NewReader("content"), 7, func() (string, error) {
+				return "checksum", nil
+			})
+			if err == nil || !strings.Contains(err.Error(), "copy failed") {
+				t.Fatalf("PutBlob() error = %v, want error of copy body", err)
+			}
+
+			if _, err = storage.StatBlob(ctx, "checksum"); err == nil {
+				t.Errorf("StatBlob() error = nil, blob must not be published")
+			}
+
+			if n := len(fake.uploads); n != 0 {
+				t.Errorf("unfinished multipart uploads = %d, want 0", n)
+			}
+		})
+	}
+}
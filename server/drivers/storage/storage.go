@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"fmt"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/drivers/keyprovider"
+	"go-upload-chunk/server/internal/entity"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// NewStorage creates storage implementation selected by given driver, each tenant has its own storage of the driver.
+// it is encrypted if encryption enabled
+func NewStorage(driver string) (entity.Storage, error) {
+	storage, err := NewTenantStorage(func(tenantID string) (entity.Storage, error) {
+		return newDriverStorage(driver, tenantID)
+	})
+	if err != nil || !config.EncryptionEnabled() {
+		return storage, err
+	}
+
+	keyProvider, err := keyprovider.NewKeyProvider(config.EncryptionKeyProvider())
+	if err != nil {
+		return nil, err
+	}
+
+	if keyProvider.CurrentKeyID() == "" {
+		return nil, fmt.Errorf("encryption enabled without master key, generate one with \"rotate-keys -generate\"")
+	}
+
+	return NewEncryptedStorage(storage, keyProvider), nil
+}
+
+// newDriverStorage creates storage of tenant, default tenant keeps layout of single tenant version
+func newDriverStorage(driver, tenantID string) (entity.Storage, error) {
+	switch driver {
+	case "", "local":
+		if tenantID == entity.DefaultTenant {
+			return NewLocalStorage(config.FolderUploadChunk(), config.FolderUploadFinal()), nil
+		}
+
+		tenantFolder := filepath.Join(config.FolderUploadTenant(), tenantID)
+		return NewLocalStorage(filepath.Join(tenantFolder, "chunk"), filepath.Join(tenantFolder, "final")), nil
+	case "memory":
+		return NewMemoryStorage(), nil
+	case "s3":
+		var prefix string
+		if tenantID != entity.DefaultTenant {
+			prefix = path.Join("tenant", tenantID) + "/"
+		}
+
+		return NewS3Storage(S3Config{
+			Endpoint:  config.S3Endpoint(),
+			Region:    config.S3Region(),
+			Bucket:    config.S3Bucket(),
+			AccessKey: config.S3AccessKey(),
+			SecretKey: config.S3SecretKey(),
+			Prefix:    prefix,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver [%s]", driver)
+	}
+}
+
+// blob of final file is stored once per content, named by its sha256 checksum.
+// infected blob is moved into quarantine with its metadata, kept for investigation
+const (
+	blobPrefix       = "blob"
+	blobDataName     = "data"
+	quarantinePrefix = "quarantine"
+)
+
+// key layout of object storages mirrors local chunk and final folders
+
+func uploadChunkPrefix(uploadID string) string {
+	return path.Join("chunk", uploadID) + "/"
+}
+
+func metadataKey(uploadID, name string) string {
+	return uploadChunkPrefix(uploadID) + name
+}
+
+func chunkKey(uploadID string, chunkIndex int) string {
+	return fmt.Sprintf("%s%s%d", uploadChunkPrefix(uploadID), chunkPrefix, chunkIndex)
+}
+
+func uploadFinalPrefix(uploadID string) string {
+	return path.Join("final", uploadID) + "/"
+}
+
+// finalKey is key of final object published by older version
+func finalKey(uploadID, filename string) (string, error) {
+	name, err := finalName(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return uploadFinalPrefix(uploadID) + name, nil
+}
+
+// finalName is name of final file published by older version, filename without any name never names a file
+func finalName(filename string) (string, error) {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		return "", fmt.Errorf("final file of [%s] : %w", filename, os.ErrNotExist)
+	}
+
+	return name, nil
+}
+
+func blobKeyPrefix(checksum string) string {
+	return path.Join(blobPrefix, checksum) + "/"
+}
+
+func blobKey(checksum, name string) string {
+	return blobKeyPrefix(checksum) + name
+}
+
+func quarantineKeyPrefix(checksum string) string {
+	return path.Join(quarantinePrefix, checksum) + "/"
+}
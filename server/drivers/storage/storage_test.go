@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-upload-chunk/server/internal/entity"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testStorages creates every storage driver, s3 uploads in small parts so multipart upload is exercised too
+func testStorages(t *testing.T) map[string]entity.Storage {
+	folder := t.TempDir()
+	s3, _ := newTestS3Storage(t, "tenant/a/", 8, 8)
+
+	return map[string]entity.Storage{
+		"local":  NewLocalStorage(filepath.Join(folder, "chunk"), filepath.Join(folder, "final")),
+		"memory": NewMemoryStorage(),
+		"s3":     s3,
+	}
+}
+
+// composeBlob puts chunks of upload then composes them into blob named by sha256 of content
+func composeBlob(ctx context.Context, storage entity.Storage, uploadID string, chunks []string) (string, error) {
+	for i, chunk := range chunks {
+		if _, err := storage.PutChunk(ctx, uploadID, i, strings.NewReader(chunk)); err != nil {
+			return "", err
+		}
+	}
+
+	var (
+		hashChecksum hash.Hash = sha256.New()
+		checksum     string
+	)
+
+	_, err := storage.ComposeBlob(ctx, uploadID, len(chunks), hashChecksum, func() (string, error) {
+		checksum = hex.EncodeToString(hashChecksum.Sum(nil))
+		return checksum, nil
+	})
+
+	return checksum, err
+}
+
+func TestStorageChunks(t *testing.T) {
+	for name, storage := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			chunks := []string{"first chunk", "second", "third chunk is longest"}
+			for i, chunk := range chunks {
+				written, err := storage.PutChunk(ctx, "upload-1", i, strings.NewReader(chunk))
+				if err != nil {
+					t.Fatalf("PutChunk(%d) error = %v", i, err)
+				}
+
+				if written != int64(len(chunk)) {
+					t.Errorf("PutChunk(%d) written = %d, want %d", i, written, len(chunk))
+				}
+			}
+
+			if err := storage.PutMetadata(ctx, "upload-1", "manifest.json", []byte(`{"id":"upload-1"}`)); err != nil {
+				t.Fatalf("PutMetadata() error = %v", err)
+			}
+
+			infos, err := storage.ListChunks(ctx, "upload-1")
+			if err != nil {
+				t.Fatalf("ListChunks() error = %v", err)
+			}
+
+			if len(infos) != len(chunks) {
+				t.Fatalf("ListChunks() = %d chunks, want %d", len(infos), len(chunks))
+			}
+
+			for i, info := range infos {
+				if info.Index != i || info.Size != int64(len(chunks[i])) {
+					t.Errorf("ListChunks()[%d] = index %d size %d, want index %d size %d", i, info.Index, info.Size, i, len(chunks[i]))
+				}
+
+				chunk, err := storage.OpenChunk(ctx, "upload-1", i)
+				if err != nil {
+					t.Fatalf("OpenChunk(%d) error = %v", i, err)
+				}
+
+				content, _ := io.ReadAll(chunk)
+				_ = chunk.Close()
+				if string(content) != chunks[i] {
+					t.Errorf("OpenChunk(%d) = %q, want %q", i, content, chunks[i])
+				}
+			}
+
+			uploadIDs, err := storage.ListUploads(ctx)
+			if err != nil || !slices.Contains(uploadIDs, "upload-1") {
+				t.Errorf("ListUploads() = %v, %v, want upload-1", uploadIDs, err)
+			}
+
+			if _, err = storage.GetMetadata(ctx, "upload-1", "missing.json"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("GetMetadata() of missing metadata error = %v, want os.ErrNotExist", err)
+			}
+
+			// metadata survives removal of chunks
+			if err = storage.DeleteChunks(ctx, "upload-1"); err != nil {
+				t.Fatalf("DeleteChunks() error = %v", err)
+			}
+
+			if infos, _ = storage.ListChunks(ctx, "upload-1"); len(infos) != 0 {
+				t.Errorf("ListChunks() after DeleteChunks() = %d chunks, want 0", len(infos))
+			}
+
+			if content, err := storage.GetMetadata(ctx, "upload-1", "manifest.json"); err != nil || string(content) != `{"id":"upload-1"}` {
+				t.Errorf("GetMetadata() after DeleteChunks() = %q, %v", content, err)
+			}
+
+			if err = storage.Delete(ctx, "upload-1"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			if _, err = storage.GetMetadata(ctx, "upload-1", "manifest.json"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("GetMetadata() after Delete() error = %v, want os.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStorageComposeBlob(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{name: "one chunk", chunks: []string{"hello world"}},
+		{name: "many chunks", chunks: []string{"lorem ipsum ", "dolor sit amet, ", "consectetur adipiscing elit"}},
+		{name: "empty chunk", chunks: []string{"before ", "", "after"}},
+	}
+
+	for name, storage := range testStorages(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				want := strings.Join(tt.chunks, "")
+
+				checksum, err := composeBlob(ctx, storage, "upload-"+tt.name, tt.chunks)
+				if err != nil {
+					t.Fatalf("ComposeBlob() error = %v", err)
+				}
+
+				sum := sha256.Sum256([]byte(want))
+				if checksum != hex.EncodeToString(sum[:]) {
+					t.Errorf("ComposeBlob() wrote checksum %s, want checksum of content", checksum)
+				}
+
+				fileInfo, err := storage.StatBlob(ctx, checksum)
+				if err != nil || fileInfo.Size != int64(len(want)) {
+					t.Fatalf("StatBlob() = %+v, %v, want size %d", fileInfo, err, len(want))
+				}
+
+				blob, err := storage.OpenBlob(ctx, checksum)
+				if err != nil {
+					t.Fatalf("OpenBlob() error = %v", err)
+				}
+
+				defer blob.Close()
+
+				content, _ := io.ReadAll(blob)
+				if string(content) != want {
+					t.Errorf("OpenBlob() = %q, want %q", content, want)
+				}
+
+				// blob is seekable for range download
+				offset := int64(len(want) / 2)
+				if _, err = blob.Seek(offset, io.SeekStart); err != nil {
+					t.Fatalf("Seek() error = %v", err)
+				}
+
+				content, _ = io.ReadAll(blob)
+				if string(content) != want[offset:] {
+					t.Errorf("OpenBlob() after Seek(%d) = %q, want %q", offset, content, want[offset:])
+				}
+
+				// same content composed again keeps stored blob
+				again, err := composeBlob(ctx, storage, "upload-again-"+tt.name, tt.chunks)
+				if err != nil || again != checksum {
+					t.Errorf("ComposeBlob() of same content = %s, %v, want %s", again, err, checksum)
+				}
+
+				checksums, err := storage.ListBlobs(ctx)
+				if err != nil || !slices.Contains(checksums, checksum) {
+					t.Errorf("ListBlobs() = %v, %v, want %s", checksums, err, checksum)
+				}
+			})
+		}
+	}
+}
+
+func TestStorageComposeBlobInvalid(t *testing.T) {
+	for name, storage := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			errInvalid := errors.New("invalid checksum")
+
+			if _, err := storage.PutChunk(ctx, "upload-1", 0, strings.NewReader("content")); err != nil {
+				t.Fatalf("PutChunk() error = %v", err)
+			}
+
+			// invalid blob is never published
+			_, err := storage.ComposeBlob(ctx, "upload-1", 1, io.Discard, func() (string, error) {
+				return "", errInvalid
+			})
+			if !errors.Is(err, errInvalid) {
+				t.Errorf("ComposeBlob() error = %v, want %v", err, errInvalid)
+			}
+
+			if checksums, _ := storage.ListBlobs(ctx); len(checksums) != 0 {
+				t.Errorf("ListBlobs() = %v, want none", checksums)
+			}
+
+			// missing chunk fails assembly
+			if _, err = storage.ComposeBlob(ctx, "upload-1", 2, io.Discard, func() (string, error) {
+				return "checksum", nil
+			}); err == nil {
+				t.Errorf("ComposeBlob() of missing chunk error = nil")
+			}
+		})
+	}
+}
+
+func TestStorageBlobMetadata(t *testing.T) {
+	for name, storage := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			checksum, err := composeBlob(ctx, storage, "upload-1", []string{"infected ", "content"})
+			if err != nil {
+				t.Fatalf("ComposeBlob() error = %v", err)
+			}
+
+			if err = storage.PutBlobMetadata(ctx, checksum, "refs.json", []byte(`["upload-1"]`)); err != nil {
+				t.Fatalf("PutBlobMetadata() error = %v", err)
+			}
+
+			if err = storage.PutBlobFile(ctx, checksum, "output-thumbnail.png", []byte("png")); err != nil {
+				t.Fatalf("PutBlobFile() error = %v", err)
+			}
+
+			if content, err := storage.GetBlobMetadata(ctx, checksum, "refs.json"); err != nil || string(content) != `["upload-1"]` {
+				t.Errorf("GetBlobMetadata() = %q, %v", content, err)
+			}
+
+			if content, err := storage.GetBlobFile(ctx, checksum, "output-thumbnail.png"); err != nil || string(content) != "png" {
+				t.Errorf("GetBlobFile() = %q, %v", content, err)
+			}
+
+			// quarantined blob is never served again, nor are its metadata and files
+			if err = storage.QuarantineBlob(ctx, checksum); err != nil {
+				t.Fatalf("QuarantineBlob() error = %v", err)
+			}
+
+			if _, err = storage.StatBlob(ctx, checksum); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("StatBlob() after QuarantineBlob() error = %v, want os.ErrNotExist", err)
+			}
+
+			if _, err = storage.GetBlobMetadata(ctx, checksum, "refs.json"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("GetBlobMetadata() after QuarantineBlob() error = %v, want os.ErrNotExist", err)
+			}
+
+			// metadata of quarantined blob is kept, and may be rewritten, e.g. by key rotation
+			if quarantined, err := storage.ListQuarantined(ctx); err != nil || len(quarantined) != 1 || quarantined[0] != checksum {
+				t.Errorf("ListQuarantined() = %v, %v, want [%s]", quarantined, err, checksum)
+			}
+
+			if blobs, err := storage.ListBlobs(ctx); err != nil || len(blobs) != 0 {
+				t.Errorf("ListBlobs() after QuarantineBlob() = %v, %v, want none", blobs, err)
+			}
+
+			if content, err := storage.GetQuarantineMetadata(ctx, checksum, "refs.json"); err != nil || string(content) != `["upload-1"]` {
+				t.Errorf("GetQuarantineMetadata() = %q, %v", content, err)
+			}
+
+			if err = storage.PutQuarantineMetadata(ctx, checksum, "refs.json", []byte(`[]`)); err != nil {
+				t.Fatalf("PutQuarantineMetadata() error = %v", err)
+			}
+
+			if content, err := storage.GetQuarantineMetadata(ctx, checksum, "refs.json"); err != nil || string(content) != `[]` {
+				t.Errorf("GetQuarantineMetadata() after PutQuarantineMetadata() = %q, %v", content, err)
+			}
+
+			// blob of same content stored again is removed with its metadata
+			if checksum, err = composeBlob(ctx, storage, "upload-2", []string{"infected content"}); err != nil {
+				t.Fatalf("ComposeBlob() error = %v", err)
+			}
+
+			if err = storage.PutBlobMetadata(ctx, checksum, "refs.json", []byte(`["upload-2"]`)); err != nil {
+				t.Fatalf("PutBlobMetadata() error = %v", err)
+			}
+
+			if err = storage.DeleteBlob(ctx, checksum); err != nil {
+				t.Fatalf("DeleteBlob() error = %v", err)
+			}
+
+			if _, err = storage.OpenBlob(ctx, checksum); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("OpenBlob() after DeleteBlob() error = %v, want os.ErrNotExist", err)
+			}
+
+			if _, err = storage.GetBlobMetadata(ctx, checksum, "refs.json"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("GetBlobMetadata() after DeleteBlob() error = %v, want os.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStoragePutBlob(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		size    int64
+		wantErr bool
+	}{
+		{name: "declared size", content: "blob content", size: 12},
+		{name: "shorter than declared", content: "blob", size: 12, wantErr: true},
+	}
+
+	for name, storage := range testStorages(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+
+				hashChecksum := sha256.New()
+				var checksum string
+				_, err := storage.PutBlob(ctx, io.TeeReader(bytes.NewReader([]byte(tt.content)), hashChecksum), tt.size, func() (string, error) {
+					checksum = hex.EncodeToString(hashChecksum.Sum(nil))
+					return checksum, nil
+				})
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("PutBlob() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if tt.wantErr {
+					return
+				}
+
+				blob, err := storage.OpenBlob(ctx, checksum)
+				if err != nil {
+					t.Fatalf("OpenBlob() error = %v", err)
+				}
+
+				defer blob.Close()
+
+				if content, _ := io.ReadAll(blob); string(content) != tt.content {
+					t.Errorf("OpenBlob() = %q, want %q", content, tt.content)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"sync"
+)
+
+// tenantStorage routes every call to storage of tenant attached to context, storage of each tenant is opened once.
+// context without tenant is routed to default tenant
+type tenantStorage struct {
+	mu       sync.Mutex
+	storages map[string]entity.Storage
+	open     func(tenantID string) (entity.Storage, error)
+}
+
+// NewTenantStorage creates new instance of tenantStorage. it implements from interface Storage.
+// open creates storage of given tenant, storage of default tenant is opened right away so invalid config fails early
+func NewTenantStorage(open func(tenantID string) (entity.Storage, error)) (entity.Storage, error) {
+	t := &tenantStorage{
+		storages: map[string]entity.Storage{},
+		open:     open,
+	}
+
+	if _, err := t.storage(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// storage retrieves storage of tenant attached to context
+func (t *tenantStorage) storage(ctx context.Context) (entity.Storage, error) {
+	tenantID := entity.DefaultTenant
+	if tenant, ok := entity.TenantFromContext(ctx); ok {
+		tenantID = tenant.ID
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if storage, ok := t.storages[tenantID]; ok {
+		return storage, nil
+	}
+
+	storage, err := t.open(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	t.storages[tenantID] = storage
+	return storage, nil
+}
+
+func (t *tenantStorage) ListUploads(ctx context.Context) ([]string, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ListUploads(ctx)
+}
+
+func (t *tenantStorage) PutMetadata(ctx context.Context, uploadID, name string, content []byte) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.PutMetadata(ctx, uploadID, name, content)
+}
+
+func (t *tenantStorage) GetMetadata(ctx context.Context, uploadID, name string) ([]byte, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.GetMetadata(ctx, uploadID, name)
+}
+
+func (t *tenantStorage) PutChunk(ctx context.Context, uploadID string, chunkIndex int, content io.Reader) (int64, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.PutChunk(ctx, uploadID, chunkIndex, content)
+}
+
+func (t *tenantStorage) ListChunks(ctx context.Context, uploadID string) ([]entity.ChunkInfo, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ListChunks(ctx, uploadID)
+}
+
+func (t *tenantStorage) OpenChunk(ctx context.Context, uploadID string, chunkIndex int) (io.ReadCloser, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.OpenChunk(ctx, uploadID, chunkIndex)
+}
+
+func (t *tenantStorage) ComposeBlob(ctx context.Context, uploadID string, totalChunk int, w io.Writer, validate func() (string, error)) (int64, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.ComposeBlob(ctx, uploadID, totalChunk, w, validate)
+}
+
+func (t *tenantStorage) PutBlob(ctx context.Context, content io.Reader, size int64, validate func() (string, error)) (int64, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.PutBlob(ctx, content, size, validate)
+}
+
+func (t *tenantStorage) AdoptFinal(ctx context.Context, uploadID, filename string, w io.Writer, validate func() (string, error)) (int64, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.AdoptFinal(ctx, uploadID, filename, w, validate)
+}
+
+func (t *tenantStorage) StatBlob(ctx context.Context, checksum string) (*entity.FileInfo, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.StatBlob(ctx, checksum)
+}
+
+func (t *tenantStorage) OpenBlob(ctx context.Context, checksum string) (io.ReadSeekCloser, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.OpenBlob(ctx, checksum)
+}
+
+func (t *tenantStorage) ListBlobs(ctx context.Context) ([]string, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ListBlobs(ctx)
+}
+
+func (t *tenantStorage) PutBlobMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.PutBlobMetadata(ctx, checksum, name, content)
+}
+
+func (t *tenantStorage) GetBlobMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.GetBlobMetadata(ctx, checksum, name)
+}
+
+func (t *tenantStorage) PutBlobFile(ctx context.Context, checksum, name string, content []byte) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.PutBlobFile(ctx, checksum, name, content)
+}
+
+func (t *tenantStorage) GetBlobFile(ctx context.Context, checksum, name string) ([]byte, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.GetBlobFile(ctx, checksum, name)
+}
+
+func (t *tenantStorage) DeleteBlob(ctx context.Context, checksum string) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.DeleteBlob(ctx, checksum)
+}
+
+func (t *tenantStorage) QuarantineBlob(ctx context.Context, checksum string) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.QuarantineBlob(ctx, checksum)
+}
+
+func (t *tenantStorage) ListQuarantined(ctx context.Context) ([]string, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ListQuarantined(ctx)
+}
+
+func (t *tenantStorage) PutQuarantineMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.PutQuarantineMetadata(ctx, checksum, name, content)
+}
+
+func (t *tenantStorage) GetQuarantineMetadata(ctx context.Context, checksum, name string) ([]byte, error) {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.GetQuarantineMetadata(ctx, checksum, name)
+}
+
+func (t *tenantStorage) DeleteChunks(ctx context.Context, uploadID string) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.DeleteChunks(ctx, uploadID)
+}
+
+func (t *tenantStorage) Delete(ctx context.Context, uploadID string) error {
+	storage, err := t.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return storage.Delete(ctx, uploadID)
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-upload-chunk/server/internal/entity"
+	"net/http"
+)
+
+// AuthMiddleware authenticates caller by X-API-Key header or Authorization bearer token, then attaches its identity to request context.
+// nil authService disables authentication, OPTIONS request is sent without credential
+func AuthMiddleware(authService entity.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authService == nil || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		identity, err := authService.Authenticate(c.Request.Context(), new(entity.AuthRequestDTO).Header(c))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.Request = c.Request.WithContext(entity.ContextWithIdentity(c.Request.Context(), identity))
+		c.Next()
+	}
+}
+
+// ScopeMiddleware rejects caller which is not granted given scope. it is no-op if authentication is disabled
+func ScopeMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if identity, ok := entity.IdentityFromContext(c.Request.Context()); ok && !identity.HasScope(scope) {
+			abortWithError(c, entity.Errorf(entity.ErrCodeForbidden, "%s is not granted scope %s", identity.Subject, scope))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// abortWithError aborts request with json body describing error
+func abortWithError(c *gin.Context, err error) {
+	var appErr *entity.Error
+	if !errors.As(err, &appErr) {
+		appErr = entity.NewError(entity.ErrCodeUnauthorized, err)
+	}
+
+	var traceID string
+	if val, ok := c.Get("traceID"); ok {
+		traceID = fmt.Sprint(val)
+	}
+
+	c.AbortWithStatusJSON(appErr.StatusCode(), appErr.Response(traceID))
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitIdle is how long limit of client without request is kept
+const rateLimitIdle = 10 * time.Minute
+
+// RateLimitConfig is limit of each client, zero value means unlimited
+type RateLimitConfig struct {
+	ChunksPerSecond float64
+	ChunkBurst      int
+	BytesPerSecond  int64
+}
+
+// RateLimiter keeps token buckets of each client, open uploads of client are limited by upload service
+type RateLimiter struct {
+	config    RateLimitConfig
+	mu        sync.Mutex
+	clients   map[string]*clientLimit
+	lastSweep time.Time
+}
+
+type clientLimit struct {
+	chunks   *utils.TokenBucket
+	bytes    *utils.TokenBucket
+	lastSeen time.Time
+}
+
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:    config,
+		clients:   map[string]*clientLimit{},
+		lastSweep: time.Now(),
+	}
+}
+
+// acquire admits one upload request of client, otherwise returns reason and how long client must wait
+func (r *RateLimiter) acquire(key string, now time.Time) (string, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(now)
+
+	client, ok := r.clients[key]
+	if !ok {
+		client = &clientLimit{}
+		if r.config.ChunksPerSecond > 0 {
+			client.chunks = utils.NewTokenBucket(r.config.ChunksPerSecond, float64(max(r.config.ChunkBurst, 1)), now)
+		}
+
+		if r.config.BytesPerSecond > 0 {
+			client.bytes = utils.NewTokenBucket(float64(r.config.BytesPerSecond), float64(r.config.BytesPerSecond), now)
+		}
+
+		r.clients[key] = client
+	}
+
+	client.lastSeen = now
+
+	// bytes are charged after request body read, client in debt waits until it is paid
+	if client.bytes != nil {
+		if wait := client.bytes.Take(0, now); wait > 0 {
+			return "bytes_per_second", wait
+		}
+	}
+
+	if client.chunks != nil {
+		if wait := client.chunks.Take(1, now); wait > 0 {
+			return "chunks_per_second", wait
+		}
+	}
+
+	return "", 0
+}
+
+// release finishes upload request of client and charges bytes read from its body
+func (r *RateLimiter) release(key string, bytesRead int64, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[key]
+	if !ok {
+		return
+	}
+
+	client.lastSeen = now
+	if client.bytes != nil {
+		client.bytes.Charge(float64(bytesRead), now)
+	}
+}
+
+// sweep removes idle clients, at most once a minute
+func (r *RateLimiter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < time.Minute {
+		return
+	}
+
+	r.lastSweep = now
+	for key, client := range r.clients {
+		if now.Sub(client.lastSeen) > rateLimitIdle {
+			delete(r.clients, key)
+		}
+	}
+}
+
+// countingReader counts bytes read from request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// RateLimitMiddleware limits upload requests of each client, keyed by authenticated subject or client IP.
+// throttled request is responded 429 with Retry-After header
+func RateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if identity, ok := entity.IdentityFromContext(c.Request.Context()); ok {
+			key = "subject:" + identity.Subject
+		}
+
+		span := trace.SpanFromContext(c.Request.Context())
+		span.SetAttributes(attribute.String("ratelimit.key", key))
+
+		reason, wait := limiter.acquire(key, time.Now())
+		if reason != "" {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			span.SetAttributes(
+				attribute.Bool("ratelimit.throttled", true),
+				attribute.String("ratelimit.reason", reason),
+				attribute.Int("ratelimit.retry_after", retryAfter),
+			)
+
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			abortWithError(c, entity.Errorf(entity.ErrCodeRateLimited, "rate limit exceeded : %s, retry after %d seconds", reason, retryAfter))
+			return
+		}
+
+		span.SetAttributes(attribute.Bool("ratelimit.throttled", false))
+
+		body := &countingReader{ReadCloser: c.Request.Body}
+		c.Request.Body = body
+
+		defer func() {
+			limiter.release(key, body.n, time.Now())
+			span.SetAttributes(attribute.Int64("ratelimit.bytes", body.n))
+		}()
+
+		c.Next()
+	}
+}
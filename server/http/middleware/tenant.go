@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-upload-chunk/server/internal/entity"
+)
+
+// TenantMiddleware resolves tenant of caller by its identity or X-Tenant-ID header, then attaches it to request context.
+// storage and services only see uploads of that tenant
+func TenantMiddleware(tenantService entity.TenantService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, err := tenantService.Resolve(c.Request.Context(), new(entity.TenantRequestDTO).Header(c))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.Header("X-Tenant-ID", tenant.ID)
+		c.Request = c.Request.WithContext(entity.ContextWithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
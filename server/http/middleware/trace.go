@@ -12,6 +12,9 @@ import (
 	"go.opentelemetry.io/otel/propagation"
 )
 
+// maxRecordedBody is max size of response body recorded into span, file download is not recorded entirely
+const maxRecordedBody = 4096
+
 type CustomWriter struct {
 	gin.ResponseWriter
 	body *bytes.Buffer
@@ -22,7 +25,10 @@ func NewCustomWriter(rsp gin.ResponseWriter, body *bytes.Buffer) *CustomWriter {
 }
 
 func (c *CustomWriter) Write(b []byte) (int, error) {
-	c.body.Write(b)
+	if remaining := maxRecordedBody - c.body.Len(); remaining > 0 {
+		c.body.Write(b[:min(len(b), remaining)])
+	}
+
 	return c.ResponseWriter.Write(b)
 }
 
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-upload-chunk/server/internal/entity"
+	"net/http"
+)
+
+// TusResumableMiddleware checks Tus-Resumable header sent by client and sets it to every response
+func TusResumableMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", entity.TusResumable)
+
+		// OPTIONS request may be sent without Tus-Resumable header
+		if c.Request.Method != http.MethodOptions && c.Request.Header.Get("Tus-Resumable") != entity.TusResumable {
+			c.Header("Tus-Version", entity.TusResumable)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+
+		c.Next()
+	}
+}
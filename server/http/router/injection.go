@@ -2,11 +2,56 @@ package router
 
 import (
 	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/drivers/processor"
+	"go-upload-chunk/server/http/middleware"
 	"go-upload-chunk/server/internal/controller"
+	"go-upload-chunk/server/internal/entity"
 	"go-upload-chunk/server/internal/service"
+	"strings"
 )
 
-func InitFileController(validate *validator.Validate) *controller.FileController {
-	fileService := service.NewFileService(validate)
-	return controller.NewFileController(fileService)
+func InitFileController(validate *validator.Validate, storage entity.Storage, webhook entity.WebhookService, pipeline entity.PipelineService, scanner entity.Scanner, locks *service.UploadLocks) *controller.FileController {
+	fileService := service.NewFileService(validate, storage, webhook, pipeline, scanner, locks, InitUploadConfig())
+	return controller.NewFileController(fileService, config.MaxChunkSize(), config.MaxDecompressionRatio())
+}
+
+func InitTusController(validate *validator.Validate, storage entity.Storage, webhook entity.WebhookService, pipeline entity.PipelineService, scanner entity.Scanner, locks *service.UploadLocks) *controller.TusController {
+	tusService := service.NewTusService(validate, storage, webhook, pipeline, scanner, locks, InitUploadConfig())
+	return controller.NewTusController(tusService, config.MaxChunkSize())
+}
+
+func InitUploadConfig() service.UploadConfig {
+	return service.UploadConfig{
+		ConcurrentUploads: config.RateLimitConcurrentUploads(),
+		ScanTimeout:       config.ScanTimeout(),
+		TusExpiration:     config.TusExpiration(),
+	}
+}
+
+func InitRateLimiter() *middleware.RateLimiter {
+	return middleware.NewRateLimiter(middleware.RateLimitConfig{
+		ChunksPerSecond: config.RateLimitChunksPerSecond(),
+		ChunkBurst:      config.RateLimitChunkBurst(),
+		BytesPerSecond:  config.RateLimitBytesPerSecond(),
+	})
+}
+
+func InitWebhookService() (entity.WebhookService, error) {
+	return service.NewWebhookService(service.WebhookConfig{
+		URLs:        strings.Split(config.WebhookURLs(), ","),
+		Secret:      config.WebhookSecret(),
+		QueueFolder: config.WebhookQueueFolder(),
+		MaxAttempts: config.WebhookMaxAttempts(),
+		Timeout:     config.WebhookTimeout(),
+	})
+}
+
+func InitPipelineService(storage entity.Storage, locks *service.UploadLocks) (entity.PipelineService, error) {
+	processors, err := processor.NewProcessors(config.Processors())
+	if err != nil {
+		return nil, err
+	}
+
+	return service.NewPipelineService(storage, processors, config.ProcessingWorkers(), config.ProcessingQueueSize(), locks), nil
 }
@@ -3,18 +3,49 @@ package router
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/http/middleware"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/service"
 )
 
-func SetupRouter(app *gin.RouterGroup, validate *validator.Validate) {
+func SetupRouter(app *gin.RouterGroup, validate *validator.Validate, storage entity.Storage, authService entity.AuthService, tenantService entity.TenantService, webhookService entity.WebhookService, pipelineService entity.PipelineService, scanner entity.Scanner, locks *service.UploadLocks) {
 	// init dependency injection
-	fileController := InitFileController(validate)
+	fileController := InitFileController(validate, storage, webhookService, pipelineService, scanner, locks)
+	tusController := InitTusController(validate, storage, webhookService, pipelineService, scanner, locks)
+	rateLimit := middleware.RateLimitMiddleware(InitRateLimiter())
 
-	apiV1 := app.Group("v1")
+	var (
+		scopeUpload = middleware.ScopeMiddleware(entity.ScopeUpload)
+		scopeRead   = middleware.ScopeMiddleware(entity.ScopeRead)
+		scopeAdmin  = middleware.ScopeMiddleware(entity.ScopeAdmin)
+	)
+
+	apiV1 := app.Group("v1", middleware.AuthMiddleware(authService), middleware.TenantMiddleware(tenantService))
 	{
 		// upload file chunk
 		fileGroup := apiV1.Group("file")
 		{
-			fileGroup.POST("/chunk", fileController.UploadChunk)
+			fileGroup.POST("/session", scopeUpload, rateLimit, fileController.CreateSession)
+			fileGroup.GET("/session/:id/status", scopeUpload, fileController.GetStatus)
+			fileGroup.DELETE("/session/:id", scopeUpload, fileController.AbortUpload)
+			fileGroup.POST("/chunk", scopeUpload, rateLimit, fileController.UploadChunk)
+			fileGroup.GET("", scopeRead, fileController.ListFiles)
+			fileGroup.GET("/:id", scopeRead, fileController.Download)
+			fileGroup.HEAD("/:id", scopeRead, fileController.Download)
+			fileGroup.GET("/:id/metadata", scopeRead, fileController.GetMetadata)
+			fileGroup.GET("/:id/processing", scopeRead, fileController.GetProcessing)
+			fileGroup.GET("/:id/processing/:name", scopeRead, fileController.DownloadOutput)
+			fileGroup.DELETE("/:id", scopeAdmin, fileController.DeleteFile)
+		}
+
+		// tus 1.0 resumable upload protocol
+		tusGroup := apiV1.Group("tus", middleware.TusResumableMiddleware(), scopeUpload)
+		{
+			tusGroup.OPTIONS("", tusController.Options)
+			tusGroup.POST("", rateLimit, tusController.CreateUpload)
+			tusGroup.HEAD("/:id", tusController.GetOffset)
+			tusGroup.PATCH("/:id", rateLimit, tusController.WriteChunk)
+			tusGroup.DELETE("/:id", tusController.Terminate)
 		}
 	}
 }
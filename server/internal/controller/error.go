@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/utils"
+	"net/http"
+)
+
+// rateLimitRetryAfter is Retry-After seconds of request limited by service, e.g. client has too many open uploads
+const rateLimitRetryAfter = "5"
+
+// responseError aborts request with json body describing error. error which is not entity.Error is treated as storage failure
+func responseError(c *gin.Context, err error) {
+	var (
+		appErr           *entity.Error
+		validationErrors validator.ValidationErrors
+		maxBytesError    *http.MaxBytesError
+	)
+
+	switch {
+	case errors.As(err, &appErr):
+	case errors.As(err, &validationErrors):
+		appErr = entity.NewError(entity.ErrCodeValidation, err)
+	case errors.As(err, &maxBytesError), errors.Is(err, utils.ErrDecodeLimit):
+		appErr = entity.NewError(entity.ErrCodeTooLarge, err)
+	case errors.Is(err, utils.ErrCorruptEncoding):
+		appErr = entity.NewError(entity.ErrCodeValidation, err)
+	default:
+		appErr = entity.NewError(entity.ErrCodeStorageFailure, err)
+	}
+
+	SetRetryAfter(c, appErr)
+
+	var traceID string
+	if val, ok := c.Get("traceID"); ok {
+		traceID = fmt.Sprint(val)
+	}
+
+	c.AbortWithStatusJSON(appErr.StatusCode(), appErr.Response(traceID))
+}
+
+// SetRetryAfter tells rate limited client when to retry, unless limit which rejected it already told
+func SetRetryAfter(c *gin.Context, appErr *entity.Error) {
+	if appErr.Code == entity.ErrCodeRateLimited && c.Writer.Header().Get("Retry-After") == "" {
+		c.Header("Retry-After", rateLimitRetryAfter)
+	}
+}
@@ -2,45 +2,89 @@ package controller
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"go-upload-chunk/server/internal/entity"
 	"go-upload-chunk/server/internal/utils"
-	"io"
+	"mime"
 	"net/http"
+	"time"
 )
 
 type FileController struct {
-	fileService entity.FileService
+	fileService           entity.FileService
+	maxChunkSize          int64
+	maxDecompressionRatio int64
 }
 
-func NewFileController(fileService entity.FileService) *FileController {
-	return &FileController{fileService: fileService}
+// NewFileController creates new instance of FileController. maxChunkSize limits chunk of caller without tenant,
+// maxDecompressionRatio limits ratio of decoded to encoded size of compressed chunk, 0 means unlimited
+func NewFileController(fileService entity.FileService, maxChunkSize, maxDecompressionRatio int64) *FileController {
+	return &FileController{fileService: fileService, maxChunkSize: maxChunkSize, maxDecompressionRatio: maxDecompressionRatio}
+}
+
+// CreateSession creates new upload session and responses its upload ID
+func (f *FileController) CreateSession(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// bind request body
+	var request entity.CreateSessionRequestDTO
+	if err := c.ShouldBindJSON(&request); err != nil {
+		logger.Error(err)
+		responseError(c, entity.NewError(entity.ErrCodeValidation, err))
+		return
+	}
+
+	request.Uploader = c.ClientIP()
+
+	// call method in service
+	session, err := f.fileService.CreateSession(c.Request.Context(), request)
+	if err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	// success create session
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success create session",
+		"data":    session,
+	})
 }
 
 // UploadChunk uploads file for chunks
 func (f *FileController) UploadChunk(c *gin.Context) {
 	logger := logrus.WithContext(c)
 
-	// get request body (binary)
-	buf := utils.ByteBufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		buf.Reset()
-		utils.ByteBufferPool.Put(buf)
-	}()
+	// request body (binary) is streamed to storage, reject chunk bigger than max chunk size
+	maxChunkSize := MaxChunkSize(c, f.maxChunkSize)
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxChunkSize)
+	defer body.Close()
 
-	// copy from request body to buffer
-	if _, err := io.Copy(buf, c.Request.Body); err != nil {
+	// chunk may be compressed on the wire, it is decoded while streamed and checksum is of decoded bytes
+	content, err := utils.NewDecodeReader(c.GetHeader("Content-Encoding"), body, maxChunkSize, f.maxDecompressionRatio)
+	if err != nil {
 		logger.Error(err)
+		if errors.Is(err, utils.ErrUnsupportedEncoding) {
+			c.Header("Accept-Encoding", utils.SupportedEncodings)
+			err = entity.NewError(entity.ErrCodeUnsupportedMediaType, err)
+		}
+
+		responseError(c, err)
 		return
 	}
 
+	defer content.Close()
+
 	// call method in service
-	if err := f.fileService.UploadChunk(c.Request.Context(), entity.UploadChunkRequestServiceDTO{
+	if err = f.fileService.UploadChunk(c.Request.Context(), entity.UploadChunkRequestServiceDTO{
 		RequestHeader: new(entity.RequestHeaderDTO).Header(c),
-		Content:       buf,
+		Content:       content,
 	}); err != nil {
 		logger.Error(err)
+		responseError(c, err)
 		return
 	}
 
@@ -49,3 +93,162 @@ func (f *FileController) UploadChunk(c *gin.Context) {
 		"message": "success upload",
 	})
 }
+
+// GetStatus responses received and missing chunks of upload session
+func (f *FileController) GetStatus(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// call method in service
+	status, err := f.fileService.GetStatus(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success get upload status",
+		"data":    status,
+	})
+}
+
+// Download responses final file, supports HEAD, Range, If-Range and conditional requests
+func (f *FileController) Download(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// call method in service
+	metadata, content, err := f.fileService.OpenFile(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	defer content.Close()
+
+	// ETag must be set before ServeContent, it is compared with If-Range and If-None-Match
+	c.Header("ETag", fmt.Sprintf("%q", metadata.CheckSum))
+	c.Header("Content-Type", metadata.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": metadata.Filename}))
+
+	http.ServeContent(c.Writer, c.Request, metadata.Filename, metadata.CompletedAt, content)
+}
+
+// ListFiles responses catalogue of final files
+func (f *FileController) ListFiles(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// bind query string
+	var request entity.ListFileRequestDTO
+	if err := c.ShouldBindQuery(&request); err != nil {
+		logger.Error(err)
+		responseError(c, entity.NewError(entity.ErrCodeValidation, err))
+		return
+	}
+
+	// call method in service
+	files, err := f.fileService.ListFiles(c.Request.Context(), request)
+	if err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success list files",
+		"data":    files,
+	})
+}
+
+// GetMetadata responses metadata of final file
+func (f *FileController) GetMetadata(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// call method in service
+	metadata, err := f.fileService.GetFileMetadata(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success get file metadata",
+		"data":    metadata,
+	})
+}
+
+// GetProcessing responses state and outputs of processors of final file
+func (f *FileController) GetProcessing(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// call method in service
+	status, err := f.fileService.GetProcessing(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success get file processing",
+		"data":    status,
+	})
+}
+
+// DownloadOutput responses output of processor of final file, e.g. thumbnail
+func (f *FileController) DownloadOutput(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// call method in service
+	output, err := f.fileService.OpenOutput(c.Request.Context(), c.Param("id"), c.Param("name"))
+	if err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", output.ContentType)
+	http.ServeContent(c.Writer, c.Request, output.Name, time.Time{}, bytes.NewReader(output.Content))
+}
+
+// AbortUpload cancels upload which is not completed yet
+func (f *FileController) AbortUpload(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// call method in service
+	if err := f.fileService.AbortUpload(c.Request.Context(), c.Param("id")); err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success abort upload",
+	})
+}
+
+// DeleteFile deletes final file of completed upload
+func (f *FileController) DeleteFile(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	// call method in service
+	if err := f.fileService.DeleteFile(c.Request.Context(), c.Param("id")); err != nil {
+		logger.Error(err)
+		responseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success delete file",
+	})
+}
+
+// MaxChunkSize retrieves max size of one chunk request body, it is limit of tenant of caller, or fallback without tenant
+func MaxChunkSize(c *gin.Context, fallback int64) int64 {
+	if tenant, ok := entity.TenantFromContext(c.Request.Context()); ok {
+		return tenant.MaxChunkSize
+	}
+
+	return fallback
+}
@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-upload-chunk/server/drivers/keyprovider"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// downloadFileService serves blobs of storage as final files, upload ID is checksum of blob
+type downloadFileService struct {
+	entity.FileService
+	storage entity.Storage
+}
+
+func (d *downloadFileService) OpenFile(ctx context.Context, uploadID string) (*entity.FileMetadata, io.ReadSeekCloser, error) {
+	content, err := d.storage.OpenBlob(ctx, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &entity.FileMetadata{
+		UploadID:    uploadID,
+		Filename:    "report.bin",
+		ContentType: "application/octet-stream",
+		CheckSum:    uploadID,
+		CompletedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, content, nil
+}
+
+// fakeS3Objects is in-memory S3 compatible server, it supports requests sent to put, copy and read one object only
+type fakeS3Objects struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3Objects) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch source := r.Header.Get("x-amz-copy-source"); {
+	case r.Method == http.MethodPut && source != "":
+		content, ok := f.objects[source]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		f.objects[r.URL.Path] = bytes.Clone(content)
+		_, _ = fmt.Fprint(w, "<CopyObjectResult><ETag>\"copy\"</ETag></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		f.objects[r.URL.Path] = body
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		content, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(content))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestEncryptedStorage(t *testing.T, fileStorage entity.Storage) entity.Storage {
+	t.Helper()
+
+	keyProvider, err := keyprovider.NewLocalKeyProvider(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider() error = %v", err)
+	}
+
+	if _, err = keyProvider.GenerateKey(context.Background()); err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	return storage.NewEncryptedStorage(fileStorage, keyProvider)
+}
+
+func newTestS3Storage(t *testing.T) entity.Storage {
+	t.Helper()
+
+	server := httptest.NewServer(&fakeS3Objects{objects: map[string][]byte{}})
+	t.Cleanup(server.Close)
+
+	return storage.NewS3Storage(storage.S3Config{Endpoint: server.URL, Bucket: "bucket", AccessKey: "access", SecretKey: "secret"})
+}
+
+// newTestDownloadRouter stores content as blob of given storage and routes its download, upload ID is returned
+func newTestDownloadRouter(t *testing.T, fileStorage entity.Storage, content []byte) (*gin.Engine, string) {
+	t.Helper()
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	if _, err := fileStorage.PutBlob(context.Background(), bytes.NewReader(content), int64(len(content)), func() (string, error) {
+		return checksum, nil
+	}); err != nil {
+		t.Fatalf("PutBlob() error = %v", err)
+	}
+
+	fileController := NewFileController(&downloadFileService{storage: fileStorage}, 1<<20, 100)
+
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.GET("/file/:id", fileController.Download)
+	app.HEAD("/file/:id", fileController.Download)
+	return app, checksum
+}
+
+func TestDownloadRange(t *testing.T) {
+	// content spans three GCM segments of 64 KiB, so ranges can cross segment boundary
+	content := make([]byte, 150_000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	type part struct {
+		first, last int
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+		wantParts  []part // none means whole content, one means single range body, more means multipart body
+		wantBody   bool
+	}{
+		{name: "whole file", method: http.MethodGet, wantStatus: http.StatusOK, wantBody: true},
+		{name: "head", method: http.MethodHead, wantStatus: http.StatusOK},
+		{name: "first bytes", method: http.MethodGet, header: map[string]string{"Range": "bytes=0-99"}, wantStatus: http.StatusPartialContent, wantParts: []part{{0, 99}}, wantBody: true},
+		{name: "range across segment boundary", method: http.MethodGet, header: map[string]string{"Range": "bytes=65500-65599"}, wantStatus: http.StatusPartialContent, wantParts: []part{{65500, 65599}}, wantBody: true},
+		{name: "range across two segment boundaries", method: http.MethodGet, header: map[string]string{"Range": "bytes=60000-140000"}, wantStatus: http.StatusPartialContent, wantParts: []part{{60000, 140000}}, wantBody: true},
+		{name: "suffix range", method: http.MethodGet, header: map[string]string{"Range": "bytes=-100"}, wantStatus: http.StatusPartialContent, wantParts: []part{{149_900, 149_999}}, wantBody: true},
+		{name: "open range", method: http.MethodGet, header: map[string]string{"Range": "bytes=131000-"}, wantStatus: http.StatusPartialContent, wantParts: []part{{131000, 149_999}}, wantBody: true},
+		{
+			name:       "multi range",
+			method:     http.MethodGet,
+			header:     map[string]string{"Range": "bytes=0-9,65530-65545,149990-"},
+			wantStatus: http.StatusPartialContent,
+			wantParts:  []part{{0, 9}, {65530, 65545}, {149_990, 149_999}},
+			wantBody:   true,
+		},
+		{name: "head of range", method: http.MethodHead, header: map[string]string{"Range": "bytes=65500-65599"}, wantStatus: http.StatusPartialContent, wantParts: []part{{65500, 65599}}},
+		{name: "unsatisfiable range", method: http.MethodGet, header: map[string]string{"Range": "bytes=200000-"}, wantStatus: http.StatusRequestedRangeNotSatisfiable},
+		{name: "if-range of current etag", method: http.MethodGet, header: map[string]string{"Range": "bytes=65500-65599", "If-Range": "etag"}, wantStatus: http.StatusPartialContent, wantParts: []part{{65500, 65599}}, wantBody: true},
+		{name: "if-range of stale etag", method: http.MethodGet, header: map[string]string{"Range": "bytes=65500-65599", "If-Range": `"stale"`}, wantStatus: http.StatusOK, wantBody: true},
+	}
+
+	storages := map[string]func(t *testing.T) entity.Storage{
+		"memory": func(t *testing.T) entity.Storage {
+			return storage.NewMemoryStorage()
+		},
+		"encrypted memory": func(t *testing.T) entity.Storage {
+			return newTestEncryptedStorage(t, storage.NewMemoryStorage())
+		},
+		"s3": newTestS3Storage,
+		"encrypted s3": func(t *testing.T) entity.Storage {
+			return newTestEncryptedStorage(t, newTestS3Storage(t))
+		},
+	}
+
+	for storageName, newStorage := range storages {
+		t.Run(storageName, func(t *testing.T) {
+			app, uploadID := newTestDownloadRouter(t, newStorage(t), content)
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					request := httptest.NewRequest(tt.method, "/file/"+uploadID, nil)
+					for key, value := range tt.header {
+						// "etag" stands for ETag of downloaded file
+						request.Header.Set(key, strings.ReplaceAll(value, "etag", strconv.Quote(uploadID)))
+					}
+
+					recorder := httptest.NewRecorder()
+					app.ServeHTTP(recorder, request)
+
+					if recorder.Code != tt.wantStatus {
+						t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+					}
+
+					if tt.wantStatus == http.StatusRequestedRangeNotSatisfiable {
+						return
+					}
+
+					if etag := recorder.Header().Get("ETag"); etag != strconv.Quote(uploadID) {
+						t.Errorf("ETag = %s, want %q", etag, uploadID)
+					}
+
+					if !tt.wantBody && recorder.Body.Len() != 0 {
+						t.Errorf("HEAD body has %d bytes, want none", recorder.Body.Len())
+					}
+
+					switch len(tt.wantParts) {
+					case 0:
+						if length := recorder.Header().Get("Content-Length"); length != strconv.Itoa(len(content)) {
+							t.Errorf("Content-Length = %s, want %d", length, len(content))
+						}
+
+						if tt.wantBody && !bytes.Equal(recorder.Body.Bytes(), content) {
+							t.Errorf("body differs from content, %d bytes", recorder.Body.Len())
+						}
+					case 1:
+						want := tt.wantParts[0]
+						if contentRange := recorder.Header().Get("Content-Range"); contentRange != fmt.Sprintf("bytes %d-%d/%d", want.first, want.last, len(content)) {
+							t.Errorf("Content-Range = %s, want bytes %d-%d/%d", contentRange, want.first, want.last, len(content))
+						}
+
+						if tt.wantBody && !bytes.Equal(recorder.Body.Bytes(), content[want.first:want.last+1]) {
+							t.Errorf("body differs from content[%d:%d]", want.first, want.last+1)
+						}
+					default:
+						mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+						if err != nil || mediaType != "multipart/byteranges" {
+							t.Fatalf("Content-Type = %s, want multipart/byteranges", recorder.Header().Get("Content-Type"))
+						}
+
+						reader := multipart.NewReader(recorder.Body, params["boundary"])
+						for _, want := range tt.wantParts {
+							p, err := reader.NextPart()
+							if err != nil {
+								t.Fatalf("NextPart() error = %v", err)
+							}
+
+							if contentRange := p.Header.Get("Content-Range"); contentRange != fmt.Sprintf("bytes %d-%d/%d", want.first, want.last, len(content)) {
+								t.Errorf("part Content-Range = %s, want bytes %d-%d/%d", contentRange, want.first, want.last, len(content))
+							}
+
+							if body, err := io.ReadAll(p); err != nil || !bytes.Equal(body, content[want.first:want.last+1]) {
+								t.Errorf("part body differs from content[%d:%d], error = %v", want.first, want.last+1, err)
+							}
+						}
+
+						if _, err = reader.NextPart(); err != io.EOF {
+							t.Errorf("NextPart() after last range error = %v, want %v", err, io.EOF)
+						}
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"net/http"
+	"strconv"
+)
+
+type TusController struct {
+	tusService   entity.TusService
+	maxChunkSize int64
+}
+
+// NewTusController creates new instance of TusController. maxChunkSize limits PATCH body of caller without tenant
+func NewTusController(tusService entity.TusService, maxChunkSize int64) *TusController {
+	return &TusController{tusService: tusService, maxChunkSize: maxChunkSize}
+}
+
+// Options responses tus protocol capabilities of server
+func (t *TusController) Options(c *gin.Context) {
+	c.Header("Tus-Version", entity.TusResumable)
+	c.Header("Tus-Extension", entity.TusExtension)
+	c.Header("Tus-Checksum-Algorithm", entity.TusChecksumAlgorithm)
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload creates new tus upload, its URL is sent in Location header
+func (t *TusController) CreateUpload(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	info, err := t.tusService.CreateUpload(c.Request.Context(), new(entity.TusCreateRequestDTO).Header(c))
+	if err != nil {
+		logger.Error(err)
+		t.abortWithError(c, err)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("%s/%s", c.Request.URL.Path, info.Session.ID))
+	c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// GetOffset responses current offset of tus upload
+func (t *TusController) GetOffset(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	info, err := t.tusService.GetUpload(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		logger.Error(err)
+		t.abortWithError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(info.Session.TotalSize, 10))
+	c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// WriteChunk appends request body to tus upload
+func (t *TusController) WriteChunk(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	if c.ContentType() != entity.TusContentType {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// one PATCH request is one chunk, so it is limited like chunk of chunk upload
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, MaxChunkSize(c, t.maxChunkSize))
+	defer body.Close()
+
+	request := new(entity.TusPatchRequestDTO).Header(c)
+	request.Content = body
+
+	info, err := t.tusService.WriteChunk(c.Request.Context(), request)
+	if err != nil {
+		logger.Error(err)
+		t.abortWithError(c, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate removes tus upload
+func (t *TusController) Terminate(c *gin.Context) {
+	logger := logrus.WithContext(c)
+
+	if err := t.tusService.Terminate(c.Request.Context(), c.Param("id")); err != nil {
+		logger.Error(err)
+		t.abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// abortWithError maps error to http status code defined by tus protocol
+func (t *TusController) abortWithError(c *gin.Context, err error) {
+	var (
+		validationErrors validator.ValidationErrors
+		appErr           *entity.Error
+		maxBytesError    *http.MaxBytesError
+	)
+
+	switch {
+	case errors.Is(err, entity.ErrTusNotFound):
+		c.AbortWithStatus(http.StatusNotFound)
+	case errors.Is(err, entity.ErrTusExpired):
+		c.AbortWithStatus(http.StatusGone)
+	case errors.Is(err, entity.ErrTusOffsetMismatch):
+		c.AbortWithStatus(http.StatusConflict)
+	case errors.Is(err, entity.ErrTusChecksumAlgorithm), errors.As(err, &validationErrors):
+		c.AbortWithStatus(http.StatusBadRequest)
+	case errors.As(err, &maxBytesError):
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+	case errors.Is(err, entity.ErrTusChecksumMismatch):
+		// 460 Checksum Mismatch, defined by tus checksum extension
+		c.AbortWithStatus(460)
+	case errors.As(err, &appErr):
+		SetRetryAfter(c, appErr)
+		c.AbortWithStatus(appErr.StatusCode())
+	default:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/http/middleware"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTusRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	validate := validator.New()
+	if err := service.RegisterFilenameValidation(validate); err != nil {
+		t.Fatalf("RegisterFilenameValidation() error = %v", err)
+	}
+
+	tusService := service.NewTusService(validate, storage.NewMemoryStorage(), nil, nil, nil, service.NewUploadLocks(), service.UploadConfig{TusExpiration: time.Hour})
+	tusController := NewTusController(tusService, 1<<20)
+
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	tusGroup := app.Group("tus", middleware.TusResumableMiddleware())
+	{
+		tusGroup.POST("", tusController.CreateUpload)
+		tusGroup.HEAD("/:id", tusController.GetOffset)
+		tusGroup.PATCH("/:id", tusController.WriteChunk)
+		tusGroup.DELETE("/:id", tusController.Terminate)
+	}
+
+	return app
+}
+
+func serveTus(app *gin.Engine, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Tus-Resumable", entity.TusResumable)
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	app.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestTusTerminateStatus(t *testing.T) {
+	// first PATCH carries enough bytes to detect content type
+	content := strings.Repeat("hello ", 1024)
+
+	tests := []struct {
+		name       string
+		patch      string
+		unknown    bool
+		wantStatus int
+	}{
+		{name: "unfinished upload", patch: content[:4096], wantStatus: http.StatusNoContent},
+		{name: "completed upload", patch: content, wantStatus: http.StatusConflict},
+		{name: "unknown upload", unknown: true, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestTusRouter(t)
+
+			created := serveTus(app, http.MethodPost, "/tus", "", map[string]string{
+				"Upload-Length":   strconv.Itoa(len(content)),
+				"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("hello.txt")),
+			})
+			if created.Code != http.StatusCreated {
+				t.Fatalf("POST status = %d, want %d", created.Code, http.StatusCreated)
+			}
+
+			location := created.Header().Get("Location")
+			if tt.patch != "" {
+				patched := serveTus(app, http.MethodPatch, location, tt.patch, map[string]string{
+					"Content-Type":  entity.TusContentType,
+					"Upload-Offset": "0",
+				})
+				if patched.Code != http.StatusNoContent {
+					t.Fatalf("PATCH status = %d, want %d", patched.Code, http.StatusNoContent)
+				}
+			}
+
+			target := location
+			if tt.unknown {
+				target = "/tus/unknown"
+			}
+
+			if terminated := serveTus(app, http.MethodDelete, target, "", nil); terminated.Code != tt.wantStatus {
+				t.Fatalf("DELETE status = %d, want %d", terminated.Code, tt.wantStatus)
+			}
+
+			// completed upload is kept, terminated one is gone
+			wantStatus := http.StatusOK
+			if tt.wantStatus == http.StatusNoContent {
+				wantStatus = http.StatusNotFound
+			}
+
+			if head := serveTus(app, http.MethodHead, location, "", nil); head.Code != wantStatus {
+				t.Errorf("HEAD status after DELETE = %d, want %d", head.Code, wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package entity
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// scopes granted to caller, admin scope grants every scope
+const (
+	ScopeUpload = "upload"
+	ScopeRead   = "read"
+	ScopeAdmin  = "admin"
+)
+
+type AuthService interface {
+	Authenticate(ctx context.Context, request AuthRequestDTO) (*Identity, error)
+}
+
+// Identity is authenticated caller of request
+type Identity struct {
+	Subject string   `json:"subject"`
+	Method  string   `json:"method"`
+	Scopes  []string `json:"scopes"`
+	Tenant  string   `json:"tenant,omitempty"` // empty if caller may pick tenant by X-Tenant-ID header
+}
+
+// HasScope checks whether identity is granted given scope
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+type identityContextKey struct{}
+
+// ContextWithIdentity attaches identity of caller to context
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext retrieves identity of caller, not found if authentication is disabled
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return identity, ok
+}
+
+type AuthRequestDTO struct {
+	APIKey      string `json:"api_key"`
+	BearerToken string `json:"bearer_token"`
+}
+
+func (r *AuthRequestDTO) Header(c *gin.Context) AuthRequestDTO {
+	r.APIKey = c.Request.Header.Get("X-API-Key")
+
+	if scheme, token, ok := strings.Cut(c.Request.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		r.BearerToken = strings.TrimSpace(token)
+	}
+
+	return *r
+}
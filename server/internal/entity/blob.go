@@ -0,0 +1,10 @@
+package entity
+
+import "time"
+
+// BlobRefs is uploads whose final file is stored as one blob, blob is removed once last reference removed
+type BlobRefs struct {
+	CheckSum  string    `json:"check_sum"`
+	Uploads   []string  `json:"uploads"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,106 @@
+package entity
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type ErrorCode string
+
+const (
+	ErrCodeValidation       ErrorCode = "VALIDATION_ERROR"
+	ErrCodeChecksumMismatch ErrorCode = "CHECKSUM_MISMATCH"
+	// ErrCodeFileChecksumMismatch is checksum mismatch of assembled file, whole upload must be sent again
+	ErrCodeFileChecksumMismatch ErrorCode = "FILE_CHECKSUM_MISMATCH"
+	ErrCodeConflict             ErrorCode = "CONFLICT"
+	ErrCodeNotFound             ErrorCode = "NOT_FOUND"
+	ErrCodeStorageFailure       ErrorCode = "STORAGE_FAILURE"
+	ErrCodeTooLarge             ErrorCode = "TOO_LARGE"
+	ErrCodeUnauthorized         ErrorCode = "UNAUTHORIZED"
+	ErrCodeForbidden            ErrorCode = "FORBIDDEN"
+	ErrCodeRateLimited          ErrorCode = "RATE_LIMITED"
+	ErrCodeUnsupportedMediaType ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	ErrCodeUnavailable          ErrorCode = "UNAVAILABLE"
+	ErrCodeInfected             ErrorCode = "INFECTED"
+)
+
+// Error is error returned by service, its code decides http status code and whether client should retry
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func NewError(code ErrorCode, err error) *Error {
+	return &Error{
+		Code: code,
+		Err:  err,
+	}
+}
+
+// Errorf creates new Error with formatted message, %w verb is supported
+func Errorf(code ErrorCode, format string, a ...any) *Error {
+	return NewError(code, fmt.Errorf(format, a...))
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode maps error code to http status code
+func (e *Error) StatusCode() int {
+	switch e.Code {
+	case ErrCodeValidation:
+		return http.StatusBadRequest
+	case ErrCodeChecksumMismatch, ErrCodeFileChecksumMismatch, ErrCodeInfected:
+		return http.StatusUnprocessableEntity
+	case ErrCodeConflict:
+		return http.StatusConflict
+	case ErrCodeNotFound:
+		return http.StatusNotFound
+	case ErrCodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrCodeUnauthorized:
+		return http.StatusUnauthorized
+	case ErrCodeForbidden:
+		return http.StatusForbidden
+	case ErrCodeRateLimited:
+		return http.StatusTooManyRequests
+	case ErrCodeUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case ErrCodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Retryable tells whether the same request may succeed if sent again
+func (e *Error) Retryable() bool {
+	switch e.Code {
+	case ErrCodeChecksumMismatch, ErrCodeConflict, ErrCodeStorageFailure, ErrCodeRateLimited, ErrCodeUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// Response creates json body describing error
+func (e *Error) Response(traceID string) ErrorResponseDTO {
+	return ErrorResponseDTO{
+		Code:      e.Code,
+		Message:   e.Error(),
+		TraceID:   traceID,
+		Retryable: e.Retryable(),
+	}
+}
+
+type ErrorResponseDTO struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	TraceID   string    `json:"trace_id"`
+	Retryable bool      `json:"retryable"`
+}
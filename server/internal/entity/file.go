@@ -1,24 +1,107 @@
 package entity
 
 import (
-	"bytes"
 	"context"
 	"github.com/gin-gonic/gin"
+	"io"
 	"strconv"
+	"time"
 )
 
 type FileService interface {
+	CreateSession(ctx context.Context, request CreateSessionRequestDTO) (*UploadSession, error)
 	UploadChunk(ctx context.Context, request UploadChunkRequestServiceDTO) error
+	GetStatus(ctx context.Context, uploadID string) (*UploadStatus, error)
+	OpenFile(ctx context.Context, uploadID string) (*FileMetadata, io.ReadSeekCloser, error)
+	GetFileMetadata(ctx context.Context, uploadID string) (*FileMetadata, error)
+	ListFiles(ctx context.Context, request ListFileRequestDTO) (*ListFileResponseDTO, error)
+	AbortUpload(ctx context.Context, uploadID string) error
+	DeleteFile(ctx context.Context, uploadID string) error
+	Recover(ctx context.Context) error
+	GetProcessing(ctx context.Context, uploadID string) (*ProcessingStatus, error)
+	OpenOutput(ctx context.Context, uploadID, name string) (*ProcessorOutput, error)
+}
+
+type CreateSessionRequestDTO struct {
+	Filename   string `json:"filename" validate:"required,filename"`
+	TotalSize  int64  `json:"total_size" validate:"required,gt=0"`
+	TotalChunk int    `json:"total_chunk" validate:"required,gt=0"`
+	CheckSum   string `json:"check_sum" validate:"omitempty,sha256"`
+	Uploader   string `json:"-"`
+}
+
+// UploadSession is one upload issued by server. chunks are addressed by its ID
+type UploadSession struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	TotalSize   int64     `json:"total_size"`
+	TotalChunk  int       `json:"total_chunk"`
+	CheckSum    string    `json:"check_sum"`
+	Uploader    string    `json:"uploader"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	StartedAt   time.Time `json:"started_at"`
+	Completed   bool      `json:"completed,omitempty"` // file of declared checksum already stored, no chunk has to be sent
+}
+
+// UploadStatus describes progress of one upload session, so client can resume upload
+type UploadStatus struct {
+	UploadID       string `json:"upload_id"`
+	Filename       string `json:"filename"`
+	TotalChunk     int    `json:"total_chunk"`
+	ReceivedChunks []int  `json:"received_chunks"`
+	MissingChunks  []int  `json:"missing_chunks"`
+	BytesReceived  int64  `json:"bytes_received"`
+	Assembled      bool   `json:"assembled"`
+	Corrupted      bool   `json:"corrupted"`
+	Rejected       string `json:"rejected,omitempty"`
+}
+
+// FileMetadata describes final file, recorded once assembly succeeded
+type FileMetadata struct {
+	UploadID    string       `json:"upload_id"`
+	Filename    string       `json:"filename"`
+	ContentType string       `json:"content_type"`
+	Size        int64        `json:"size"`
+	CheckSum    string       `json:"check_sum"`
+	TotalChunk  int          `json:"total_chunk"`
+	Uploader    string       `json:"uploader"`
+	CreatedAt   time.Time    `json:"created_at"`
+	StartedAt   time.Time    `json:"started_at"`
+	CompletedAt time.Time    `json:"completed_at"`
+	Scan        *ScanVerdict `json:"scan,omitempty"` // none if scanning was disabled when file completed
+}
+
+// ListFileRequestDTO is query of file catalogue. content type "image/*" matches all image types
+type ListFileRequestDTO struct {
+	Page        int    `form:"page,default=1" validate:"gte=1"`
+	PageSize    int    `form:"page_size,default=20" validate:"gte=1,lte=100"`
+	SortBy      string `form:"sort_by,default=time" validate:"oneof=size time name"`
+	Order       string `form:"order,default=desc" validate:"oneof=asc desc"`
+	NamePrefix  string `form:"name_prefix"`
+	ContentType string `form:"content_type"`
+}
+
+type ListFileResponseDTO struct {
+	Files    []FileMetadata `json:"files"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int            `json:"total"`
 }
 
 type RequestHeaderDTO struct {
-	Filename   string `json:"filename" validate:"required"`
-	CheckSum   string `json:"check_sum" validate:"required"`
+	UploadID   string `json:"upload_id" validate:"required"`
+	Filename   string `json:"filename"`
+	CheckSum   string `json:"check_sum" validate:"required,sha256"`
 	ChunkIndex int    `json:"chunk_index"`
-	TotalChunk int    `json:"total_chunk" validate:"required"`
+	TotalChunk int    `json:"total_chunk"`
 }
 
 func (r *RequestHeaderDTO) Header(c *gin.Context) RequestHeaderDTO {
+	if uploadID := c.Request.Header.Get("upload-id"); uploadID != "" {
+		r.UploadID = uploadID
+	}
+
 	if filename := c.Request.Header.Get("filename"); filename != "" {
 		r.Filename = filename
 	}
@@ -44,5 +127,5 @@ func (r *RequestHeaderDTO) Header(c *gin.Context) RequestHeaderDTO {
 
 type UploadChunkRequestServiceDTO struct {
 	RequestHeader RequestHeaderDTO `json:"requestHeader" validate:"required"`
-	Content       *bytes.Buffer    `json:"content" validate:"required"`
+	Content       io.Reader        `json:"content" validate:"required"`
 }
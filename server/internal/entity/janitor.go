@@ -0,0 +1,9 @@
+package entity
+
+import "context"
+
+type JanitorService interface {
+	Start()
+	Stop()
+	Sweep(ctx context.Context) ([]string, error)
+}
@@ -0,0 +1,30 @@
+package entity
+
+import (
+	"context"
+	"time"
+)
+
+// KeyProvider wraps data keys with master key, data key is stored wrapped next to data it encrypts.
+// master key is never stored with data, rotating it re-wraps data keys only
+type KeyProvider interface {
+	CurrentKeyID() string
+	WrapKey(ctx context.Context, dataKey []byte) (*DataKey, error)
+	UnwrapKey(ctx context.Context, dataKey *DataKey) ([]byte, error)
+	GenerateKey(ctx context.Context) (string, error)
+}
+
+// DataKey is data key wrapped by master key of KeyID
+type DataKey struct {
+	KeyID      string    `json:"key_id"`
+	Algorithm  string    `json:"algorithm"`
+	WrappedKey []byte    `json:"wrapped_key"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// EncryptedStorage is Storage which encrypts chunks and blobs with data keys
+type EncryptedStorage interface {
+	Storage
+	RotateKeys(ctx context.Context) (int, error)
+}
@@ -0,0 +1,45 @@
+package entity
+
+import "time"
+
+// UploadManifest is durable state of one upload. it is source of truth of received chunks
+type UploadManifest struct {
+	Session   UploadSession   `json:"session"`
+	Chunks    []ChunkManifest `json:"chunks"`
+	Rejected  string          `json:"rejected,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// ChunkManifest is one chunk recorded after it is stored. checksum is sha256 hex of chunk content
+type ChunkManifest struct {
+	Index      int       `json:"index"`
+	Size       int64     `json:"size"`
+	CheckSum   string    `json:"check_sum"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Chunk returns recorded chunk of given index
+func (m *UploadManifest) Chunk(index int) (*ChunkManifest, bool) {
+	for i := range m.Chunks {
+		if m.Chunks[i].Index == index {
+			return &m.Chunks[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// BytesReceived returns total size of recorded chunks
+func (m *UploadManifest) BytesReceived() int64 {
+	var total int64
+	for _, chunk := range m.Chunks {
+		total += chunk.Size
+	}
+
+	return total
+}
+
+// Complete tells whether all chunks of declared total size are recorded
+func (m *UploadManifest) Complete() bool {
+	return len(m.Chunks) == m.Session.TotalChunk && m.BytesReceived() == m.Session.TotalSize
+}
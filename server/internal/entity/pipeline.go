@@ -0,0 +1,67 @@
+package entity
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// states of processor run on final file
+const (
+	ProcessorPending = "pending"
+	ProcessorRunning = "running"
+	ProcessorDone    = "done"
+	ProcessorFailed  = "failed"
+)
+
+// Processor derives files from final file after assembly, e.g. thumbnails of image.
+// outputs are stored next to blob of final file, their names must be unique among processors
+type Processor interface {
+	Name() string
+	Accepts(metadata *FileMetadata) bool
+	Process(ctx context.Context, metadata *FileMetadata, content io.ReadSeeker) ([]ProcessorOutput, error)
+}
+
+// PipelineService runs processors on final files in bounded worker pool.
+// Submit never waits for room in queue, Resume does
+type PipelineService interface {
+	Submit(ctx context.Context, metadata *FileMetadata) error
+	Resume(ctx context.Context, metadata *FileMetadata) error
+	Start()
+	Stop()
+}
+
+// ProcessingStatus is state of every processor accepting final file of upload
+type ProcessingStatus struct {
+	UploadID   string            `json:"upload_id"`
+	Processors []ProcessorStatus `json:"processors"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Pending tells whether any processor has not finished yet
+func (s *ProcessingStatus) Pending() bool {
+	for _, processor := range s.Processors {
+		if processor.State == ProcessorPending || processor.State == ProcessorRunning {
+			return true
+		}
+	}
+
+	return false
+}
+
+type ProcessorStatus struct {
+	Name       string            `json:"name"`
+	State      string            `json:"state"`
+	Error      string            `json:"error,omitempty"`
+	Outputs    []ProcessorOutput `json:"outputs"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+}
+
+// ProcessorOutput is file derived by processor, its content is stored apart from status
+type ProcessorOutput struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Content     []byte `json:"-"`
+}
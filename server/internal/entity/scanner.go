@@ -0,0 +1,32 @@
+package entity
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// verdicts of malware scan
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+// Scanner scans content for malware, error means no verdict, e.g. scanner is unreachable
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, content io.Reader) (*ScanVerdict, error)
+}
+
+// ScanVerdict is result of malware scan of final file, signature names malware found
+type ScanVerdict struct {
+	Status    string    `json:"status"`
+	Signature string    `json:"signature,omitempty"`
+	Scanner   string    `json:"scanner"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// Clean tells whether no malware was found
+func (v *ScanVerdict) Clean() bool {
+	return v.Status == ScanClean
+}
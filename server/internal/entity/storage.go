@@ -0,0 +1,48 @@
+package entity
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Storage stores chunk files and metadata of each upload, and final files as blobs named by sha256 checksum of content.
+// blob files are derived from blob content, e.g. thumbnails, and are removed with blob. quarantined blob is never served again,
+// only its metadata is kept up to date, e.g. its data key re-wrapped by key rotation.
+// errors of missing object wrap os.ErrNotExist
+type Storage interface {
+	ListUploads(ctx context.Context) ([]string, error)
+	PutMetadata(ctx context.Context, uploadID, name string, content []byte) error
+	GetMetadata(ctx context.Context, uploadID, name string) ([]byte, error)
+	PutChunk(ctx context.Context, uploadID string, chunkIndex int, content io.Reader) (int64, error)
+	ListChunks(ctx context.Context, uploadID string) ([]ChunkInfo, error)
+	OpenChunk(ctx context.Context, uploadID string, chunkIndex int) (io.ReadCloser, error)
+	ComposeBlob(ctx context.Context, uploadID string, totalChunk int, w io.Writer, validate func() (string, error)) (int64, error)
+	PutBlob(ctx context.Context, content io.Reader, size int64, validate func() (string, error)) (int64, error)
+	AdoptFinal(ctx context.Context, uploadID, filename string, w io.Writer, validate func() (string, error)) (int64, error)
+	StatBlob(ctx context.Context, checksum string) (*FileInfo, error)
+	OpenBlob(ctx context.Context, checksum string) (io.ReadSeekCloser, error)
+	ListBlobs(ctx context.Context) ([]string, error)
+	PutBlobMetadata(ctx context.Context, checksum, name string, content []byte) error
+	GetBlobMetadata(ctx context.Context, checksum, name string) ([]byte, error)
+	PutBlobFile(ctx context.Context, checksum, name string, content []byte) error
+	GetBlobFile(ctx context.Context, checksum, name string) ([]byte, error)
+	DeleteBlob(ctx context.Context, checksum string) error
+	QuarantineBlob(ctx context.Context, checksum string) error
+	ListQuarantined(ctx context.Context) ([]string, error)
+	PutQuarantineMetadata(ctx context.Context, checksum, name string, content []byte) error
+	GetQuarantineMetadata(ctx context.Context, checksum, name string) ([]byte, error)
+	DeleteChunks(ctx context.Context, uploadID string) error
+	Delete(ctx context.Context, uploadID string) error
+}
+
+type ChunkInfo struct {
+	Index   int       `json:"index"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type FileInfo struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
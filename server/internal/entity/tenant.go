@@ -0,0 +1,49 @@
+package entity
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// DefaultTenant is tenant of caller bound to no tenant, its files stay in configured upload folders
+const DefaultTenant = "default"
+
+type TenantService interface {
+	Resolve(ctx context.Context, request TenantRequestDTO) (*Tenant, error)
+	ListTenants() []*Tenant
+}
+
+// Tenant is namespace of uploads, files of one tenant are stored apart and never listed or returned to others.
+// zero max file size means unlimited, empty allowed types allows all
+type Tenant struct {
+	ID           string        `json:"id"`
+	MaxChunkSize int64         `json:"max_chunk_size"`
+	MaxFileSize  int64         `json:"max_file_size"`
+	AllowedTypes string        `json:"allowed_types"`
+	DeniedTypes  string        `json:"denied_types"`
+	UploadTTL    time.Duration `json:"upload_ttl"`
+	Retention    time.Duration `json:"retention"`
+}
+
+type tenantContextKey struct{}
+
+// ContextWithTenant attaches tenant of request to context
+func ContextWithTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext retrieves tenant of request, not found for work out of request unless attached
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(*Tenant)
+	return tenant, ok
+}
+
+type TenantRequestDTO struct {
+	TenantID string `json:"tenant_id"`
+}
+
+func (r *TenantRequestDTO) Header(c *gin.Context) TenantRequestDTO {
+	r.TenantID = c.Request.Header.Get("X-Tenant-ID")
+	return *r
+}
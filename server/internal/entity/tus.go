@@ -0,0 +1,110 @@
+package entity
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TusResumable         = "1.0.0"
+	TusExtension         = "creation,checksum,termination,expiration"
+	TusChecksumAlgorithm = "md5,sha1,sha256"
+	TusContentType       = "application/offset+octet-stream"
+)
+
+var (
+	ErrTusNotFound          = errors.New("tus upload not found")
+	ErrTusExpired           = errors.New("tus upload expired")
+	ErrTusOffsetMismatch    = errors.New("upload offset mismatch")
+	ErrTusChecksumAlgorithm = errors.New("unsupported checksum algorithm")
+	ErrTusChecksumMismatch  = errors.New("checksum mismatch")
+)
+
+type TusService interface {
+	CreateUpload(ctx context.Context, request TusCreateRequestDTO) (*TusUploadInfo, error)
+	GetUpload(ctx context.Context, uploadID string) (*TusUploadInfo, error)
+	WriteChunk(ctx context.Context, request TusPatchRequestDTO) (*TusUploadInfo, error)
+	Terminate(ctx context.Context, uploadID string) error
+}
+
+// TusUploadInfo is upload session seen by tus protocol
+type TusUploadInfo struct {
+	Session   *UploadSession
+	Offset    int64
+	Chunks    int
+	ExpiresAt time.Time
+}
+
+// TusCreateRequestDTO is creation request of tus client. unlike chunk upload session, upload may be empty
+// and its filename is optional
+type TusCreateRequestDTO struct {
+	UploadLength int64             `json:"upload_length" validate:"gte=0"`
+	Filename     string            `json:"filename" validate:"filename"`
+	CheckSum     string            `json:"check_sum" validate:"omitempty,sha256"`
+	Metadata     map[string]string `json:"metadata"`
+	Uploader     string            `json:"-"`
+}
+
+func (r *TusCreateRequestDTO) Header(c *gin.Context) TusCreateRequestDTO {
+	r.UploadLength = -1
+	if uploadLength := c.Request.Header.Get("Upload-Length"); uploadLength != "" {
+		if i, err := strconv.ParseInt(uploadLength, 10, 64); err == nil {
+			r.UploadLength = i
+		}
+	}
+
+	// metadata format is comma separated "key base64(value)"
+	r.Metadata = map[string]string{}
+	for _, pair := range strings.Split(c.Request.Header.Get("Upload-Metadata"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+
+		r.Metadata[key] = string(decoded)
+	}
+
+	// tus-js-client sends "filename", uppy sends "name"
+	r.Filename = r.Metadata["filename"]
+	if r.Filename == "" {
+		r.Filename = r.Metadata["name"]
+	}
+
+	r.CheckSum = r.Metadata["checksum"]
+
+	r.Uploader = c.ClientIP()
+	return *r
+}
+
+type TusPatchRequestDTO struct {
+	UploadID       string    `json:"upload_id" validate:"required"`
+	UploadOffset   int64     `json:"upload_offset" validate:"gte=0"`
+	UploadChecksum string    `json:"upload_checksum"`
+	Content        io.Reader `json:"content" validate:"required"`
+}
+
+func (r *TusPatchRequestDTO) Header(c *gin.Context) TusPatchRequestDTO {
+	r.UploadID = c.Param("id")
+	r.UploadChecksum = c.Request.Header.Get("Upload-Checksum")
+	r.UploadOffset = -1
+
+	if uploadOffset := c.Request.Header.Get("Upload-Offset"); uploadOffset != "" {
+		if i, err := strconv.ParseInt(uploadOffset, 10, 64); err == nil {
+			r.UploadOffset = i
+		}
+	}
+
+	r.Content = c.Request.Body
+	return *r
+}
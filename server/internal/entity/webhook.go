@@ -0,0 +1,55 @@
+package entity
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// lifecycle events of upload sent to webhooks
+const (
+	EventUploadCompleted = "upload.completed"
+	EventUploadFailed    = "upload.failed"
+	EventUploadAborted   = "upload.aborted"
+	EventUploadExpired   = "upload.expired"
+	EventFileExpired     = "file.expired"
+)
+
+// WebhookService delivers events to webhook URLs. event is queued durably before Publish returns,
+// delivery is retried until it succeeds or is dead-lettered
+type WebhookService interface {
+	Publish(ctx context.Context, event *WebhookEvent) error
+	DeliverDue(ctx context.Context) (int, error)
+	Start()
+	Stop()
+}
+
+// WebhookEvent is body of webhook request
+type WebhookEvent struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	OccurredAt  time.Time `json:"occurred_at"`
+	Tenant      string    `json:"tenant"`
+	UploadID    string    `json:"upload_id"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	CheckSum    string    `json:"check_sum"`
+	ContentType string    `json:"content_type,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	TraceID     string    `json:"trace_id,omitempty"`
+	TraceParent string    `json:"trace_parent,omitempty"`
+}
+
+// WebhookDelivery is event queued for one webhook URL, payload is signed as is on every attempt
+type WebhookDelivery struct {
+	ID            string          `json:"id"`
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	URL           string          `json:"url"`
+	Payload       json.RawMessage `json:"payload"`
+	TraceParent   string          `json:"trace_parent,omitempty"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
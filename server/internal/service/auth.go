@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+	"strings"
+	"time"
+)
+
+type apiKey struct {
+	key      []byte
+	identity entity.Identity
+}
+
+// authService authenticates caller by static API key or JWT bearer token
+type authService struct {
+	apiKeys  []apiKey
+	keySet   *utils.JWTKeySet
+	issuer   string
+	audience string
+}
+
+// NewAuthService creates new instance of authService. it implements from interface AuthService.
+// apiKeys is comma separated "key=subject:scope|scope:tenant" where tenant is optional, jwksFile is optional path of JSON Web Key Set.
+// at least one API key or JWK is required, otherwise every request would be rejected
+func NewAuthService(apiKeys, jwksFile, issuer, audience string) (entity.AuthService, error) {
+	auth := authService{
+		keySet:   &utils.JWTKeySet{},
+		issuer:   issuer,
+		audience: audience,
+	}
+
+	for _, entry := range strings.Split(apiKeys, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		key, identity, ok := strings.Cut(entry, "=")
+		subject, scopes, _ := strings.Cut(identity, ":")
+		scopes, tenant, _ := strings.Cut(scopes, ":")
+		if !ok || key == "" || subject == "" {
+			return nil, fmt.Errorf("invalid api key entry, expected key=subject:scope|scope:tenant")
+		}
+
+		auth.apiKeys = append(auth.apiKeys, apiKey{
+			key: []byte(key),
+			identity: entity.Identity{
+				Subject: subject,
+				Method:  "api_key",
+				Scopes:  strings.Split(scopes, "|"),
+				Tenant:  tenant,
+			},
+		})
+	}
+
+	if jwksFile != "" {
+		content, err := os.ReadFile(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if auth.keySet, err = utils.ParseJWKS(content); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(auth.apiKeys) == 0 && auth.keySet.Len() == 0 {
+		return nil, errors.New("authentication is enabled but no credential is configured, set AUTH_API_KEYS or AUTH_JWKS_FILE, or AUTH_ENABLED=false")
+	}
+
+	return &auth, nil
+}
+
+// Authenticate verifies credential sent by caller and returns its identity
+func (a *authService) Authenticate(ctx context.Context, request entity.AuthRequestDTO) (*entity.Identity, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	var (
+		identity *entity.Identity
+		err      error
+	)
+
+	switch {
+	case request.APIKey != "":
+		identity, err = a.AuthenticateAPIKey(request.APIKey)
+	case request.BearerToken != "":
+		identity, err = a.AuthenticateJWT(request.BearerToken)
+	default:
+		err = errors.New("missing api key or bearer token")
+	}
+
+	if err != nil {
+		err = entity.Errorf(entity.ErrCodeUnauthorized, "unauthenticated : %w", err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.String("auth.subject", identity.Subject),
+		attribute.String("auth.method", identity.Method),
+	)
+
+	return identity, nil
+}
+
+// AuthenticateAPIKey finds identity of static API key, keys are compared in constant time
+func (a *authService) AuthenticateAPIKey(key string) (*entity.Identity, error) {
+	var identity *entity.Identity
+	for i := range a.apiKeys {
+		if subtle.ConstantTimeCompare(a.apiKeys[i].key, []byte(key)) == 1 {
+			identity = &a.apiKeys[i].identity
+		}
+	}
+
+	if identity == nil {
+		return nil, errors.New("invalid api key")
+	}
+
+	return identity, nil
+}
+
+// AuthenticateJWT verifies JWT against local key set, its subject and scope become identity
+func (a *authService) AuthenticateJWT(token string) (*entity.Identity, error) {
+	claims, err := a.keySet.Verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, fmt.Errorf("invalid token issuer %s", claims.Issuer)
+	}
+
+	if a.audience != "" && !claims.Audience.Contains(a.audience) {
+		return nil, fmt.Errorf("invalid token audience %v", claims.Audience)
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	return &entity.Identity{
+		Subject: claims.Subject,
+		Method:  "jwt",
+		Scopes:  strings.Fields(claims.Scope),
+		Tenant:  claims.Tenant,
+	}, nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewAuthService(t *testing.T) {
+	folder := t.TempDir()
+	writeJWKS := func(name, content string) string {
+		path := filepath.Join(folder, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString([]byte(strings.Repeat("s", 32)))
+
+	tests := []struct {
+		name     string
+		apiKeys  string
+		jwksFile string
+		wantErr  bool
+	}{
+		{name: "api key", apiKeys: "key-1=client:upload|read"},
+		{name: "api key bound to tenant", apiKeys: "key-1=client:upload:team-a"},
+		{name: "jwks file", jwksFile: writeJWKS("jwks.json", fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"k1","k":%q}]}`, secret))},
+		{name: "no credential", apiKeys: " , ", wantErr: true},
+		{name: "empty jwks file", jwksFile: writeJWKS("empty.json", `{"keys":[]}`), wantErr: true},
+		{name: "invalid api key entry", apiKeys: "key-1", wantErr: true},
+		{name: "missing jwks file", jwksFile: filepath.Join(folder, "missing.json"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAuthService(tt.apiKeys, tt.jwksFile, "", ""); (err != nil) != tt.wantErr {
+				t.Errorf("NewAuthService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
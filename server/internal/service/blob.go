@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"os"
+	"slices"
+	"time"
+)
+
+// blobRefsFilename is metadata name of blob references
+const blobRefsFilename = "refs.json"
+
+// GetBlobRefs retrieves uploads referencing blob, blob without references file is referenced by none
+func (f *fileService) GetBlobRefs(ctx context.Context, checksum string) (*entity.BlobRefs, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	refs := entity.BlobRefs{
+		CheckSum: checksum,
+		Uploads:  []string{},
+	}
+
+	content, err := f.storage.GetBlobMetadata(ctx, checksum, blobRefsFilename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &refs, nil
+		}
+
+		logger.Error(err)
+		return nil, err
+	}
+
+	if err = json.Unmarshal(content, &refs); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &refs, nil
+}
+
+// PutBlobRefs records uploads referencing blob into storage
+func (f *fileService) PutBlobRefs(ctx context.Context, refs *entity.BlobRefs) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	refs.UpdatedAt = time.Now()
+	content, err := json.Marshal(refs)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err = f.storage.PutBlobMetadata(ctx, refs.CheckSum, blobRefsFilename, content); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// AddBlobRef records upload as reference of blob, upload already referencing is kept as is.
+// caller must hold blob lock of checksum
+func (f *fileService) AddBlobRef(ctx context.Context, checksum, uploadID string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	refs, err := f.GetBlobRefs(ctx, checksum)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if slices.Contains(refs.Uploads, uploadID) {
+		return nil
+	}
+
+	refs.Uploads = append(refs.Uploads, uploadID)
+	if err = f.PutBlobRefs(ctx, refs); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	logger.Infof("upload [%s] references blob %s, %d references 🔗", uploadID, checksum, len(refs.Uploads))
+	return nil
+}
+
+// RemoveBlobRef removes upload from references of blob, blob is removed along with its last reference.
+// caller must hold blob lock of checksum
+func (f *fileService) RemoveBlobRef(ctx context.Context, checksum, uploadID string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	refs, err := f.GetBlobRefs(ctx, checksum)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	refs.Uploads = slices.DeleteFunc(refs.Uploads, func(ref string) bool {
+		return ref == uploadID
+	})
+
+	if len(refs.Uploads) > 0 {
+		if err = f.PutBlobRefs(ctx, refs); err != nil {
+			logger.Error(err)
+			return err
+		}
+
+		logger.Infof("upload [%s] released blob %s, %d references left 🔗", uploadID, checksum, len(refs.Uploads))
+		return nil
+	}
+
+	if err = f.storage.DeleteBlob(ctx, checksum); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	logger.Infof("delete blob %s, no reference left 🗑️", checksum)
+	return nil
+}
+
+// CompleteFromBlob completes upload session without any chunk if file of its declared checksum is already stored.
+// stored file is sniffed like first chunk, so content type policy still applies. session is returned as is if not stored
+func (f *fileService) CompleteFromBlob(ctx context.Context, session *entity.UploadSession) (*entity.UploadSession, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	unlock := f.locks.blob.Lock(session.CheckSum)
+	defer unlock()
+
+	fileInfo, err := f.storage.StatBlob(ctx, session.CheckSum)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return session, nil
+		}
+
+		logger.Error(err)
+		return nil, err
+	}
+
+	// size of stored file must be declared size too, otherwise upload goes on and fails its validation
+	if fileInfo.Size != session.TotalSize {
+		logger.Warnf("blob %s is %d bytes, upload [%s] declares %d bytes ⚠️", session.CheckSum, fileInfo.Size, session.ID, session.TotalSize)
+		return session, nil
+	}
+
+	content, err := f.storage.OpenBlob(ctx, session.CheckSum)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	_, err = f.SniffContent(ctx, session, content, 0)
+	_ = content.Close()
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	// only blob found clean completes upload, otherwise upload goes on and its file is scanned after assembly
+	verdict, err := f.ScanBlob(ctx, session.CheckSum)
+	if err != nil || (verdict != nil && !verdict.Clean()) {
+		if err != nil {
+			logger.Warnf("blob %s is not scanned, upload [%s] goes on : %s ⚠️", session.CheckSum, session.ID, err.Error())
+		}
+
+		return session, nil
+	}
+
+	manifest, err := f.UpdateManifest(ctx, session.ID, func(manifest *entity.UploadManifest) error {
+		manifest.Session.Completed = true
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	session = &manifest.Session
+	if err = f.AddBlobRef(ctx, session.CheckSum, session.ID); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	metadata := NewFileMetadata(session, fileInfo.Size, session.CheckSum, manifest.UpdatedAt)
+	metadata.Scan = verdict
+	if err = f.PutFileMetadata(ctx, metadata); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	f.locks.openUploads.Remove(session.ID)
+
+	f.Notify(ctx, NewWebhookEvent(ctx, entity.EventUploadCompleted, metadata))
+	f.SubmitProcessing(ctx, metadata)
+
+	logger.Infof("file %s [%s] already stored as blob %s, upload completed ✅", session.Filename, session.ID, session.CheckSum)
+	return session, nil
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestBlobRefs(t *testing.T) {
+	checksum := sha256.Sum256([]byte("helloworld"))
+
+	tests := []struct {
+		name     string
+		checkSum string // declared by second upload, it completes from stored blob without chunks
+	}{
+		{name: "second upload sends chunks"},
+		{name: "second upload declares checksum of stored blob", checkSum: hex.EncodeToString(checksum[:])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			service, memoryStorage := newTestFileService(t, UploadConfig{})
+
+			first := createTestSession(t, service)
+			uploadTestChunk(t, service, first.ID, 0, "hello")
+			uploadTestChunk(t, service, first.ID, 1, "world")
+
+			second, err := service.CreateSession(ctx, entity.CreateSessionRequestDTO{Filename: "b.txt", TotalSize: 10, TotalChunk: 2, CheckSum: tt.checkSum, Uploader: "10.0.0.1"})
+			if err != nil {
+				t.Fatalf("CreateSession() error = %v", err)
+			}
+
+			if tt.checkSum == "" {
+				uploadTestChunk(t, service, second.ID, 0, "hello")
+				uploadTestChunk(t, service, second.ID, 1, "world")
+			}
+
+			// both uploads share one blob
+			blobs, err := memoryStorage.ListBlobs(ctx)
+			if err != nil || len(blobs) != 1 {
+				t.Fatalf("ListBlobs() = %v, %v, want one blob", blobs, err)
+			}
+
+			refs, err := service.GetBlobRefs(ctx, blobs[0])
+			if err != nil {
+				t.Fatalf("GetBlobRefs() error = %v", err)
+			}
+
+			want := []string{first.ID, second.ID}
+			slices.Sort(want)
+			slices.Sort(refs.Uploads)
+			if !slices.Equal(refs.Uploads, want) {
+				t.Fatalf("blob references = %v, want %v", refs.Uploads, want)
+			}
+
+			// deleting one upload keeps blob of other
+			if err = service.DeleteFile(ctx, first.ID); err != nil {
+				t.Fatalf("DeleteFile() error = %v", err)
+			}
+
+			if refs, err = service.GetBlobRefs(ctx, blobs[0]); err != nil || !slices.Equal(refs.Uploads, []string{second.ID}) {
+				t.Fatalf("blob references after delete = %v, %v, want [%s]", refs.Uploads, err, second.ID)
+			}
+
+			_, file, err := service.OpenFile(ctx, second.ID)
+			if err != nil {
+				t.Fatalf("OpenFile() of other upload error = %v", err)
+			}
+
+			content, err := io.ReadAll(file)
+			_ = file.Close()
+			if err != nil || string(content) != "helloworld" {
+				t.Errorf("file of other upload = %q, %v, want %q", content, err, "helloworld")
+			}
+
+			// last reference removes blob
+			if err = service.DeleteFile(ctx, second.ID); err != nil {
+				t.Fatalf("DeleteFile() error = %v", err)
+			}
+
+			if _, err = memoryStorage.StatBlob(ctx, blobs[0]); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("StatBlob() after last reference error = %v, want %v", err, os.ErrNotExist)
+			}
+
+			if blobs, err = memoryStorage.ListBlobs(ctx); err != nil || len(blobs) != 0 {
+				t.Errorf("ListBlobs() after last reference = %v, %v, want none", blobs, err)
+			}
+		})
+	}
+}
@@ -1,30 +1,156 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
+	"encoding/json"
+	"errors"
 	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"go-upload-chunk/server/config"
 	"go-upload-chunk/server/internal/entity"
 	"go-upload-chunk/server/internal/utils"
+	"golang.org/x/text/unicode/norm"
 	"io"
+	"mime"
 	"os"
 	"path/filepath"
-	_ "path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
+const (
+	sessionFilename      = "session.json" // session of older version, migrated into manifest
+	corruptFilename      = "corrupt.json"
+	fileMetadataFilename = "file.json"
+)
+
+// UploadConfig is config of upload services, it is read once at startup
+type UploadConfig struct {
+	ConcurrentUploads int           // upload sessions each client may keep open, 0 means unlimited
+	ScanTimeout       time.Duration // how long scan of one final file may take
+	TusExpiration     time.Duration // how long unfinished tus upload is kept
+}
+
 type fileService struct {
 	validate *validator.Validate
+	storage  entity.Storage
+	webhook  entity.WebhookService
+	pipeline entity.PipelineService
+	scanner  entity.Scanner
+	locks    *UploadLocks
+	config   UploadConfig
+}
+
+// NewFileService creates new instance of fileService. it implements from interface FileService.
+// nil webhook means upload events are not published, nil pipeline means final files are not processed,
+// nil scanner means final files are not scanned. locks must be shared by every service of same storage
+func NewFileService(validate *validator.Validate, storage entity.Storage, webhook entity.WebhookService, pipeline entity.PipelineService, scanner entity.Scanner, locks *UploadLocks, config UploadConfig) entity.FileService {
+	return &fileService{
+		validate: validate,
+		storage:  storage,
+		webhook:  webhook,
+		pipeline: pipeline,
+		scanner:  scanner,
+		locks:    locks,
+		config:   config,
+	}
 }
 
-// NewFileService creates new instance of fileService. it implements from interface FileService
-func NewFileService(validate *validator.Validate) entity.FileService {
-	return &fileService{validate}
+// CreateSession creates new upload session and issues its upload ID
+func (f *fileService) CreateSession(ctx context.Context, request entity.CreateSessionRequestDTO) (*entity.UploadSession, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// filename is kept as metadata only, it is normalized before checked by filename policy
+	request.Filename = NormalizeFilename(request.Filename)
+
+	// validate request, rejected filename is explained by rule it violated
+	if err := f.validate.Struct(request); err != nil {
+		err = FilenameError(err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return f.OpenSession(ctx, request)
+}
+
+// OpenSession issues upload session of validated request, tus upload is validated by its own rules
+func (f *fileService) OpenSession(ctx context.Context, request entity.CreateSessionRequestDTO) (*entity.UploadSession, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if tenant := TenantOf(ctx); tenant.MaxFileSize > 0 && request.TotalSize > tenant.MaxFileSize {
+		err := entity.Errorf(entity.ErrCodeTooLarge, "file is %d bytes, tenant [%s] allows %d bytes", request.TotalSize, tenant.ID, tenant.MaxFileSize)
+		logger.Error(err)
+		return nil, err
+	}
+
+	// uploader is authenticated caller, or client address if authentication is disabled
+	uploader := request.Uploader
+	if identity, ok := entity.IdentityFromContext(ctx); ok {
+		uploader = identity.Subject
+	}
+
+	session := entity.UploadSession{
+		ID:         uuid.NewString(),
+		Filename:   request.Filename,
+		TotalSize:  request.TotalSize,
+		TotalChunk: request.TotalChunk,
+		CheckSum:   request.CheckSum,
+		Uploader:   uploader,
+		CreatedAt:  time.Now(),
+	}
+
+	if !f.locks.openUploads.Add(uploader, session.ID, f.config.ConcurrentUploads) {
+		err := entity.Errorf(entity.ErrCodeRateLimited, "rate limit exceeded : %s has %d open uploads, complete or abort one first", uploader, f.locks.openUploads.Len(uploader))
+		logger.Error(err)
+		return nil, err
+	}
+
+	// record manifest of new upload into storage
+	if err := f.PutManifest(ctx, &entity.UploadManifest{
+		Session:   session,
+		Chunks:    []entity.ChunkManifest{},
+		UpdatedAt: session.CreatedAt,
+	}); err != nil {
+		f.locks.openUploads.Remove(session.ID)
+		logger.Error(err)
+		return nil, err
+	}
+
+	logger.Infof("success create upload session [%s] for file %s 🎫", session.ID, session.Filename)
+
+	// same file may be uploaded before, then there is nothing to upload
+	if session.CheckSum != "" {
+		return f.CompleteFromBlob(ctx, &session)
+	}
+
+	return &session, nil
+}
+
+// GetSession retrieves upload session by given upload ID
+func (f *fileService) GetSession(ctx context.Context, uploadID string) (*entity.UploadSession, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	manifest, err := f.GetManifest(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &manifest.Session, nil
 }
 
 // UploadChunk uploads one chunk file, combines to one file
@@ -40,242 +166,683 @@ func (f *fileService) UploadChunk(ctx context.Context, request entity.UploadChun
 		return err
 	}
 
+	// get upload manifest, chunks are addressed by its ID
+	manifest, err := f.GetManifest(ctx, request.RequestHeader.UploadID)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
 	var (
-		requestHeader   = request.RequestHeader
-		totalChunkFiles int
+		session       = &manifest.Session
+		requestHeader = request.RequestHeader
 	)
 
-	hashChecksum := sha256.New()
-	content := io.TeeReader(request.Content, hashChecksum)
-
-	// get buffer from Pool
-	buf := utils.ByteBufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		buf.Reset()
-		utils.ByteBufferPool.Put(buf)
-	}()
-
-	// copy from content to buf. content will be empty
-	if _, err := io.Copy(buf, content); err != nil {
+	if requestHeader.ChunkIndex < 0 || requestHeader.ChunkIndex >= session.TotalChunk {
+		err = entity.Errorf(entity.ErrCodeValidation, "chunk index %d out of range, total chunk is %d", requestHeader.ChunkIndex, session.TotalChunk)
 		logger.Error(err)
 		return err
 	}
 
-	// copy from buf to request.Content, buf will be empty
-	if _, err := io.Copy(request.Content, buf); err != nil {
+	// content type of rejected upload is not allowed, its chunks are never stored
+	if manifest.Rejected != "" {
+		err = entity.Errorf(entity.ErrCodeUnsupportedMediaType, "upload [%s] rejected : %s", session.ID, manifest.Rejected)
 		logger.Error(err)
 		return err
 	}
 
-	// validate checksum
-	checksum := hex.EncodeToString(hashChecksum.Sum(nil))
-	if checksum != requestHeader.CheckSum {
-		err := fmt.Errorf("invalid checksum ‼️")
+	// check chunk if already recorded, or upload completed by stored file of same checksum
+	if _, ok := manifest.Chunk(requestHeader.ChunkIndex); ok || session.Completed {
+		logger.Infof("chuck file already exists 📩")
+		return nil
+	}
+
+	// hash content while streamed to storage, chunk is discarded by storage if checksum invalid
+	expected, err := hex.DecodeString(requestHeader.CheckSum)
+	if err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// check local folder chunk
-	if err := f.CheckAndCreateFolder(ctx, config.FolderUploadChunk()); err != nil {
+	// first chunk tells content type of upload, other chunks wait until it is detected.
+	// upload recorded first chunk before content type was detected by older version
+	if _, ok := manifest.Chunk(0); !ok && requestHeader.ChunkIndex != 0 && session.ContentType == "" {
+		err = entity.Errorf(entity.ErrCodeConflict, "chunk 0 of upload [%s] must be received first, its content type is not detected yet", session.ID)
 		logger.Error(err)
 		return err
 	}
 
-	// check file chunk if already exists
-	filePath := fmt.Sprintf("%s/%s-chunk-%d", config.FolderUploadChunk(), requestHeader.Filename, requestHeader.ChunkIndex)
-	if _, err := os.Stat(filePath); err == nil {
-		logger.Infof("chuck file already exists 📩")
-		return nil
+	content := request.Content
+	if requestHeader.ChunkIndex == 0 {
+		if content, err = f.SniffContent(ctx, session, content, 0); err != nil {
+			logger.Error(err)
+			return err
+		}
 	}
 
+	content = utils.NewChecksumReader(content, sha256.New(), expected)
+
 	// create new chunk file
-	if err := f.CreateChunkFile(ctx, request); err != nil {
+	written, err := f.storage.PutChunk(ctx, session.ID, requestHeader.ChunkIndex, content)
+	if err != nil {
+		if errors.Is(err, utils.ErrChecksumMismatch) {
+			err = entity.Errorf(entity.ErrCodeChecksumMismatch, "invalid checksum ‼️")
+		}
+
 		logger.Error(err)
 		return err
 	}
 
-	// find all files by given prefix path
-	filePathPrefix := fmt.Sprintf("%s/%s-chunk-*", config.FolderUploadChunk(), requestHeader.Filename)
-	matchFiles, err := filepath.Glob(filePathPrefix)
+	// record chunk into manifest, other chunks may be recorded concurrently
+	manifest, err = f.RecordChunk(ctx, session.ID, entity.ChunkManifest{
+		Index:      requestHeader.ChunkIndex,
+		Size:       written,
+		CheckSum:   requestHeader.CheckSum,
+		ReceivedAt: time.Now(),
+	})
 	if err != nil {
+		f.DiscardUnrecorded(ctx, session.ID, err)
 		logger.Error(err)
 		return err
 	}
 
-	// count total chunk files
-	for _, _ = range matchFiles {
-		totalChunkFiles++
+	if len(manifest.Chunks) != manifest.Session.TotalChunk {
+		logger.Infof("create chunk file %s only", session.Filename)
+		return nil
+	}
+
+	// all chunks received, their size must add up to declared total size
+	if !manifest.Complete() {
+		err = entity.Errorf(entity.ErrCodeValidation, "received %d bytes, declared total size is %d", manifest.BytesReceived(), manifest.Session.TotalSize)
+		logger.Error(err)
+		return err
 	}
 
 	// if total files number is same as we expect, then combine mutiple chunk into a one file
-	if totalChunkFiles == requestHeader.TotalChunk {
-		if err = f.CreateFinalFile(ctx, request); err != nil {
-			logger.Error(err)
-			return err
-		}
-	} else {
-		logger.Infof("create chunk file %s only", request.RequestHeader.Filename)
+	if err = f.CreateFinalFile(ctx, &manifest.Session); err != nil {
+		logger.Error(err)
+		return err
 	}
 
 	return nil
 }
 
-// CheckAndCreateFolder checks folder, if not exists then create folder
-func (f *fileService) CheckAndCreateFolder(ctx context.Context, path string) error {
+// GetStatus retrieves which chunks already received and which chunks are still missing
+func (f *fileService) GetStatus(ctx context.Context, uploadID string) (*entity.UploadStatus, error) {
 	ctx, span := gootel.RecordSpan(ctx)
 	defer span.End()
 
 	logger := logrus.WithContext(ctx)
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// create folder
-		if err = os.MkdirAll(path, os.ModePerm); err != nil {
-			logrus.Error(err)
-			return err
+	manifest, err := f.GetManifest(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	session := &manifest.Session
+	status := entity.UploadStatus{
+		UploadID:       session.ID,
+		Filename:       session.Filename,
+		TotalChunk:     session.TotalChunk,
+		ReceivedChunks: []int{},
+		MissingChunks:  []int{},
+		Rejected:       manifest.Rejected,
+	}
+
+	// chunk files are removed after assembly, so file metadata means all chunks received
+	if metadata, err := f.LoadFileMetadata(ctx, session.ID); err == nil {
+		for i := 0; i < session.TotalChunk; i++ {
+			status.ReceivedChunks = append(status.ReceivedChunks, i)
 		}
 
-		logger.Infof("create new folder [%s]", path)
+		status.BytesReceived = metadata.Size
+		status.Assembled = true
+		return &status, nil
 	}
 
-	return nil
+	// assembled file failed whole file checksum validation, chunks must be sent again
+	if _, err = f.storage.GetMetadata(ctx, session.ID, corruptFilename); err == nil {
+		status.Corrupted = true
+	}
+
+	// check each chunk recorded in manifest
+	for i := 0; i < session.TotalChunk; i++ {
+		if _, ok := manifest.Chunk(i); !ok {
+			status.MissingChunks = append(status.MissingChunks, i)
+			continue
+		}
+
+		status.ReceivedChunks = append(status.ReceivedChunks, i)
+	}
+
+	status.BytesReceived = manifest.BytesReceived()
+	return &status, nil
 }
 
-// CreateChunkFile creates new chunk file
-func (f *fileService) CreateChunkFile(ctx context.Context, request entity.UploadChunkRequestServiceDTO) error {
+// CreateFinalFile combines chunk files into one final file and validates its checksum. final file is stored as blob
+// named by its checksum and referenced by upload, so same content uploaded again is stored once.
+// assembly of one upload is serialized, concurrent callers return after final file is published
+func (f *fileService) CreateFinalFile(ctx context.Context, session *entity.UploadSession) error {
 	ctx, span := gootel.RecordSpan(ctx)
 	defer span.End()
 
 	logger := logrus.WithContext(ctx)
 
-	// create new chunk file
-	chunkFilePath := fmt.Sprintf("%s/%s-chunk-%d", config.FolderUploadChunk(), request.RequestHeader.Filename, request.RequestHeader.ChunkIndex)
-	chunkFile, err := os.Create(chunkFilePath)
+	unlock := f.locks.assembly.Lock(session.ID)
+	defer unlock()
+
+	if _, err := f.storage.GetMetadata(ctx, session.ID, fileMetadataFilename); err == nil {
+		logger.Infof("final file already exists 📩")
+		return nil
+	}
+
+	// chunks may have been consumed by concurrent assembly which failed
+	manifest, err := f.GetManifest(ctx, session.ID)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if !manifest.Complete() {
+		logger.Infof("chunk files of %s are not complete, skip assembly", session.Filename)
+		return nil
+	}
+
+	session = &manifest.Session
+
+	// combine from multiple chunk files into one final file, hash while writing.
+	// final file is published only if whole file checksum declared by client is valid,
+	// and content type detected from whole file is accepted too, first chunk alone may hide content behind it
+	var (
+		hashChecksum = sha256.New()
+		header       = &headerWriter{}
+		detected     *mimetype.MIME
+		checksum     string
+		rejected     string
+		unlockBlob   = func() {}
+	)
+
+	defer func() {
+		unlockBlob()
+	}()
+
+	size, err := f.storage.ComposeBlob(ctx, session.ID, session.TotalChunk, io.MultiWriter(hashChecksum, header), func() (string, error) {
+		checksum = hex.EncodeToString(hashChecksum.Sum(nil))
+		if session.CheckSum != "" && checksum != session.CheckSum {
+			return "", entity.Errorf(entity.ErrCodeFileChecksumMismatch, "invalid final file checksum, expected %s got %s ‼️", session.CheckSum, checksum)
+		}
+
+		detected = mimetype.Detect(header.header)
+		if rejected = CheckContentType(detected, session.Filename, TenantOf(ctx)); rejected != "" {
+			return "", entity.Errorf(entity.ErrCodeUnsupportedMediaType, "upload [%s] rejected : %s", session.ID, rejected)
+		}
+
+		// blob is held from publish until referenced, so it is not removed by concurrent delete of its last reference
+		unlockBlob = f.locks.blob.Lock(checksum)
+		return checksum, nil
+	})
+
+	if rejected != "" {
+		err = f.RejectContent(ctx, session, detected, rejected)
+		logger.Error(err)
+		return err
+	}
+
+	if err != nil {
+		// invalid final file is never published, chunks must be sent again
+		var appErr *entity.Error
+		if errors.As(err, &appErr) && appErr.Code == entity.ErrCodeFileChecksumMismatch {
+			if _, err := f.UpdateManifest(ctx, session.ID, func(manifest *entity.UploadManifest) error {
+				manifest.Chunks = []entity.ChunkManifest{}
+				return nil
+			}); err != nil {
+				logger.Error(err)
+				return err
+			}
+
+			if err := f.storage.DeleteChunks(ctx, session.ID); err != nil {
+				logger.Error(err)
+				return err
+			}
+
+			if err := f.RecordCorruptFile(ctx, session, checksum); err != nil {
+				logger.Error(err)
+				return err
+			}
+
+			event := NewWebhookEvent(ctx, entity.EventUploadFailed, NewFileMetadata(session, session.TotalSize, session.CheckSum, time.Now()))
+			event.Reason = appErr.Error()
+			f.Notify(ctx, event)
+		}
+
+		logger.Error(err)
+		return err
+	}
+
+	// file is scanned before its metadata recorded, so it is never served unscanned.
+	// without verdict assembly fails, chunks are kept and it is assembled again by next chunk or recovery
+	verdict, err := f.ScanBlob(ctx, checksum)
 	if err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// don't forget to close chunk file at the end
-	defer chunkFile.Close()
+	// quarantined blob is referenced by none
+	if verdict == nil || verdict.Clean() {
+		if err = f.AddBlobRef(ctx, checksum, session.ID); err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+
+	unlockBlob()
+	unlockBlob = func() {}
 
-	// write content to chunk file
-	if _, err = chunkFile.Write(request.Content.Bytes()); err != nil {
+	// checksum of final file names its blob, and is served as ETag of download
+	metadata := NewFileMetadata(session, size, checksum, time.Now())
+	metadata.Scan = verdict
+	if err = f.PutFileMetadata(ctx, metadata); err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// sync chunk file
-	if err = chunkFile.Sync(); err != nil {
+	f.locks.openUploads.Remove(session.ID)
+
+	// chunk files are not needed anymore, per chunk checksum has been validated
+	if err = f.storage.DeleteChunks(ctx, session.ID); err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	logger.Infof("success create chunk file [%s] 🗳️", chunkFilePath)
+	if err = CheckScan(metadata); err != nil {
+		event := NewWebhookEvent(ctx, entity.EventUploadFailed, metadata)
+		event.Reason = err.Error()
+		f.Notify(ctx, event)
+
+		logger.Error(err)
+		return err
+	}
+
+	f.Notify(ctx, NewWebhookEvent(ctx, entity.EventUploadCompleted, metadata))
+	f.SubmitProcessing(ctx, metadata)
+
+	logger.Infof("success create final file %s [%s] ✅", session.Filename, session.ID)
 	return nil
 }
 
-// CreateFinalFile creates new final file and combine from multiple chunk files into one final file
-func (f *fileService) CreateFinalFile(ctx context.Context, request entity.UploadChunkRequestServiceDTO) error {
+// AdoptFinalFile moves final file published by older version into blob store, then records its reference and metadata
+func (f *fileService) AdoptFinalFile(ctx context.Context, session *entity.UploadSession) error {
 	ctx, span := gootel.RecordSpan(ctx)
 	defer span.End()
 
 	logger := logrus.WithContext(ctx)
 
-	// check folder final
-	if err := f.CheckAndCreateFolder(ctx, config.FolderUploadFinal()); err != nil {
+	var (
+		hashChecksum = sha256.New()
+		checksum     string
+		unlockBlob   = func() {}
+	)
+
+	defer func() {
+		unlockBlob()
+	}()
+
+	size, err := f.storage.AdoptFinal(ctx, session.ID, session.Filename, hashChecksum, func() (string, error) {
+		checksum = hex.EncodeToString(hashChecksum.Sum(nil))
+		unlockBlob = f.locks.blob.Lock(checksum)
+		return checksum, nil
+	})
+	if err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	finalFilePath := fmt.Sprintf("%s/%s", config.FolderUploadFinal(), request.RequestHeader.Filename)
-	if _, err := os.Stat(finalFilePath); err == nil {
-		logrus.Infof("final file already exists 📩")
-		return nil
+	if err = f.AddBlobRef(ctx, checksum, session.ID); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	// metadata recorded by older version is kept, it has original completion time
+	if _, err = f.storage.GetMetadata(ctx, session.ID, fileMetadataFilename); errors.Is(err, os.ErrNotExist) {
+		if err = f.PutFileMetadata(ctx, NewFileMetadata(session, size, checksum, time.Now())); err != nil {
+			logger.Error(err)
+			return err
+		}
 	}
 
-	// create new final file
-	finalFile, err := os.Create(finalFilePath)
+	logger.Infof("success adopt final file %s [%s] as blob %s", session.Filename, session.ID, checksum)
+	return nil
+}
+
+// NewFileMetadata creates metadata of final file assembled from upload session
+func NewFileMetadata(session *entity.UploadSession, size int64, checksum string, completedAt time.Time) *entity.FileMetadata {
+	// content type detected from first chunk, or from extension for upload created by older version
+	contentType := session.ContentType
+	if contentType == "" {
+		contentType = ContentType(session.Filename)
+	}
+
+	return &entity.FileMetadata{
+		UploadID:    session.ID,
+		Filename:    session.Filename,
+		ContentType: contentType,
+		Size:        size,
+		CheckSum:    checksum,
+		TotalChunk:  session.TotalChunk,
+		Uploader:    session.Uploader,
+		CreatedAt:   session.CreatedAt,
+		StartedAt:   session.StartedAt,
+		CompletedAt: completedAt,
+	}
+}
+
+// RecordCorruptFile records final file which failed checksum validation, so status can report it
+func (f *fileService) RecordCorruptFile(ctx context.Context, session *entity.UploadSession, checksum string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	content, err := json.Marshal(map[string]any{
+		"expected_check_sum": session.CheckSum,
+		"actual_check_sum":   checksum,
+		"created_at":         time.Now(),
+	})
 	if err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// don't forget to close file at the end
-	defer finalFile.Close()
+	if err = f.storage.PutMetadata(ctx, session.ID, corruptFilename, content); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
 
-	// combine from multiple chunk files into one final file
-	if err = f.CombineChunkFiles(ctx, request, finalFile); err != nil {
+// PutFileMetadata records metadata of final file into storage
+func (f *fileService) PutFileMetadata(ctx context.Context, metadata *entity.FileMetadata) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err = f.storage.PutMetadata(ctx, metadata.UploadID, fileMetadataFilename, content); err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	logger.Infof("success create final file [%s] ✅", finalFilePath)
 	return nil
 }
 
-// CombineChunkFiles combines multiple chunk files into one final file
-func (f *fileService) CombineChunkFiles(ctx context.Context, request entity.UploadChunkRequestServiceDTO, finalFile *os.File) error {
+// GetFileMetadata retrieves metadata of final file, not found if upload is not assembled yet
+func (f *fileService) GetFileMetadata(ctx context.Context, uploadID string) (*entity.FileMetadata, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	session, err := f.GetSession(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	metadata, err := f.LoadFileMetadata(ctx, session.ID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = entity.Errorf(entity.ErrCodeNotFound, "file of upload [%s] is not assembled yet: %w", uploadID, err)
+		}
+
+		logger.Error(err)
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// LoadFileMetadata reads metadata of final file from storage. upload not assembled yet has none, it is not logged
+func (f *fileService) LoadFileMetadata(ctx context.Context, uploadID string) (*entity.FileMetadata, error) {
+	content, err := f.storage.GetMetadata(ctx, uploadID, fileMetadataFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata entity.FileMetadata
+	if err = json.Unmarshal(content, &metadata); err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+// ListFiles lists final files filtered by name prefix and content type, sorted and paginated
+func (f *fileService) ListFiles(ctx context.Context, request entity.ListFileRequestDTO) (*entity.ListFileResponseDTO, error) {
 	ctx, span := gootel.RecordSpan(ctx)
 	defer span.End()
 
 	logger := logrus.WithContext(ctx)
 
-	// looping each chunk files
-	for i := 0; i < request.RequestHeader.TotalChunk; i++ {
-		// open file chunk
-		chunkFilePath := fmt.Sprintf("%s/%s-chunk-%d", config.FolderUploadChunk(), request.RequestHeader.Filename, i)
-		if err := f.WriteChunkToFinalFile(ctx, chunkFilePath, finalFile); err != nil {
+	// validate request
+	if err := f.validate.Struct(request); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	uploadIDs, err := f.storage.ListUploads(ctx)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	files := []entity.FileMetadata{}
+	for _, uploadID := range uploadIDs {
+		// only assembled uploads have file metadata
+		metadata, err := f.LoadFileMetadata(ctx, uploadID)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
 			logger.Error(err)
-			return err
+			return nil, err
+		}
+
+		if !strings.HasPrefix(metadata.Filename, norm.NFC.String(request.NamePrefix)) || !MatchContentType(metadata.ContentType, request.ContentType) {
+			continue
+		}
+
+		files = append(files, *metadata)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if request.Order == "desc" {
+			a, b = b, a
+		}
+
+		switch request.SortBy {
+		case "size":
+			return a.Size < b.Size
+		case "name":
+			return a.Filename < b.Filename
+		default:
+			return a.CompletedAt.Before(b.CompletedAt)
+		}
+	})
+
+	response := entity.ListFileResponseDTO{
+		Files:    []entity.FileMetadata{},
+		Page:     request.Page,
+		PageSize: request.PageSize,
+		Total:    len(files),
+	}
+
+	if start := (request.Page - 1) * request.PageSize; start < len(files) {
+		response.Files = files[start:min(start+request.PageSize, len(files))]
+	}
+
+	return &response, nil
+}
+
+// OpenFile opens final file of upload for download, caller must close it
+func (f *fileService) OpenFile(ctx context.Context, uploadID string) (*entity.FileMetadata, io.ReadSeekCloser, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	metadata, err := f.GetFileMetadata(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, nil, err
+	}
+
+	// file completed while scanning was disabled is scanned before its first read
+	if metadata.Scan == nil && f.scanner != nil {
+		if metadata, err = f.ScanFile(ctx, metadata); err != nil {
+			logger.Error(err)
+			return nil, nil, err
+		}
+	}
+
+	if err = CheckScan(metadata); err != nil {
+		logger.Error(err)
+		return nil, nil, err
+	}
+
+	content, err := f.storage.OpenBlob(ctx, metadata.CheckSum)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = entity.Errorf(entity.ErrCodeNotFound, "file of upload [%s] not found: %w", uploadID, err)
 		}
+
+		logger.Error(err)
+		return nil, nil, err
+	}
+
+	return metadata, content, nil
+}
+
+// AbortUpload removes chunk files and state of upload which is not assembled yet
+func (f *fileService) AbortUpload(ctx context.Context, uploadID string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	session, err := f.GetSession(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	// upload being assembled can't be aborted, it is either completed or corrupted after that
+	unlock, ok := f.locks.assembly.TryLock(session.ID)
+	if !ok {
+		err = entity.Errorf(entity.ErrCodeConflict, "upload [%s] is being assembled", session.ID)
+		logger.Error(err)
+		return err
+	}
+
+	defer unlock()
+
+	if _, err = f.storage.GetMetadata(ctx, session.ID, fileMetadataFilename); err == nil {
+		err = entity.Errorf(entity.ErrCodeConflict, "upload [%s] already completed, delete its file instead", session.ID)
+		logger.Error(err)
+		return err
+	}
+
+	// chunk is recorded under manifest lock, so chunk stored meanwhile finds upload removed and discards itself
+	unlockManifest := f.locks.manifest.Lock(session.ID)
+	err = f.storage.Delete(ctx, session.ID)
+	unlockManifest()
+	if err != nil {
+		logger.Error(err)
+		return err
 	}
 
+	f.locks.openUploads.Remove(session.ID)
+
+	f.Notify(ctx, NewWebhookEvent(ctx, entity.EventUploadAborted, NewFileMetadata(session, session.TotalSize, session.CheckSum, time.Now())))
+
+	logger.Infof("success abort upload [%s] 🗑️", session.ID)
 	return nil
 }
 
-// WriteChunkToFinalFile writes content from chunk file to final file
-func (f *fileService) WriteChunkToFinalFile(ctx context.Context, chunkFilePath string, finalFile *os.File) error {
+// DeleteFile removes state of completed upload and its reference of blob, blob is removed with its last reference
+func (f *fileService) DeleteFile(ctx context.Context, uploadID string) error {
 	ctx, span := gootel.RecordSpan(ctx)
 	defer span.End()
 
 	logger := logrus.WithContext(ctx)
 
-	// open chunk file
-	chunkFile, err := os.Open(chunkFilePath)
+	session, err := f.GetSession(ctx, uploadID)
 	if err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// don't forget to close chunk file at the end
-	defer chunkFile.Close()
+	unlock, ok := f.locks.assembly.TryLock(session.ID)
+	if !ok {
+		err = entity.Errorf(entity.ErrCodeConflict, "upload [%s] is being assembled", session.ID)
+		logger.Error(err)
+		return err
+	}
 
-	// get buffer from Pool
-	buf := utils.ByteBufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		buf.Reset()
-		utils.ByteBufferPool.Put(buf)
-	}()
+	defer unlock()
 
-	// copy content from chunk file to buffer. chunk file will be empty after this
-	if _, err = io.Copy(buf, chunkFile); err != nil {
+	metadata, err := f.GetFileMetadata(ctx, session.ID)
+	if err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// write content from chunk file to final file
-	if _, err = finalFile.Write(buf.Bytes()); err != nil {
+	// upload is removed before its reference, crash in between leaks blob rather than breaks other uploads
+	if err = f.storage.Delete(ctx, session.ID); err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// remove chunk file after write
-	if err = os.RemoveAll(chunkFilePath); err != nil {
+	unlockBlob := f.locks.blob.Lock(metadata.CheckSum)
+	defer unlockBlob()
+
+	if err = f.RemoveBlobRef(ctx, metadata.CheckSum, session.ID); err != nil {
 		logger.Error(err)
 		return err
 	}
 
-	// success write
-	logger.Infof("success write from chunk file %s to final file", chunkFilePath)
+	logger.Infof("success delete file %s [%s] 🗑️", session.Filename, session.ID)
 	return nil
 }
+
+// MatchContentType checks media type against filter, empty filter matches all and "type/*" matches its subtypes
+func MatchContentType(contentType, filter string) bool {
+	if filter == "" {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if prefix, ok := strings.CutSuffix(filter, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+
+	return strings.EqualFold(mediaType, filter)
+}
+
+// ContentType returns media type of filename by its extension
+func ContentType(filename string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFileService(t *testing.T, config UploadConfig) (*fileService, entity.Storage) {
+	t.Helper()
+
+	validate := validator.New()
+	if err := RegisterFilenameValidation(validate); err != nil {
+		t.Fatalf("RegisterFilenameValidation() error = %v", err)
+	}
+
+	memoryStorage := storage.NewMemoryStorage()
+	return NewFileService(validate, memoryStorage, nil, nil, nil, NewUploadLocks(), config).(*fileService), memoryStorage
+}
+
+func TestOpenSessionConcurrentUploads(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		sessions  int
+		abortOne  bool
+		wantOpens int
+	}{
+		{name: "under limit", limit: 3, sessions: 2, wantOpens: 2},
+		{name: "over limit", limit: 2, sessions: 3, wantOpens: 2},
+		{name: "aborted upload frees slot", limit: 2, sessions: 3, abortOne: true, wantOpens: 3},
+		{name: "unlimited", limit: 0, sessions: 5, wantOpens: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			service, _ := newTestFileService(t, UploadConfig{ConcurrentUploads: tt.limit})
+			request := entity.CreateSessionRequestDTO{Filename: "a.txt", TotalSize: 5, TotalChunk: 1, Uploader: "10.0.0.1"}
+
+			var opens int
+			for i := 0; i < tt.sessions; i++ {
+				session, err := service.CreateSession(ctx, request)
+				if err != nil {
+					var appErr *entity.Error
+					if !errors.As(err, &appErr) || appErr.Code != entity.ErrCodeRateLimited {
+						t.Fatalf("CreateSession() error = %v, want code %s", err, entity.ErrCodeRateLimited)
+					}
+
+					continue
+				}
+
+				opens++
+				if tt.abortOne && i == 0 {
+					if err = service.AbortUpload(ctx, session.ID); err != nil {
+						t.Fatalf("AbortUpload() error = %v", err)
+					}
+				}
+			}
+
+			if opens != tt.wantOpens {
+				t.Errorf("opened %d sessions, want %d", opens, tt.wantOpens)
+			}
+
+			// other client has its own limit
+			if _, err := service.CreateSession(ctx, entity.CreateSessionRequestDTO{Filename: "a.txt", TotalSize: 5, TotalChunk: 1, Uploader: "10.0.0.2"}); err != nil {
+				t.Errorf("CreateSession() of other client error = %v", err)
+			}
+		})
+	}
+}
+
+// composeCountingStorage counts composed blobs. chunks are held until all concurrent senders store them,
+// so each sender sees upload complete, and compose is slowed down so their assemblies overlap
+type composeCountingStorage struct {
+	entity.Storage
+	senders  sync.WaitGroup
+	composed atomic.Int32
+}
+
+func (c *composeCountingStorage) PutChunk(ctx context.Context, uploadID string, chunkIndex int, content io.Reader) (int64, error) {
+	c.senders.Done()
+	c.senders.Wait()
+	return c.Storage.PutChunk(ctx, uploadID, chunkIndex, content)
+}
+
+func (c *composeCountingStorage) ComposeBlob(ctx context.Context, uploadID string, totalChunk int, w io.Writer, validate func() (string, error)) (int64, error) {
+	c.composed.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return c.Storage.ComposeBlob(ctx, uploadID, totalChunk, w, validate)
+}
+
+func TestUploadChunkConcurrentLastChunk(t *testing.T) {
+	const senders = 8
+
+	ctx := context.Background()
+	service, memoryStorage := newTestFileService(t, UploadConfig{})
+	session := createTestSession(t, service)
+	uploadTestChunk(t, service, session.ID, 0, "hello")
+
+	countingStorage := &composeCountingStorage{Storage: memoryStorage}
+	countingStorage.senders.Add(senders)
+	service.storage = countingStorage
+
+	// last chunk is sent again by client retries while first one is still assembling
+	var (
+		wg       sync.WaitGroup
+		checksum = sha256.Sum256([]byte("world"))
+	)
+
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := service.UploadChunk(ctx, entity.UploadChunkRequestServiceDTO{
+				RequestHeader: entity.RequestHeaderDTO{UploadID: session.ID, CheckSum: hex.EncodeToString(checksum[:]), ChunkIndex: 1},
+				Content:       strings.NewReader("world"),
+			}); err != nil {
+				t.Errorf("UploadChunk() error = %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if composed := countingStorage.composed.Load(); composed != 1 {
+		t.Errorf("final file composed %d times, want 1", composed)
+	}
+
+	_, file, err := service.OpenFile(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+
+	defer file.Close()
+
+	if content, err := io.ReadAll(file); err != nil || string(content) != "helloworld" {
+		t.Errorf("final file = %q, %v, want %q", content, err, "helloworld")
+	}
+}
+
+func TestUploadChunkFileChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	service, memoryStorage := newTestFileService(t, UploadConfig{})
+
+	declared := sha256.Sum256([]byte("helloworld"))
+	session, err := service.CreateSession(ctx, entity.CreateSessionRequestDTO{Filename: "a.txt", TotalSize: 10, TotalChunk: 2, CheckSum: hex.EncodeToString(declared[:]), Uploader: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	// each chunk is valid by its own checksum, whole file is not
+	uploadTestChunk(t, service, session.ID, 0, "hello")
+
+	checksum := sha256.Sum256([]byte("wOrld"))
+	err = service.UploadChunk(ctx, entity.UploadChunkRequestServiceDTO{
+		RequestHeader: entity.RequestHeaderDTO{UploadID: session.ID, CheckSum: hex.EncodeToString(checksum[:]), ChunkIndex: 1},
+		Content:       strings.NewReader("wOrld"),
+	})
+
+	var appErr *entity.Error
+	if !errors.As(err, &appErr) || appErr.Code != entity.ErrCodeFileChecksumMismatch {
+		t.Fatalf("UploadChunk() error = %v, want code %s", err, entity.ErrCodeFileChecksumMismatch)
+	}
+
+	// chunks are reset, corrupt file is recorded and never published
+	if chunks, err := memoryStorage.ListChunks(ctx, session.ID); err != nil || len(chunks) != 0 {
+		t.Errorf("ListChunks() = %v, %v, want none", chunks, err)
+	}
+
+	content, err := memoryStorage.GetMetadata(ctx, session.ID, corruptFilename)
+	if err != nil {
+		t.Fatalf("GetMetadata() of %s error = %v", corruptFilename, err)
+	}
+
+	actual := sha256.Sum256([]byte("hellowOrld"))
+	if !strings.Contains(string(content), hex.EncodeToString(actual[:])) {
+		t.Errorf("%s = %s, want actual checksum %x", corruptFilename, content, actual)
+	}
+
+	if blobs, err := memoryStorage.ListBlobs(ctx); err != nil || len(blobs) != 0 {
+		t.Errorf("ListBlobs() = %v, %v, want none", blobs, err)
+	}
+
+	status, err := service.GetStatus(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+
+	if !status.Corrupted || status.Assembled || len(status.MissingChunks) != 2 {
+		t.Errorf("GetStatus() = %+v, want corrupted with every chunk missing", status)
+	}
+
+	// chunks sent again complete upload
+	uploadTestChunk(t, service, session.ID, 0, "hello")
+	uploadTestChunk(t, service, session.ID, 1, "world")
+
+	if status, err = service.GetStatus(ctx, session.ID); err != nil || !status.Assembled {
+		t.Errorf("GetStatus() after chunks sent again = %+v, %v, want assembled", status, err)
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/internal/entity"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// filenameTag is validation tag of filename policy, registered by RegisterFilenameValidation
+const filenameTag = "filename"
+
+// reservedFilenames are device names of windows, file named by them can't be saved there whatever its extension
+var reservedFilenames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// filenamePolicy is policy registered as filename tag, it explains which rule rejected filename
+var filenamePolicy *FilenamePolicy
+
+// FilenamePolicy is rules of filename given by client. filename is metadata only, it never names file in storage,
+// but it is sent back as download name so it must be safe to save on any client
+type FilenamePolicy struct {
+	MaxLength int
+	Allowed   *regexp.Regexp
+	Denied    *regexp.Regexp
+}
+
+// NewFilenamePolicy creates filename policy from config
+func NewFilenamePolicy() (*FilenamePolicy, error) {
+	policy := FilenamePolicy{MaxLength: config.FilenameMaxLength()}
+
+	var err error
+	if pattern := config.FilenameAllowedPattern(); pattern != "" {
+		if policy.Allowed, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid allowed filename pattern : %w", err)
+		}
+	}
+
+	if pattern := config.FilenameDeniedPattern(); pattern != "" {
+		if policy.Denied, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid denied filename pattern : %w", err)
+		}
+	}
+
+	return &policy, nil
+}
+
+// RegisterFilenameValidation registers filename policy from config as "filename" tag of validator
+func RegisterFilenameValidation(validate *validator.Validate) error {
+	policy, err := NewFilenamePolicy()
+	if err != nil {
+		return err
+	}
+
+	filenamePolicy = policy
+	return validate.RegisterValidation(filenameTag, func(fl validator.FieldLevel) bool {
+		if reason := policy.Check(fl.Field().String()); reason != "" {
+			logrus.Warnf("filename rejected : %s ⚠️", reason)
+			return false
+		}
+
+		return true
+	})
+}
+
+// FilenameError replaces validation error of filename tag by error telling rule which rejected filename,
+// so client knows how to rename file. other errors are returned as is
+func FilenameError(err error) error {
+	var validationErrors validator.ValidationErrors
+	if filenamePolicy == nil || !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	for _, fieldError := range validationErrors {
+		if fieldError.Tag() != filenameTag {
+			continue
+		}
+
+		if reason := filenamePolicy.Check(fmt.Sprint(fieldError.Value())); reason != "" {
+			return entity.Errorf(entity.ErrCodeValidation, "invalid filename : %s", reason)
+		}
+	}
+
+	return err
+}
+
+// NormalizeFilename normalizes filename into NFC form, and trims spaces around it and dots after it
+// which are dropped by windows anyway
+func NormalizeFilename(filename string) string {
+	filename = norm.NFC.String(filename)
+	filename = strings.TrimSpace(filename)
+	return strings.TrimRightFunc(filename, func(r rune) bool {
+		return r == '.' || unicode.IsSpace(r)
+	})
+}
+
+// Check checks normalized filename against policy, it returns reason of rejection or empty if filename is allowed
+func (p *FilenamePolicy) Check(filename string) string {
+	if filename == "" {
+		return "filename is empty"
+	}
+
+	if !utf8.ValidString(filename) {
+		return "filename is not valid utf-8"
+	}
+
+	if p.MaxLength > 0 && len(filename) > p.MaxLength {
+		return fmt.Sprintf("filename is %d bytes, more than %d bytes", len(filename), p.MaxLength)
+	}
+
+	// filename is name of one file, never path
+	if strings.ContainsAny(filename, `/\`) || filename == "." || filename == ".." {
+		return "filename " + filename + " is a path"
+	}
+
+	if strings.IndexFunc(filename, unicode.IsControl) >= 0 {
+		return "filename contains control character"
+	}
+
+	base, _, _ := strings.Cut(filename, ".")
+	if reservedFilenames[strings.ToUpper(strings.TrimSpace(base))] {
+		return "filename " + filename + " is reserved"
+	}
+
+	if p.Allowed != nil && !p.Allowed.MatchString(filename) {
+		return "filename " + filename + " doesn't match allowed pattern"
+	}
+
+	if p.Denied != nil && p.Denied.MatchString(filename) {
+		return "filename " + filename + " matches denied pattern"
+	}
+
+	return ""
+}
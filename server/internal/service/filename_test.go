@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/internal/entity"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilenamePolicyCheck(t *testing.T) {
+	policy := FilenamePolicy{
+		MaxLength: 16,
+		Denied:    regexp.MustCompile(`\.exe$`),
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		reason   string
+	}{
+		{name: "allowed", filename: "report.pdf"},
+		{name: "empty", filename: "", reason: "filename is empty"},
+		{name: "too long", filename: "a-very-long-filename.txt", reason: "more than 16 bytes"},
+		{name: "path", filename: "../passwd", reason: "is a path"},
+		{name: "windows path", filename: `dir\a.txt`, reason: "is a path"},
+		{name: "control character", filename: "a\x00.txt", reason: "control character"},
+		{name: "reserved", filename: "nul.txt", reason: "is reserved"},
+		{name: "denied", filename: "setup.exe", reason: "matches denied pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := policy.Check(tt.filename)
+			if tt.reason == "" && reason != "" || !strings.Contains(reason, tt.reason) {
+				t.Errorf("Check(%q) = %q, want %q", tt.filename, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestFilenameError(t *testing.T) {
+	filenamePolicy = &FilenamePolicy{Allowed: regexp.MustCompile(`\.txt$`)}
+	t.Cleanup(func() { filenamePolicy = nil })
+
+	validate := validator.New()
+	if err := validate.RegisterValidation(filenameTag, func(fl validator.FieldLevel) bool {
+		return filenamePolicy.Check(fl.Field().String()) == ""
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		request entity.CreateSessionRequestDTO
+		message string
+	}{
+		{name: "rule of filename", request: entity.CreateSessionRequestDTO{Filename: "a.pdf", TotalSize: 1, TotalChunk: 1}, message: "invalid filename : filename a.pdf doesn't match allowed pattern"},
+		{name: "other field", request: entity.CreateSessionRequestDTO{Filename: "a.txt", TotalChunk: 1}, message: "'TotalSize' failed on the 'required' tag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := FilenameError(validate.Struct(tt.request))
+
+			var appErr *entity.Error
+			if errors.As(err, &appErr) != strings.HasPrefix(tt.message, "invalid filename") {
+				t.Errorf("FilenameError() = %T, want entity.Error for filename only", err)
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("FilenameError() = %v, want %q", err, tt.message)
+			}
+		})
+	}
+}
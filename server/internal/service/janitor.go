@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"sync"
+	"time"
+)
+
+type janitorService struct {
+	storage     entity.Storage
+	fileService *fileService
+	tenants     []*entity.Tenant
+	interval    time.Duration
+	chanQuit    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewJanitorService creates new instance of janitorService. it implements from interface JanitorService.
+// janitor removes chunk files of uploads untouched longer than upload ttl of their tenant,
+// and files older than retention of their tenant, checked every interval
+func NewJanitorService(storage entity.Storage, webhook entity.WebhookService, tenants []*entity.Tenant, interval time.Duration, locks *UploadLocks) entity.JanitorService {
+	return &janitorService{
+		storage:     storage,
+		fileService: &fileService{storage: storage, webhook: webhook, locks: locks},
+		tenants:     tenants,
+		interval:    interval,
+		chanQuit:    make(chan struct{}),
+	}
+}
+
+// Start spawns goroutine which sweeps abandoned uploads every interval
+func (j *janitorService) Start() {
+	j.wg.Add(1)
+
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		logrus.Infof("start janitor of %d tenants, interval %s 🧹", len(j.tenants), j.interval)
+		for {
+			select {
+			case <-ticker.C:
+				ctx, span := gootel.NewSpan(context.Background(), "janitor", "")
+				_, _ = j.Sweep(ctx)
+				span.End()
+			case <-j.chanQuit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops janitor and waits until running sweep finished
+func (j *janitorService) Stop() {
+	close(j.chanQuit)
+	j.wg.Wait()
+	logrus.Infof("janitor stopped 🧹")
+}
+
+// Sweep sweeps uploads of every tenant, returns removed upload IDs
+func (j *janitorService) Sweep(ctx context.Context) ([]string, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	deleted := []string{}
+	for _, tenant := range j.tenants {
+		removed, err := j.SweepTenant(entity.ContextWithTenant(ctx, tenant), tenant)
+		if err != nil {
+			logger.Warnf("skip tenant [%s] : %s ⚠️", tenant.ID, err.Error())
+			continue
+		}
+
+		deleted = append(deleted, removed...)
+	}
+
+	return deleted, nil
+}
+
+// SweepTenant removes uploads of tenant which are not assembled and untouched longer than its upload ttl,
+// and files completed longer than its retention ago. returns removed upload IDs
+func (j *janitorService) SweepTenant(ctx context.Context, tenant *entity.Tenant) ([]string, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	uploadIDs, err := j.storage.ListUploads(ctx)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	deleted := []string{}
+	for _, uploadID := range uploadIDs {
+		session, lastTouched, err := j.LastTouched(ctx, uploadID)
+		if err != nil {
+			logger.Warnf("skip upload [%s] : %s ⚠️", uploadID, err.Error())
+			continue
+		}
+
+		if lastTouched.IsZero() {
+			if expired, err := j.Expire(ctx, tenant, uploadID); err != nil {
+				logger.Warnf("skip file [%s] : %s ⚠️", uploadID, err.Error())
+			} else if expired {
+				deleted = append(deleted, uploadID)
+			}
+
+			continue
+		}
+
+		if time.Since(lastTouched) < tenant.UploadTTL {
+			continue
+		}
+
+		if ok, err := j.DeleteAbandoned(ctx, tenant, uploadID); err != nil {
+			logger.Warnf("skip upload [%s] : %s ⚠️", uploadID, err.Error())
+			continue
+		} else if !ok {
+			continue
+		}
+
+		// folder without manifest was never known as upload by clients, nothing is published
+		if session != nil {
+			j.fileService.Notify(ctx, NewWebhookEvent(ctx, entity.EventUploadExpired, NewFileMetadata(session, session.TotalSize, session.CheckSum, time.Now())))
+		}
+
+		deleted = append(deleted, uploadID)
+		span.AddEvent("delete abandoned upload", trace.WithAttributes(
+			attribute.String("upload.id", uploadID),
+			attribute.String("upload.last_touched", lastTouched.Format(time.RFC3339)),
+		))
+		logger.Infof("delete abandoned upload [%s], untouched since %s 🧹", uploadID, lastTouched.Format(time.RFC3339))
+	}
+
+	span.SetAttributes(
+		attribute.String("tenant.id", tenant.ID),
+		attribute.Int("janitor.scanned", len(uploadIDs)),
+		attribute.Int("janitor.deleted", len(deleted)),
+	)
+
+	return deleted, nil
+}
+
+// DeleteAbandoned removes upload found abandoned, unless it is being assembled or touched since then.
+// upload being assembled is skipped, it is checked again by next sweep
+func (j *janitorService) DeleteAbandoned(ctx context.Context, tenant *entity.Tenant, uploadID string) (bool, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	unlock, ok := j.fileService.locks.assembly.TryLock(uploadID)
+	if !ok {
+		logger.Infof("skip upload [%s], it is being assembled", uploadID)
+		return false, nil
+	}
+
+	defer unlock()
+
+	// chunk is recorded under manifest lock, so upload touched meanwhile is kept and chunk stored after removal discards itself
+	unlockManifest := j.fileService.locks.manifest.Lock(uploadID)
+	defer unlockManifest()
+
+	// assembly may have completed, or chunk recorded, before lock taken
+	_, lastTouched, err := j.LastTouched(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return false, err
+	}
+
+	if lastTouched.IsZero() || time.Since(lastTouched) < tenant.UploadTTL {
+		return false, nil
+	}
+
+	if err = j.storage.Delete(ctx, uploadID); err != nil {
+		logger.Error(err)
+		return false, err
+	}
+
+	j.fileService.locks.openUploads.Remove(uploadID)
+	return true, nil
+}
+
+// LastTouched retrieves session of upload and last time its manifest updated. zero time means upload is already assembled.
+// folder without manifest has no session, it was last touched by its newest chunk
+func (j *janitorService) LastTouched(ctx context.Context, uploadID string) (*entity.UploadSession, time.Time, error) {
+	manifest, err := j.fileService.GetManifest(ctx, uploadID)
+	if errors.Is(err, os.ErrNotExist) {
+		lastTouched, err := j.OrphanTouched(ctx, uploadID)
+		return nil, lastTouched, err
+	} else if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// assembled upload is not abandoned
+	session := &manifest.Session
+	if _, err = j.storage.GetMetadata(ctx, session.ID, fileMetadataFilename); err == nil {
+		return session, time.Time{}, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, err
+	}
+
+	lastTouched := session.CreatedAt
+	if manifest.UpdatedAt.After(lastTouched) {
+		lastTouched = manifest.UpdatedAt
+	}
+
+	return session, lastTouched, nil
+}
+
+// OrphanTouched retrieves last time folder of upload without manifest was touched, by its newest chunk.
+// such folder is left by chunk stored while its upload was removed, or by crash before manifest recorded.
+// folder without chunks holds nothing to keep, it is touched at zero unix time so it is removed by next sweep
+func (j *janitorService) OrphanTouched(ctx context.Context, uploadID string) (time.Time, error) {
+	// folder not named by upload ID is not ours to remove
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return time.Time{}, fmt.Errorf("folder [%s] is not an upload", uploadID)
+	}
+
+	// upload of older version keeps session file until migrated by recovery, file metadata means it is assembled
+	for _, name := range []string{sessionFilename, fileMetadataFilename} {
+		if _, err := j.storage.GetMetadata(ctx, uploadID, name); err == nil {
+			return time.Time{}, fmt.Errorf("upload [%s] has %s but no manifest, it is left to recovery", uploadID, name)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return time.Time{}, err
+		}
+	}
+
+	chunks, err := j.storage.ListChunks(ctx, uploadID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lastTouched := time.Unix(0, 0)
+	for _, chunk := range chunks {
+		if chunk.ModTime.After(lastTouched) {
+			lastTouched = chunk.ModTime
+		}
+	}
+
+	return lastTouched, nil
+}
+
+// Expire removes assembled file completed longer than retention of tenant ago, zero retention keeps files forever
+func (j *janitorService) Expire(ctx context.Context, tenant *entity.Tenant, uploadID string) (bool, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if tenant.Retention <= 0 {
+		return false, nil
+	}
+
+	metadata, err := j.fileService.LoadFileMetadata(ctx, uploadID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		logger.Error(err)
+		return false, err
+	}
+
+	if time.Since(metadata.CompletedAt) < tenant.Retention {
+		return false, nil
+	}
+
+	if err = j.fileService.DeleteFile(ctx, uploadID); err != nil {
+		logger.Error(err)
+		return false, err
+	}
+
+	j.fileService.Notify(ctx, NewWebhookEvent(ctx, entity.EventFileExpired, metadata))
+
+	logger.Infof("delete file %s [%s] of tenant [%s], retention %s passed 🧹", metadata.Filename, uploadID, tenant.ID, tenant.Retention)
+	return true, nil
+}
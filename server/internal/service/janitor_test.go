@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSweepTenant(t *testing.T) {
+	tests := []struct {
+		name        string
+		uploadTTL   time.Duration
+		setup       func(t *testing.T, service *fileService, storage entity.Storage) string
+		wantDeleted bool
+	}{
+		{
+			name:      "abandoned upload",
+			uploadTTL: time.Nanosecond,
+			setup: func(t *testing.T, service *fileService, storage entity.Storage) string {
+				return createTestSession(t, service).ID
+			},
+			wantDeleted: true,
+		},
+		{
+			name:      "active upload",
+			uploadTTL: time.Hour,
+			setup: func(t *testing.T, service *fileService, storage entity.Storage) string {
+				return createTestSession(t, service).ID
+			},
+		},
+		{
+			name:      "chunk without manifest",
+			uploadTTL: time.Nanosecond,
+			setup: func(t *testing.T, service *fileService, storage entity.Storage) string {
+				return putOrphanChunk(t, storage, uuid.NewString())
+			},
+			wantDeleted: true,
+		},
+		{
+			name:      "recent chunk without manifest",
+			uploadTTL: time.Hour,
+			setup: func(t *testing.T, service *fileService, storage entity.Storage) string {
+				return putOrphanChunk(t, storage, uuid.NewString())
+			},
+		},
+		{
+			name:      "file metadata without manifest",
+			uploadTTL: time.Nanosecond,
+			setup: func(t *testing.T, service *fileService, storage entity.Storage) string {
+				uploadID := putOrphanChunk(t, storage, uuid.NewString())
+				if err := storage.PutMetadata(context.Background(), uploadID, fileMetadataFilename, []byte(`{}`)); err != nil {
+					t.Fatalf("PutMetadata() error = %v", err)
+				}
+
+				return uploadID
+			},
+		},
+		{
+			name:      "folder not named by upload",
+			uploadTTL: time.Nanosecond,
+			setup: func(t *testing.T, service *fileService, storage entity.Storage) string {
+				return putOrphanChunk(t, storage, "not-an-upload")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			service, memoryStorage := newTestFileService(t, UploadConfig{})
+			janitor := NewJanitorService(memoryStorage, nil, nil, time.Minute, service.locks).(*janitorService)
+			uploadID := tt.setup(t, service, memoryStorage)
+
+			deleted, err := janitor.SweepTenant(ctx, &entity.Tenant{ID: entity.DefaultTenant, UploadTTL: tt.uploadTTL})
+			if err != nil {
+				t.Fatalf("SweepTenant() error = %v", err)
+			}
+
+			if got := slices.Contains(deleted, uploadID); got != tt.wantDeleted {
+				t.Errorf("SweepTenant() deleted = %v, want upload deleted %v", deleted, tt.wantDeleted)
+			}
+
+			uploadIDs, err := memoryStorage.ListUploads(ctx)
+			if err != nil {
+				t.Fatalf("ListUploads() error = %v", err)
+			}
+
+			if kept := slices.Contains(uploadIDs, uploadID); kept == tt.wantDeleted {
+				t.Errorf("upload kept = %v after sweep, want %v", kept, !tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestUploadChunkWhileRemoved(t *testing.T) {
+	ctx := context.Background()
+	service, memoryStorage := newTestFileService(t, UploadConfig{})
+	session := createTestSession(t, service)
+	uploadTestChunk(t, service, session.ID, 0, "hello")
+
+	// upload is aborted while its chunk is streamed to storage
+	content := &removingReader{Reader: strings.NewReader("world"), remove: func() {
+		if err := service.AbortUpload(ctx, session.ID); err != nil {
+			t.Errorf("AbortUpload() error = %v", err)
+		}
+	}}
+
+	checksum := sha256.Sum256([]byte("world"))
+	err := service.UploadChunk(ctx, entity.UploadChunkRequestServiceDTO{
+		RequestHeader: entity.RequestHeaderDTO{UploadID: session.ID, CheckSum: hex.EncodeToString(checksum[:]), ChunkIndex: 1},
+		Content:       content,
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("UploadChunk() of removed upload error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	// stored chunk doesn't bring removed upload back
+	if uploadIDs, err := memoryStorage.ListUploads(ctx); err != nil || len(uploadIDs) != 0 {
+		t.Errorf("ListUploads() = %v, %v, want none", uploadIDs, err)
+	}
+}
+
+// removingReader removes upload on first read, like abort or janitor while chunk is streamed
+type removingReader struct {
+	io.Reader
+	remove func()
+}
+
+func (r *removingReader) Read(p []byte) (int, error) {
+	if r.remove != nil {
+		r.remove()
+		r.remove = nil
+	}
+
+	return r.Reader.Read(p)
+}
+
+func createTestSession(t *testing.T, service *fileService) *entity.UploadSession {
+	t.Helper()
+
+	session, err := service.CreateSession(context.Background(), entity.CreateSessionRequestDTO{Filename: "a.txt", TotalSize: 10, TotalChunk: 2, Uploader: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	return session
+}
+
+func uploadTestChunk(t *testing.T, service *fileService, uploadID string, chunkIndex int, content string) {
+	t.Helper()
+
+	checksum := sha256.Sum256([]byte(content))
+	if err := service.UploadChunk(context.Background(), entity.UploadChunkRequestServiceDTO{
+		RequestHeader: entity.RequestHeaderDTO{UploadID: uploadID, CheckSum: hex.EncodeToString(checksum[:]), ChunkIndex: chunkIndex},
+		Content:       strings.NewReader(content),
+	}); err != nil {
+		t.Fatalf("UploadChunk() of chunk %d error = %v", chunkIndex, err)
+	}
+}
+
+func putOrphanChunk(t *testing.T, storage entity.Storage, uploadID string) string {
+	t.Helper()
+
+	if _, err := storage.PutChunk(context.Background(), uploadID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutChunk() error = %v", err)
+	}
+
+	return uploadID
+}
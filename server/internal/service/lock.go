@@ -0,0 +1,28 @@
+package service
+
+import "go-upload-chunk/server/internal/utils"
+
+// UploadLocks holds locks of uploads and blobs, services sharing storage must share them too.
+// it is created once at startup and passed into every service, so tests and tenants never share hidden state
+type UploadLocks struct {
+	assembly   *utils.KeyedMutex // serializes final file assembly per upload ID
+	manifest   *utils.KeyedMutex // serializes read-modify-write of manifest per upload ID
+	tusWrite   *utils.KeyedMutex // serializes tus PATCH requests per upload ID
+	blob       *utils.KeyedMutex // serializes publish, reference and removal of blob per checksum, so blob is never removed while referenced
+	processing *utils.KeyedMutex // serializes updates of processing status per upload ID
+
+	// openUploads holds IDs of uploads not completed yet per uploader, it limits concurrent uploads of each client.
+	// upload leaves it once completed, rejected, aborted or removed by janitor
+	openUploads *utils.KeyedSet
+}
+
+func NewUploadLocks() *UploadLocks {
+	return &UploadLocks{
+		assembly:    utils.NewKeyedMutex(),
+		manifest:    utils.NewKeyedMutex(),
+		tusWrite:    utils.NewKeyedMutex(),
+		blob:        utils.NewKeyedMutex(),
+		processing:  utils.NewKeyedMutex(),
+		openUploads: utils.NewKeyedSet(),
+	}
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// manifestFilename is metadata name of upload manifest
+const manifestFilename = "manifest.json"
+
+// GetManifest retrieves manifest of upload by given upload ID
+func (f *fileService) GetManifest(ctx context.Context, uploadID string) (*entity.UploadManifest, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// upload ID is used as folder name, make sure it is not a path
+	if _, err := uuid.Parse(uploadID); err != nil {
+		err = entity.Errorf(entity.ErrCodeNotFound, "invalid upload id [%s]: %w", uploadID, os.ErrNotExist)
+		logger.Error(err)
+		return nil, err
+	}
+
+	content, err := f.storage.GetMetadata(ctx, uploadID, manifestFilename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = entity.Errorf(entity.ErrCodeNotFound, "upload session [%s] not found: %w", uploadID, err)
+		}
+
+		logger.Error(err)
+		return nil, err
+	}
+
+	var manifest entity.UploadManifest
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// PutManifest records manifest of upload into storage
+func (f *fileService) PutManifest(ctx context.Context, manifest *entity.UploadManifest) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err = f.storage.PutMetadata(ctx, manifest.Session.ID, manifestFilename, content); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateManifest applies update to manifest of upload and records it, updates of one upload are serialized
+func (f *fileService) UpdateManifest(ctx context.Context, uploadID string, update func(manifest *entity.UploadManifest) error) (*entity.UploadManifest, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	unlock := f.locks.manifest.Lock(uploadID)
+	defer unlock()
+
+	manifest, err := f.GetManifest(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if err = update(manifest); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	sort.Slice(manifest.Chunks, func(i, j int) bool {
+		return manifest.Chunks[i].Index < manifest.Chunks[j].Index
+	})
+
+	manifest.UpdatedAt = time.Now()
+	if err = f.PutManifest(ctx, manifest); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// RecordChunk records stored chunk into manifest of upload. chunk already recorded is kept as is
+func (f *fileService) RecordChunk(ctx context.Context, uploadID string, chunk entity.ChunkManifest) (*entity.UploadManifest, error) {
+	return f.UpdateManifest(ctx, uploadID, func(manifest *entity.UploadManifest) error {
+		if manifest.Rejected != "" {
+			return entity.Errorf(entity.ErrCodeUnsupportedMediaType, "upload [%s] rejected : %s", uploadID, manifest.Rejected)
+		}
+
+		if _, ok := manifest.Chunk(chunk.Index); ok {
+			return nil
+		}
+
+		if manifest.Session.StartedAt.IsZero() {
+			manifest.Session.StartedAt = chunk.ReceivedAt
+		}
+
+		manifest.Chunks = append(manifest.Chunks, chunk)
+		return nil
+	})
+}
+
+// DiscardUnrecorded removes chunk stored but not recorded, because its upload was removed or rejected while chunk was streamed,
+// e.g. by abort, janitor or content type of other chunk. stored chunk never brings removed upload back nor stays in rejected upload
+func (f *fileService) DiscardUnrecorded(ctx context.Context, uploadID string, recordErr error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	var (
+		appErr *entity.Error
+		err    error
+	)
+
+	switch {
+	case errors.Is(recordErr, os.ErrNotExist):
+		unlock := f.locks.manifest.Lock(uploadID)
+		err = f.storage.Delete(ctx, uploadID)
+		unlock()
+	case errors.As(recordErr, &appErr) && appErr.Code == entity.ErrCodeUnsupportedMediaType:
+		err = f.storage.DeleteChunks(ctx, uploadID)
+	default:
+		return
+	}
+
+	if err != nil {
+		logger.Warnf("failed discard chunk of upload [%s] : %s ⚠️", uploadID, err.Error())
+		return
+	}
+
+	logger.Infof("discard chunk of upload [%s], %s 🗑️", uploadID, recordErr.Error())
+}
+
+// Recover reconciles manifest of every upload with storage on startup, so state survives restarts
+func (f *fileService) Recover(ctx context.Context) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	uploadIDs, err := f.storage.ListUploads(ctx)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	for _, uploadID := range uploadIDs {
+		if err = f.RecoverUpload(ctx, uploadID); err != nil {
+			logger.Warnf("failed recover upload [%s] : %s ⚠️", uploadID, err.Error())
+			continue
+		}
+
+		// processing of final file interrupted by restart is run again
+		if err = f.ResumeProcessing(ctx, uploadID); err != nil {
+			logger.Warnf("failed resume processing of upload [%s] : %s ⚠️", uploadID, err.Error())
+		}
+	}
+
+	logger.Infof("success recover %d uploads 🗂️", len(uploadIDs))
+	return nil
+}
+
+// RecoverUpload finishes work of one upload interrupted by restart:
+// chunks stored but not recorded are recorded, recorded but missing chunks are dropped,
+// complete upload is assembled and reference of its blob is recorded
+func (f *fileService) RecoverUpload(ctx context.Context, uploadID string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	manifest, err := f.GetManifest(ctx, uploadID)
+	if errors.Is(err, os.ErrNotExist) {
+		manifest, err = f.MigrateSession(ctx, uploadID)
+	}
+
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	session := &manifest.Session
+
+	// final file published by older version is moved into blob store
+	if err = f.AdoptFinalFile(ctx, session); err == nil {
+		return f.storage.DeleteChunks(ctx, session.ID)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Error(err)
+		return err
+	}
+
+	// upload completed by stored file of same checksum, interrupted before its metadata recorded
+	if session.Completed {
+		if _, err = f.storage.GetMetadata(ctx, session.ID, fileMetadataFilename); errors.Is(err, os.ErrNotExist) {
+			if _, err = f.CompleteFromBlob(ctx, session); err != nil {
+				logger.Error(err)
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// final file published, assembly was interrupted before chunks removed
+	if metadata, err := f.LoadFileMetadata(ctx, session.ID); err == nil {
+		unlockBlob := f.locks.blob.Lock(metadata.CheckSum)
+		err = f.AddBlobRef(ctx, metadata.CheckSum, session.ID)
+		unlockBlob()
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+
+		return f.storage.DeleteChunks(ctx, session.ID)
+	}
+
+	// chunks of rejected upload are never recorded
+	if manifest.Rejected != "" {
+		return f.storage.DeleteChunks(ctx, session.ID)
+	}
+
+	// upload not completed yet counts against limit of its client again, even beyond limit
+	f.locks.openUploads.Add(session.Uploader, session.ID, 0)
+
+	chunks, err := f.storage.ListChunks(ctx, session.ID)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	stored := map[int]entity.ChunkInfo{}
+	for _, chunk := range chunks {
+		stored[chunk.Index] = chunk
+	}
+
+	// chunk is renamed into place only after its checksum validated, so it is safe to record
+	var unrecorded []entity.ChunkManifest
+	for _, chunk := range chunks {
+		if _, ok := manifest.Chunk(chunk.Index); ok {
+			continue
+		}
+
+		checksum, err := f.HashChunk(ctx, session.ID, chunk.Index)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+
+		unrecorded = append(unrecorded, entity.ChunkManifest{
+			Index:      chunk.Index,
+			Size:       chunk.Size,
+			CheckSum:   checksum,
+			ReceivedAt: chunk.ModTime,
+		})
+	}
+
+	manifest, err = f.UpdateManifest(ctx, session.ID, func(manifest *entity.UploadManifest) error {
+		recorded := manifest.Chunks[:0]
+		for _, chunk := range manifest.Chunks {
+			if _, ok := stored[chunk.Index]; ok {
+				recorded = append(recorded, chunk)
+			}
+		}
+
+		manifest.Chunks = append(recorded, unrecorded...)
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if manifest.Complete() {
+		if err = f.CreateFinalFile(ctx, &manifest.Session); err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateSession creates manifest of upload recorded by older version, which has session only
+func (f *fileService) MigrateSession(ctx context.Context, uploadID string) (*entity.UploadManifest, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	content, err := f.storage.GetMetadata(ctx, uploadID, sessionFilename)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	manifest := entity.UploadManifest{
+		Chunks:    []entity.ChunkManifest{},
+		UpdatedAt: time.Now(),
+	}
+
+	if err = json.Unmarshal(content, &manifest.Session); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if err = f.PutManifest(ctx, &manifest); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	logger.Infof("success migrate session of upload [%s] into manifest", uploadID)
+	return &manifest, nil
+}
+
+// HashChunk calculates sha256 checksum of stored chunk
+func (f *fileService) HashChunk(ctx context.Context, uploadID string, chunkIndex int) (string, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	chunk, err := f.storage.OpenChunk(ctx, uploadID, chunkIndex)
+	if err != nil {
+		logger.Error(err)
+		return "", err
+	}
+
+	defer chunk.Close()
+
+	hashChecksum := sha256.New()
+	if _, err = io.Copy(hashChecksum, chunk); err != nil {
+		logger.Error(err)
+		return "", err
+	}
+
+	return hex.EncodeToString(hashChecksum.Sum(nil)), nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// errCrash stands for server stopped in the middle of request
+var errCrash = errors.New("crash")
+
+// crashingStorage fails write of named metadata or chunks removal once, like server stopped right before it
+type crashingStorage struct {
+	entity.Storage
+	crash string
+}
+
+func (c *crashingStorage) crashed(name string) bool {
+	if c.crash != name {
+		return false
+	}
+
+	c.crash = ""
+	return true
+}
+
+func (c *crashingStorage) PutMetadata(ctx context.Context, uploadID, name string, content []byte) error {
+	if c.crashed(name) {
+		return errCrash
+	}
+
+	return c.Storage.PutMetadata(ctx, uploadID, name, content)
+}
+
+func (c *crashingStorage) PutBlobMetadata(ctx context.Context, checksum, name string, content []byte) error {
+	if c.crashed(name) {
+		return errCrash
+	}
+
+	return c.Storage.PutBlobMetadata(ctx, checksum, name, content)
+}
+
+func (c *crashingStorage) DeleteChunks(ctx context.Context, uploadID string) error {
+	if c.crashed("chunks") {
+		return errCrash
+	}
+
+	return c.Storage.DeleteChunks(ctx, uploadID)
+}
+
+func TestRecoverInterruptedAssembly(t *testing.T) {
+	tests := []struct {
+		name  string
+		crash string
+	}{
+		{name: "last chunk stored, not recorded", crash: manifestFilename},
+		{name: "blob published, not referenced", crash: blobRefsFilename},
+		{name: "blob referenced, file metadata not recorded", crash: fileMetadataFilename},
+		{name: "file metadata recorded, chunks not removed", crash: "chunks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			service, memoryStorage := newTestFileService(t, UploadConfig{})
+			session := createTestSession(t, service)
+			uploadTestChunk(t, service, session.ID, 0, "hello")
+
+			// server stops while last chunk is assembled
+			crashing := &crashingStorage{Storage: memoryStorage, crash: tt.crash}
+			service.storage = crashing
+
+			checksum := sha256.Sum256([]byte("world"))
+			if err := service.UploadChunk(ctx, entity.UploadChunkRequestServiceDTO{
+				RequestHeader: entity.RequestHeaderDTO{UploadID: session.ID, CheckSum: hex.EncodeToString(checksum[:]), ChunkIndex: 1},
+				Content:       strings.NewReader("world"),
+			}); !errors.Is(err, errCrash) {
+				t.Fatalf("UploadChunk() error = %v, want %v", err, errCrash)
+			}
+
+			// next run recovers upload from storage left behind, with locks of its own
+			restarted, _ := newTestFileService(t, UploadConfig{})
+			restarted.storage = memoryStorage
+			if err := restarted.Recover(ctx); err != nil {
+				t.Fatalf("Recover() error = %v", err)
+			}
+
+			_, file, err := restarted.OpenFile(ctx, session.ID)
+			if err != nil {
+				t.Fatalf("OpenFile() after Recover() error = %v", err)
+			}
+
+			content, err := io.ReadAll(file)
+			_ = file.Close()
+			if err != nil || string(content) != "helloworld" {
+				t.Errorf("final file = %q, %v, want %q", content, err, "helloworld")
+			}
+
+			blobs, err := memoryStorage.ListBlobs(ctx)
+			if err != nil || len(blobs) != 1 {
+				t.Fatalf("ListBlobs() = %v, %v, want one blob", blobs, err)
+			}
+
+			if refs, err := restarted.GetBlobRefs(ctx, blobs[0]); err != nil || !slices.Equal(refs.Uploads, []string{session.ID}) {
+				t.Errorf("blob references = %v, %v, want [%s]", refs.Uploads, err, session.ID)
+			}
+
+			if chunks, err := memoryStorage.ListChunks(ctx, session.ID); err != nil || len(chunks) != 0 {
+				t.Errorf("ListChunks() after Recover() = %v, %v, want none", chunks, err)
+			}
+		})
+	}
+}
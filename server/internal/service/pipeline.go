@@ -0,0 +1,478 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	processingFilename = "processing.json"
+	outputPrefix       = "output-" // prefix of blob file name of processor output
+)
+
+// processingJob is final file waiting for worker, it keeps tenant and trace of upload which completed it
+type processingJob struct {
+	tenant      *entity.Tenant
+	spanContext trace.SpanContext
+	metadata    *entity.FileMetadata
+}
+
+type pipelineService struct {
+	fileService *fileService
+	processors  []entity.Processor
+	workers     int
+	jobs        chan processingJob
+	chanQuit    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewPipelineService creates new instance of pipelineService. it implements from interface PipelineService.
+// at most workers final files are processed at once, queueSize files wait for free worker
+func NewPipelineService(storage entity.Storage, processors []entity.Processor, workers, queueSize int, locks *UploadLocks) entity.PipelineService {
+	return &pipelineService{
+		fileService: &fileService{storage: storage, locks: locks},
+		processors:  processors,
+		workers:     max(workers, 1),
+		jobs:        make(chan processingJob, max(queueSize, 0)),
+		chanQuit:    make(chan struct{}),
+	}
+}
+
+// Start spawns workers which run processors on submitted final files
+func (p *pipelineService) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+
+		go func() {
+			defer p.wg.Done()
+
+			for {
+				select {
+				case job := <-p.jobs:
+					p.Run(job)
+				case <-p.chanQuit:
+					return
+				}
+			}
+		}()
+	}
+
+	logrus.Infof("start pipeline of %d processors, %d workers ⚙️", len(p.processors), p.workers)
+}
+
+// Stop stops workers and waits until running processors finished. files still waiting stay pending,
+// they are submitted again by recovery of next run
+func (p *pipelineService) Stop() {
+	close(p.chanQuit)
+	p.wg.Wait()
+	logrus.Infof("pipeline stopped ⚙️")
+}
+
+// Submit queues final file for workers without waiting, it is called while upload is locked.
+// file not queued because queue is full stays pending, it is resumed by recovery of next run
+func (p *pipelineService) Submit(ctx context.Context, metadata *entity.FileMetadata) error {
+	return p.submit(ctx, metadata, false)
+}
+
+// Resume queues final file left pending by previous run, it waits while queue is full
+func (p *pipelineService) Resume(ctx context.Context, metadata *entity.FileMetadata) error {
+	return p.submit(ctx, metadata, true)
+}
+
+// submit records every processor accepting final file as pending, then queues file for workers.
+// processor already done for the file is not run again
+func (p *pipelineService) submit(ctx context.Context, metadata *entity.FileMetadata, wait bool) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	var accepted []entity.Processor
+	for _, processor := range p.processors {
+		if processor.Accepts(metadata) {
+			accepted = append(accepted, processor)
+		}
+	}
+
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	status, err := p.fileService.UpdateProcessingStatus(ctx, metadata.UploadID, func(status *entity.ProcessingStatus) error {
+		processors := make([]entity.ProcessorStatus, 0, len(accepted))
+		for _, processor := range accepted {
+			if previous, ok := FindProcessorStatus(status, processor.Name()); ok && previous.State == entity.ProcessorDone {
+				processors = append(processors, *previous)
+				continue
+			}
+
+			processors = append(processors, entity.ProcessorStatus{
+				Name:    processor.Name(),
+				State:   entity.ProcessorPending,
+				Outputs: []entity.ProcessorOutput{},
+			})
+		}
+
+		status.Processors = processors
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if !status.Pending() {
+		return nil
+	}
+
+	job := processingJob{
+		tenant:      TenantOf(ctx),
+		spanContext: trace.SpanContextFromContext(ctx),
+		metadata:    metadata,
+	}
+
+	if !wait {
+		select {
+		case p.jobs <- job:
+		default:
+			span.SetAttributes(attribute.Bool("pipeline.queue_full", true))
+			logger.Warnf("pipeline queue is full, file %s [%s] stays pending until recovery ⚠️", metadata.Filename, metadata.UploadID)
+			return nil
+		}
+	} else {
+		// full queue holds back caller, file stays pending if caller gives up
+		select {
+		case p.jobs <- job:
+		case <-p.chanQuit:
+		case <-ctx.Done():
+			logger.Error(ctx.Err())
+			return ctx.Err()
+		}
+	}
+
+	span.SetAttributes(
+		attribute.String("upload.id", metadata.UploadID),
+		attribute.Int("pipeline.processors", len(status.Processors)),
+	)
+
+	return nil
+}
+
+// Run runs every pending processor of file in order, state of each is recorded once it finished
+func (p *pipelineService) Run(job processingJob) {
+	// work goes on after upload request finished, its trace is continued
+	ctx := entity.ContextWithTenant(context.Background(), job.tenant)
+	if job.spanContext.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, job.spanContext)
+	}
+
+	ctx, span := gootel.NewSpan(ctx, "pipeline", "")
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	metadata := job.metadata
+	span.SetAttributes(
+		attribute.String("tenant.id", job.tenant.ID),
+		attribute.String("upload.id", metadata.UploadID),
+		attribute.String("file.content_type", metadata.ContentType),
+	)
+
+	status, err := p.fileService.GetProcessingStatus(ctx, metadata.UploadID)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	for _, processorStatus := range status.Processors {
+		if processorStatus.State == entity.ProcessorDone {
+			continue
+		}
+
+		startedAt := time.Now()
+		if _, err = p.fileService.UpdateProcessingStatus(ctx, metadata.UploadID, func(status *entity.ProcessingStatus) error {
+			if current, ok := FindProcessorStatus(status, processorStatus.Name); ok {
+				current.State = entity.ProcessorRunning
+				current.StartedAt = startedAt
+			}
+
+			return nil
+		}); err != nil {
+			logger.Error(err)
+			return
+		}
+
+		outputs, processErr := p.RunProcessor(ctx, processorStatus.Name, metadata)
+		if processErr != nil {
+			logger.Errorf("processor %s failed on file %s [%s] : %s", processorStatus.Name, metadata.Filename, metadata.UploadID, processErr.Error())
+		} else {
+			logger.Infof("processor %s done on file %s [%s], %d outputs ⚙️", processorStatus.Name, metadata.Filename, metadata.UploadID, len(outputs))
+		}
+
+		if _, err = p.fileService.UpdateProcessingStatus(ctx, metadata.UploadID, func(status *entity.ProcessingStatus) error {
+			current, ok := FindProcessorStatus(status, processorStatus.Name)
+			if !ok {
+				return nil
+			}
+
+			current.State = entity.ProcessorDone
+			current.Error = ""
+			current.Outputs = outputs
+			current.FinishedAt = time.Now()
+			if processErr != nil {
+				current.State = entity.ProcessorFailed
+				current.Error = processErr.Error()
+				current.Outputs = []entity.ProcessorOutput{}
+			}
+
+			return nil
+		}); err != nil {
+			logger.Error(err)
+			return
+		}
+
+		span.AddEvent("processor finished", trace.WithAttributes(
+			attribute.String("processor.name", processorStatus.Name),
+			attribute.Bool("processor.failed", processErr != nil),
+			attribute.Int("processor.outputs", len(outputs)),
+			attribute.String("processor.duration", time.Since(startedAt).String()),
+		))
+	}
+}
+
+// RunProcessor runs processor of given name on final file and stores its outputs next to blob of file.
+// panic of processor fails the processor only
+func (p *pipelineService) RunProcessor(ctx context.Context, name string, metadata *entity.FileMetadata) (outputs []entity.ProcessorOutput, err error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	defer func() {
+		if r := recover(); r != nil {
+			outputs, err = nil, fmt.Errorf("processor %s panicked : %v", name, r)
+		}
+	}()
+
+	var processor entity.Processor
+	for _, configured := range p.processors {
+		if configured.Name() == name {
+			processor = configured
+			break
+		}
+	}
+
+	if processor == nil {
+		return nil, fmt.Errorf("processor %s is not configured", name)
+	}
+
+	content, err := p.fileService.storage.OpenBlob(ctx, metadata.CheckSum)
+	if err != nil {
+		return nil, err
+	}
+
+	defer content.Close()
+
+	outputs, err = processor.Process(ctx, metadata, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// file may be deleted while processed, outputs are never written next to removed blob
+	unlock := p.fileService.locks.blob.Lock(metadata.CheckSum)
+	defer unlock()
+
+	if _, err = p.fileService.storage.StatBlob(ctx, metadata.CheckSum); err != nil {
+		return nil, err
+	}
+
+	for i := range outputs {
+		outputs[i].Size = int64(len(outputs[i].Content))
+		if err = p.fileService.storage.PutBlobFile(ctx, metadata.CheckSum, outputPrefix+outputs[i].Name, outputs[i].Content); err != nil {
+			return nil, err
+		}
+	}
+
+	return outputs, nil
+}
+
+// FindProcessorStatus finds state of processor of given name
+func FindProcessorStatus(status *entity.ProcessingStatus, name string) (*entity.ProcessorStatus, bool) {
+	for i := range status.Processors {
+		if status.Processors[i].Name == name {
+			return &status.Processors[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// SubmitProcessing submits final file to pipeline if enabled. failure is only logged, file stays pending
+// and is submitted again by recovery
+func (f *fileService) SubmitProcessing(ctx context.Context, metadata *entity.FileMetadata) {
+	if f.pipeline == nil {
+		return
+	}
+
+	if err := f.pipeline.Submit(ctx, metadata); err != nil {
+		logrus.WithContext(ctx).Errorf("failed submit file %s [%s] to pipeline : %s", metadata.Filename, metadata.UploadID, err.Error())
+	}
+}
+
+// ResumeProcessing submits final file again if any of its processors did not finish before restart.
+// file is never handed to processors unless its scan verdict is clean, like it is never served
+func (f *fileService) ResumeProcessing(ctx context.Context, uploadID string) error {
+	if f.pipeline == nil {
+		return nil
+	}
+
+	status, err := f.GetProcessingStatus(ctx, uploadID)
+	if err != nil || !status.Pending() {
+		return err
+	}
+
+	metadata, err := f.LoadFileMetadata(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	// file completed while scanning was disabled is scanned before processed
+	if metadata.Scan == nil && f.scanner != nil {
+		if metadata, err = f.ScanFile(ctx, metadata); err != nil {
+			return err
+		}
+	}
+
+	if err = CheckScan(metadata); err != nil {
+		logrus.WithContext(ctx).Warnf("skip processing of file %s [%s] : %s ⚠️", metadata.Filename, metadata.UploadID, err.Error())
+		return nil
+	}
+
+	return f.pipeline.Resume(ctx, metadata)
+}
+
+// GetProcessing retrieves state of processors of final file, empty if no processor accepts it
+func (f *fileService) GetProcessing(ctx context.Context, uploadID string) (*entity.ProcessingStatus, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	metadata, err := f.GetFileMetadata(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	status, err := f.GetProcessingStatus(ctx, metadata.UploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// OpenOutput retrieves output of processor of final file by its name
+func (f *fileService) OpenOutput(ctx context.Context, uploadID, name string) (*entity.ProcessorOutput, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	status, err := f.GetProcessing(ctx, uploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	// only outputs recorded in status are served, so name never reaches storage unchecked
+	for _, processorStatus := range status.Processors {
+		for _, output := range processorStatus.Outputs {
+			if output.Name != name || processorStatus.State != entity.ProcessorDone {
+				continue
+			}
+
+			metadata, err := f.LoadFileMetadata(ctx, status.UploadID)
+			if err != nil {
+				logger.Error(err)
+				return nil, err
+			}
+
+			if output.Content, err = f.storage.GetBlobFile(ctx, metadata.CheckSum, outputPrefix+output.Name); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					err = entity.Errorf(entity.ErrCodeNotFound, "output %s of upload [%s] not found: %w", name, uploadID, err)
+				}
+
+				logger.Error(err)
+				return nil, err
+			}
+
+			return &output, nil
+		}
+	}
+
+	err = entity.Errorf(entity.ErrCodeNotFound, "upload [%s] has no output %s", uploadID, name)
+	logger.Error(err)
+	return nil, err
+}
+
+// GetProcessingStatus reads processing status of upload, empty status if file was never submitted
+func (f *fileService) GetProcessingStatus(ctx context.Context, uploadID string) (*entity.ProcessingStatus, error) {
+	content, err := f.storage.GetMetadata(ctx, uploadID, processingFilename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &entity.ProcessingStatus{UploadID: uploadID, Processors: []entity.ProcessorStatus{}}, nil
+		}
+
+		return nil, err
+	}
+
+	var status entity.ProcessingStatus
+	if err = json.Unmarshal(content, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// UpdateProcessingStatus applies update to processing status of upload and persists it
+func (f *fileService) UpdateProcessingStatus(ctx context.Context, uploadID string, update func(status *entity.ProcessingStatus) error) (*entity.ProcessingStatus, error) {
+	unlock := f.locks.processing.Lock(uploadID)
+	defer unlock()
+
+	// status of deleted file is not written, it would leave upload without session behind
+	if _, err := f.storage.GetMetadata(ctx, uploadID, fileMetadataFilename); err != nil {
+		return nil, err
+	}
+
+	status, err := f.GetProcessingStatus(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = update(status); err != nil {
+		return nil, err
+	}
+
+	status.UpdatedAt = time.Now()
+
+	content, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.storage.PutMetadata(ctx, uploadID, processingFilename, content); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
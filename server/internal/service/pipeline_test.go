@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubProcessor writes one output of content it read, or fails
+type stubProcessor struct {
+	name string
+	err  error
+}
+
+func (s *stubProcessor) Name() string {
+	return s.name
+}
+
+func (s *stubProcessor) Accepts(metadata *entity.FileMetadata) bool {
+	return true
+}
+
+func (s *stubProcessor) Process(ctx context.Context, metadata *entity.FileMetadata, content io.ReadSeeker) ([]entity.ProcessorOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	read, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return []entity.ProcessorOutput{{Name: s.name + ".txt", ContentType: "text/plain", Content: read}}, nil
+}
+
+// stubPipeline records files resumed by recovery
+type stubPipeline struct {
+	mu      sync.Mutex
+	resumed []string
+}
+
+func (s *stubPipeline) Submit(ctx context.Context, metadata *entity.FileMetadata) error {
+	return s.Resume(ctx, metadata)
+}
+
+func (s *stubPipeline) Resume(ctx context.Context, metadata *entity.FileMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resumed = append(s.resumed, metadata.UploadID)
+	return nil
+}
+
+func (s *stubPipeline) Start() {}
+
+func (s *stubPipeline) Stop() {}
+
+// putTestFile stores blob of content and file metadata of upload referencing it
+func putTestFile(t *testing.T, service *fileService, uploadID, content string) *entity.FileMetadata {
+	t.Helper()
+
+	ctx := context.Background()
+	checksum := "checksum-" + content
+	if _, err := service.storage.PutBlob(ctx, strings.NewReader(content), int64(len(content)), func() (string, error) {
+		return checksum, nil
+	}); err != nil {
+		t.Fatalf("PutBlob() error = %v", err)
+	}
+
+	if err := service.AddBlobRef(ctx, checksum, uploadID); err != nil {
+		t.Fatalf("AddBlobRef() error = %v", err)
+	}
+
+	metadata := &entity.FileMetadata{UploadID: uploadID, Filename: "a.txt", ContentType: "text/plain", Size: int64(len(content)), CheckSum: checksum, CompletedAt: time.Now()}
+	if err := service.PutFileMetadata(ctx, metadata); err != nil {
+		t.Fatalf("PutFileMetadata() error = %v", err)
+	}
+
+	return metadata
+}
+
+func TestPipelineRunProcessor(t *testing.T) {
+	tests := []struct {
+		name        string
+		processors  []entity.Processor
+		deleteBlob  bool
+		wantErr     bool
+		wantContent string
+	}{
+		{name: "output stored next to blob", processors: []entity.Processor{&stubProcessor{name: "copy"}}, wantContent: "content"},
+		{name: "first of same name runs", processors: []entity.Processor{&stubProcessor{name: "copy"}, &stubProcessor{name: "copy", err: errors.New("second processor")}}, wantContent: "content"},
+		{name: "processor failed", processors: []entity.Processor{&stubProcessor{name: "copy", err: errors.New("failed")}}, wantErr: true},
+		{name: "blob deleted while processed", processors: []entity.Processor{&stubProcessor{name: "copy"}}, deleteBlob: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memoryStorage := storage.NewMemoryStorage()
+			pipeline := NewPipelineService(memoryStorage, tt.processors, 1, 1, NewUploadLocks()).(*pipelineService)
+			metadata := putTestFile(t, pipeline.fileService, "upload-1", "content")
+
+			if tt.deleteBlob {
+				// processor reads blob before it is deleted, then writes outputs
+				pipeline.processors = []entity.Processor{&deletingProcessor{Processor: tt.processors[0], storage: memoryStorage}}
+			}
+
+			outputs, err := pipeline.RunProcessor(ctx, "copy", metadata)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunProcessor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			content, getErr := memoryStorage.GetBlobFile(ctx, metadata.CheckSum, outputPrefix+"copy.txt")
+			if tt.wantErr {
+				if !errors.Is(getErr, os.ErrNotExist) {
+					t.Errorf("output of failed processor = %q, %v, want none", content, getErr)
+				}
+
+				return
+			}
+
+			if len(outputs) != 1 || string(content) != tt.wantContent {
+				t.Errorf("RunProcessor() = %d outputs, stored %q, want %q", len(outputs), content, tt.wantContent)
+			}
+		})
+	}
+}
+
+// deletingProcessor removes blob after processed, like file deleted while its processor runs
+type deletingProcessor struct {
+	entity.Processor
+	storage entity.Storage
+}
+
+func (d *deletingProcessor) Process(ctx context.Context, metadata *entity.FileMetadata, content io.ReadSeeker) ([]entity.ProcessorOutput, error) {
+	outputs, err := d.Processor.Process(ctx, metadata, content)
+	if err == nil {
+		err = d.storage.DeleteBlob(ctx, metadata.CheckSum)
+	}
+
+	return outputs, err
+}
+
+func TestResumeProcessing(t *testing.T) {
+	tests := []struct {
+		name        string
+		scan        *entity.ScanVerdict
+		wantResumed bool
+	}{
+		{name: "not scanned", wantResumed: true},
+		{name: "clean", scan: &entity.ScanVerdict{Status: entity.ScanClean}, wantResumed: true},
+		{name: "infected", scan: &entity.ScanVerdict{Status: entity.ScanInfected, Signature: "Eicar-Test-Signature"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			pipeline := &stubPipeline{}
+			service := NewFileService(nil, storage.NewMemoryStorage(), nil, pipeline, nil, NewUploadLocks(), UploadConfig{}).(*fileService)
+
+			metadata := putTestFile(t, service, "upload-1", "content")
+			metadata.Scan = tt.scan
+			if err := service.PutFileMetadata(ctx, metadata); err != nil {
+				t.Fatalf("PutFileMetadata() error = %v", err)
+			}
+
+			// processing interrupted by restart
+			if _, err := service.UpdateProcessingStatus(ctx, metadata.UploadID, func(status *entity.ProcessingStatus) error {
+				status.Processors = []entity.ProcessorStatus{{Name: "thumbnail", State: entity.ProcessorRunning}}
+				return nil
+			}); err != nil {
+				t.Fatalf("UpdateProcessingStatus() error = %v", err)
+			}
+
+			if err := service.ResumeProcessing(ctx, metadata.UploadID); err != nil {
+				t.Fatalf("ResumeProcessing() error = %v", err)
+			}
+
+			if resumed := len(pipeline.resumed) == 1; resumed != tt.wantResumed {
+				t.Errorf("file resumed = %v, want %v", resumed, tt.wantResumed)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+// scanFilename is blob metadata name of scan verdict, content of blob never changes so it is scanned once.
+// verdict of quarantined blob is kept in place of it, so same content uploaded again is not scanned again
+const scanFilename = "scan.json"
+
+// ScanBlob scans blob for malware, infected blob is quarantined unless uploads already reference it,
+// those uploads are refused on read by its cached verdict and blob is removed with their last reference.
+// caller must hold blob lock of checksum. nil verdict means scanning is disabled, error means no verdict so file must not be served
+func (f *fileService) ScanBlob(ctx context.Context, checksum string) (*entity.ScanVerdict, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if f.scanner == nil {
+		return nil, nil
+	}
+
+	verdict, err := f.GetScanVerdict(ctx, checksum)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	cached := verdict != nil
+	if !cached {
+		if verdict, err = f.ScanContent(ctx, checksum); err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+	}
+
+	AddScanEvent(span, checksum, verdict, cached)
+
+	if !verdict.Clean() {
+		refs, err := f.GetBlobRefs(ctx, checksum)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+
+		if len(refs.Uploads) > 0 {
+			logger.Warnf("blob %s is infected by %s, kept for its %d references ☣️", checksum, verdict.Signature, len(refs.Uploads))
+		} else if _, err = f.storage.StatBlob(ctx, checksum); err == nil {
+			// blob is moved along with its metadata, verdict is recorded again below
+			if err = f.storage.QuarantineBlob(ctx, checksum); err != nil {
+				logger.Error(err)
+				return nil, err
+			}
+
+			cached = false
+			logger.Warnf("blob %s is infected by %s, moved into quarantine ☣️", checksum, verdict.Signature)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err)
+			return nil, err
+		}
+	}
+
+	if cached {
+		return verdict, nil
+	}
+
+	content, err := json.Marshal(verdict)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if err = f.storage.PutBlobMetadata(ctx, checksum, scanFilename, content); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return verdict, nil
+}
+
+// GetScanVerdict retrieves cached verdict of blob, nil if blob is not scanned yet
+func (f *fileService) GetScanVerdict(ctx context.Context, checksum string) (*entity.ScanVerdict, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	content, err := f.storage.GetBlobMetadata(ctx, checksum, scanFilename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		logger.Error(err)
+		return nil, err
+	}
+
+	var verdict entity.ScanVerdict
+	if err = json.Unmarshal(content, &verdict); err != nil {
+		logger.Warnf("ignore invalid scan verdict of blob %s : %s ⚠️", checksum, err.Error())
+		return nil, nil
+	}
+
+	return &verdict, nil
+}
+
+// ScanContent sends content of blob to scanner. scan is bounded by scan timeout, since caller holds locks of upload and blob meanwhile
+func (f *fileService) ScanContent(ctx context.Context, checksum string) (*entity.ScanVerdict, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, f.config.ScanTimeout)
+	defer cancel()
+
+	content, err := f.storage.OpenBlob(ctx, checksum)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	defer content.Close()
+
+	verdict, err := f.scanner.Scan(ctx, content)
+	if err != nil {
+		err = entity.Errorf(entity.ErrCodeUnavailable, "failed scan blob %s by %s : %w", checksum, f.scanner.Name(), err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return verdict, nil
+}
+
+// ScanFile scans final file completed while scanning was disabled, then records its verdict
+func (f *fileService) ScanFile(ctx context.Context, metadata *entity.FileMetadata) (*entity.FileMetadata, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	unlockBlob := f.locks.blob.Lock(metadata.CheckSum)
+	defer unlockBlob()
+
+	verdict, err := f.ScanBlob(ctx, metadata.CheckSum)
+	if err != nil || verdict == nil {
+		return metadata, err
+	}
+
+	metadata.Scan = verdict
+	if err = f.PutFileMetadata(ctx, metadata); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// CheckScan refuses file whose scan found malware
+func CheckScan(metadata *entity.FileMetadata) error {
+	if metadata.Scan == nil || metadata.Scan.Clean() {
+		return nil
+	}
+
+	return entity.Errorf(entity.ErrCodeInfected, "file of upload [%s] is quarantined, infected by %s", metadata.UploadID, metadata.Scan.Signature)
+}
+
+// AddScanEvent records verdict of blob as event of span
+func AddScanEvent(span trace.Span, checksum string, verdict *entity.ScanVerdict, cached bool) {
+	span.AddEvent("scan verdict", trace.WithAttributes(
+		attribute.String("blob.checksum", checksum),
+		attribute.String("scan.scanner", verdict.Scanner),
+		attribute.String("scan.status", verdict.Status),
+		attribute.String("scan.signature", verdict.Signature),
+		attribute.Bool("scan.cached", cached),
+	))
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubScanner returns verdict of given status, or blocks until scan is canceled
+type stubScanner struct {
+	mu     sync.Mutex
+	status string
+	block  bool
+	calls  int
+}
+
+func (s *stubScanner) Name() string {
+	return "stub"
+}
+
+func (s *stubScanner) Scan(ctx context.Context, content io.Reader) (*entity.ScanVerdict, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+
+	if s.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return nil, err
+	}
+
+	verdict := &entity.ScanVerdict{Status: s.status, Scanner: s.Name(), ScannedAt: time.Now()}
+	if s.status == entity.ScanInfected {
+		verdict.Signature = "Eicar-Test-Signature"
+	}
+
+	return verdict, nil
+}
+
+func TestScanBlob(t *testing.T) {
+	tests := []struct {
+		name        string
+		scanner     *stubScanner
+		referenced  bool
+		wantStatus  string
+		wantCode    entity.ErrorCode
+		quarantined bool
+	}{
+		{name: "clean", scanner: &stubScanner{status: entity.ScanClean}, wantStatus: entity.ScanClean},
+		{name: "infected", scanner: &stubScanner{status: entity.ScanInfected}, wantStatus: entity.ScanInfected, quarantined: true},
+		// other uploads read referenced blob, they are refused by cached verdict instead
+		{name: "infected with references", scanner: &stubScanner{status: entity.ScanInfected}, referenced: true, wantStatus: entity.ScanInfected},
+		{name: "scan timeout", scanner: &stubScanner{block: true}, wantCode: entity.ErrCodeUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memoryStorage := storage.NewMemoryStorage()
+			service := NewFileService(validator.New(), memoryStorage, nil, nil, tt.scanner, NewUploadLocks(), UploadConfig{ScanTimeout: 50 * time.Millisecond}).(*fileService)
+
+			if _, err := memoryStorage.PutBlob(ctx, strings.NewReader("content"), 7, func() (string, error) {
+				return "checksum", nil
+			}); err != nil {
+				t.Fatalf("PutBlob() error = %v", err)
+			}
+
+			if tt.referenced {
+				if err := service.AddBlobRef(ctx, "checksum", "upload-1"); err != nil {
+					t.Fatalf("AddBlobRef() error = %v", err)
+				}
+			}
+
+			// second scan of same blob uses cached verdict
+			for i := 0; i < 2; i++ {
+				verdict, err := service.ScanBlob(ctx, "checksum")
+				if tt.wantCode != "" {
+					var appErr *entity.Error
+					if !errors.As(err, &appErr) || appErr.Code != tt.wantCode {
+						t.Fatalf("ScanBlob() error = %v, want code %s", err, tt.wantCode)
+					}
+
+					continue
+				}
+
+				if err != nil || verdict.Status != tt.wantStatus {
+					t.Fatalf("ScanBlob() = %+v, %v, want status %s", verdict, err, tt.wantStatus)
+				}
+			}
+
+			// failed scan has no verdict, so it is scanned again
+			wantCalls := 1
+			if tt.wantCode != "" {
+				wantCalls = 2
+			}
+
+			if tt.scanner.calls != wantCalls {
+				t.Errorf("scanner called %d times, want %d", tt.scanner.calls, wantCalls)
+			}
+
+			_, err := memoryStorage.StatBlob(ctx, "checksum")
+			if quarantined := errors.Is(err, os.ErrNotExist); quarantined != tt.quarantined {
+				t.Errorf("blob quarantined = %v, want %v", quarantined, tt.quarantined)
+			}
+
+			// verdict of quarantined blob stays, so same content uploaded again is refused without scan
+			verdict, err := service.GetScanVerdict(ctx, "checksum")
+			if err != nil || (verdict == nil) != (tt.wantCode != "") || verdict != nil && verdict.Status != tt.wantStatus {
+				t.Errorf("GetScanVerdict() = %+v, %v, want status %q", verdict, err, tt.wantStatus)
+			}
+		})
+	}
+}
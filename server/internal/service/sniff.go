@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"mime"
+	"strings"
+	"time"
+)
+
+// sniffLimit is number of first bytes of upload used to detect its content type
+const sniffLimit = 3072
+
+// SniffContent detects content type of upload from first bytes of its first chunk, before chunk is stored.
+// upload is rejected if detected type is not allowed or it contradicts extension of filename.
+// chunk shorter than need bytes is refused, so content is never hidden behind short first chunk.
+// returned reader still yields whole content
+func (f *fileService) SniffContent(ctx context.Context, session *entity.UploadSession, content io.Reader, need int) (io.Reader, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	header := make([]byte, sniffLimit)
+	n, err := io.ReadFull(content, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if n < need {
+		err = entity.Errorf(entity.ErrCodeValidation, "first chunk of upload [%s] has %d bytes, at least %d bytes are needed to detect content type", session.ID, n, need)
+		logger.Error(err)
+		return nil, err
+	}
+
+	header = header[:n]
+	detected := mimetype.Detect(header)
+
+	if reason := CheckContentType(detected, session.Filename, TenantOf(ctx)); reason != "" {
+		err = f.RejectContent(ctx, session, detected, reason)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if _, err = f.UpdateManifest(ctx, session.ID, func(manifest *entity.UploadManifest) error {
+		manifest.Session.ContentType = detected.String()
+		return nil
+	}); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	logger.Infof("detected content type of upload [%s] is %s", session.ID, detected.String())
+	return io.MultiReader(bytes.NewReader(header), content), nil
+}
+
+// RejectContent rejects upload of detected content type which is not accepted, then publishes its failure.
+// it returns error telling why upload is rejected
+func (f *fileService) RejectContent(ctx context.Context, session *entity.UploadSession, detected *mimetype.MIME, reason string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if err := f.RejectUpload(ctx, session.ID, reason); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	event := NewWebhookEvent(ctx, entity.EventUploadFailed, NewFileMetadata(session, session.TotalSize, session.CheckSum, time.Now()))
+	event.ContentType = detected.String()
+	event.Reason = reason
+	f.Notify(ctx, event)
+
+	return entity.Errorf(entity.ErrCodeUnsupportedMediaType, "upload [%s] rejected : %s", session.ID, reason)
+}
+
+// RejectUpload marks upload as rejected and removes its chunks, so remaining chunks are never stored
+func (f *fileService) RejectUpload(ctx context.Context, uploadID, reason string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if _, err := f.UpdateManifest(ctx, uploadID, func(manifest *entity.UploadManifest) error {
+		manifest.Rejected = reason
+		manifest.Chunks = []entity.ChunkManifest{}
+		return nil
+	}); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if err := f.storage.DeleteChunks(ctx, uploadID); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	// rejected upload is never completed, so it is not open upload of its client anymore
+	f.locks.openUploads.Remove(uploadID)
+	return nil
+}
+
+// headerWriter keeps first sniffLimit bytes written into it, so content type of composed file is detected while it is written
+type headerWriter struct {
+	header []byte
+}
+
+func (h *headerWriter) Write(p []byte) (int, error) {
+	if rest := sniffLimit - len(h.header); rest > 0 {
+		h.header = append(h.header, p[:min(rest, len(p))]...)
+	}
+
+	return len(p), nil
+}
+
+// CheckContentType checks detected type against allow and deny list of tenant, then against extension of filename.
+// it returns reason of rejection, empty if content type is accepted
+func CheckContentType(detected *mimetype.MIME, filename string, tenant *entity.Tenant) string {
+	for _, denied := range strings.Split(tenant.DeniedTypes, ",") {
+		if denied = strings.TrimSpace(denied); denied != "" && IsContentType(detected, denied) {
+			return "content type " + detected.String() + " is denied"
+		}
+	}
+
+	allowed := false
+	allowedTypes := strings.Split(tenant.AllowedTypes, ",")
+	for _, allowedType := range allowedTypes {
+		if allowedType = strings.TrimSpace(allowedType); allowedType == "" || IsContentType(detected, allowedType) {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return "content type " + detected.String() + " is not allowed"
+	}
+
+	// generic type means content is not recognized, so it can't contradict extension
+	declared, _, _ := mime.ParseMediaType(ContentType(filename))
+	if declared == "application/octet-stream" || detected.Is("application/octet-stream") || detected.Is("text/plain") {
+		return ""
+	}
+
+	if !IsContentType(detected, declared) {
+		return "content type " + detected.String() + " doesn't match extension of " + filename
+	}
+
+	return ""
+}
+
+// IsContentType checks detected type or its parent types against filter, e.g. "image/*"
+func IsContentType(detected *mimetype.MIME, filter string) bool {
+	for m := detected; m != nil; m = m.Parent() {
+		if MatchContentType(m.String(), filter) || m.Is(filter) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-upload-chunk/server/internal/entity"
+	"strings"
+	"testing"
+)
+
+func TestUploadChunkContentType(t *testing.T) {
+	type chunk struct {
+		index    int
+		content  string
+		wantCode entity.ErrorCode
+	}
+
+	tests := []struct {
+		name         string
+		chunks       []chunk
+		wantRejected bool
+	}{
+		{name: "text chunks", chunks: []chunk{{index: 0, content: "hello"}, {index: 1, content: "world"}}},
+		{
+			name:   "chunk before first chunk",
+			chunks: []chunk{{index: 1, content: "world", wantCode: entity.ErrCodeConflict}, {index: 0, content: "hello"}, {index: 1, content: "world"}},
+		},
+		{
+			name:         "binary content behind text first chunk",
+			chunks:       []chunk{{index: 0, content: "hello"}, {index: 1, content: "\x00\x01\x02\x03\x04", wantCode: entity.ErrCodeUnsupportedMediaType}},
+			wantRejected: true,
+		},
+		{
+			name:         "denied first chunk",
+			chunks:       []chunk{{index: 0, content: "\x00\x01\x02\x03\x04", wantCode: entity.ErrCodeUnsupportedMediaType}, {index: 1, content: "world", wantCode: entity.ErrCodeUnsupportedMediaType}},
+			wantRejected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := entity.ContextWithTenant(context.Background(), &entity.Tenant{ID: entity.DefaultTenant, AllowedTypes: "text/plain"})
+			service, memoryStorage := newTestFileService(t, UploadConfig{})
+			session := createTestSession(t, service)
+
+			for _, c := range tt.chunks {
+				checksum := sha256.Sum256([]byte(c.content))
+				err := service.UploadChunk(ctx, entity.UploadChunkRequestServiceDTO{
+					RequestHeader: entity.RequestHeaderDTO{UploadID: session.ID, CheckSum: hex.EncodeToString(checksum[:]), ChunkIndex: c.index},
+					Content:       strings.NewReader(c.content),
+				})
+
+				var appErr *entity.Error
+				if c.wantCode == "" && err != nil || c.wantCode != "" && (!errors.As(err, &appErr) || appErr.Code != c.wantCode) {
+					t.Fatalf("UploadChunk() of chunk %d error = %v, want code %q", c.index, err, c.wantCode)
+				}
+			}
+
+			manifest, err := service.GetManifest(ctx, session.ID)
+			if err != nil {
+				t.Fatalf("GetManifest() error = %v", err)
+			}
+
+			if rejected := manifest.Rejected != ""; rejected != tt.wantRejected {
+				t.Errorf("upload rejected = %q, want rejected %v", manifest.Rejected, tt.wantRejected)
+			}
+
+			// rejected upload keeps neither chunks nor final file
+			chunks, err := memoryStorage.ListChunks(ctx, session.ID)
+			if err != nil {
+				t.Fatalf("ListChunks() error = %v", err)
+			}
+
+			if tt.wantRejected && len(chunks) != 0 {
+				t.Errorf("rejected upload keeps %d chunks", len(chunks))
+			}
+
+			if _, err = memoryStorage.GetMetadata(ctx, session.ID, fileMetadataFilename); (err == nil) == tt.wantRejected {
+				t.Errorf("final file recorded = %v, want %v", err == nil, !tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestTusFirstChunkContentType(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     int64
+		content    string
+		wantCode   entity.ErrorCode
+		wantOffset int64
+	}{
+		{name: "whole upload shorter than sniff limit", length: 5, content: "hello", wantOffset: 5},
+		{name: "first chunk of sniff limit", length: sniffLimit + 5, content: strings.Repeat("a", sniffLimit), wantOffset: sniffLimit},
+		{name: "first chunk shorter than sniff limit", length: sniffLimit + 5, content: "hello", wantCode: entity.ErrCodeValidation},
+		{name: "first chunk shorter than upload", length: 10, content: "hello", wantCode: entity.ErrCodeValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestTusService(t)
+			ctx := context.Background()
+			info := createTusUpload(t, service, tt.length, map[string]string{"filename": "a.txt"})
+
+			_, err := service.WriteChunk(ctx, entity.TusPatchRequestDTO{UploadID: info.Session.ID, Content: strings.NewReader(tt.content)})
+
+			var appErr *entity.Error
+			if tt.wantCode == "" && err != nil || tt.wantCode != "" && (!errors.As(err, &appErr) || appErr.Code != tt.wantCode) {
+				t.Fatalf("WriteChunk() error = %v, want code %q", err, tt.wantCode)
+			}
+
+			// refused chunk is never recorded, it is sent again with more bytes
+			if info, err = service.GetUpload(ctx, info.Session.ID); err != nil || info.Offset != tt.wantOffset {
+				t.Errorf("GetUpload() offset = %d, %v, want %d", info.Offset, err, tt.wantOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tenantIDPattern is format of tenant ID, it names folder of tenant
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// tenantConfig is tenant in tenants file, missing field inherits global config
+type tenantConfig struct {
+	ID           string  `json:"id"`
+	MaxChunkSize *int64  `json:"max_chunk_size"`
+	MaxFileSize  *int64  `json:"max_file_size"`
+	AllowedTypes *string `json:"allowed_types"`
+	DeniedTypes  *string `json:"denied_types"`
+	UploadTTL    *string `json:"upload_ttl"`
+	Retention    *string `json:"retention"`
+}
+
+type tenantService struct {
+	tenants map[string]*entity.Tenant
+}
+
+// NewTenantService creates new instance of tenantService. it implements from interface TenantService.
+// tenantsFile is optional json file of tenants {"tenants": [{"id": "team-a", "allowed_types": "image/*"}]},
+// default tenant always exists and may be configured there too
+func NewTenantService(tenantsFile string) (entity.TenantService, error) {
+	tenants := tenantService{
+		tenants: map[string]*entity.Tenant{entity.DefaultTenant: DefaultTenant()},
+	}
+
+	if tenantsFile == "" {
+		return &tenants, nil
+	}
+
+	content, err := os.ReadFile(tenantsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Tenants []tenantConfig `json:"tenants"`
+	}
+
+	if err = json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s : %w", tenantsFile, err)
+	}
+
+	for _, tenantConfig := range file.Tenants {
+		tenant, err := tenantConfig.Tenant()
+		if err != nil {
+			return nil, err
+		}
+
+		tenants.tenants[tenant.ID] = tenant
+	}
+
+	logrus.Infof("success load %d tenants from %s 🏢", len(file.Tenants), tenantsFile)
+	return &tenants, nil
+}
+
+// defaultTenant is default tenant of global config, read once at first use
+var defaultTenant = sync.OnceValue(func() entity.Tenant {
+	return entity.Tenant{
+		ID:           entity.DefaultTenant,
+		MaxChunkSize: config.MaxChunkSize(),
+		AllowedTypes: config.UploadAllowedTypes(),
+		DeniedTypes:  config.UploadDeniedTypes(),
+		UploadTTL:    config.JanitorTTL(),
+	}
+})
+
+// DefaultTenant returns copy of default tenant of global config
+func DefaultTenant() *entity.Tenant {
+	tenant := defaultTenant()
+	return &tenant
+}
+
+// TenantOf retrieves tenant of request, work without tenant belongs to default tenant
+func TenantOf(ctx context.Context) *entity.Tenant {
+	if tenant, ok := entity.TenantFromContext(ctx); ok {
+		return tenant
+	}
+
+	return DefaultTenant()
+}
+
+// Tenant applies config of tenant over global config
+func (t tenantConfig) Tenant() (*entity.Tenant, error) {
+	if !tenantIDPattern.MatchString(t.ID) {
+		return nil, fmt.Errorf("invalid tenant id [%s], expected %s", t.ID, tenantIDPattern.String())
+	}
+
+	tenant := DefaultTenant()
+	tenant.ID = t.ID
+
+	if t.MaxChunkSize != nil {
+		if *t.MaxChunkSize <= 0 {
+			return nil, fmt.Errorf("max chunk size of tenant [%s] must be positive", t.ID)
+		}
+
+		tenant.MaxChunkSize = *t.MaxChunkSize
+	}
+
+	if t.MaxFileSize != nil {
+		tenant.MaxFileSize = *t.MaxFileSize
+	}
+
+	if t.AllowedTypes != nil {
+		tenant.AllowedTypes = *t.AllowedTypes
+	}
+
+	if t.DeniedTypes != nil {
+		tenant.DeniedTypes = *t.DeniedTypes
+	}
+
+	var err error
+	if t.UploadTTL != nil {
+		if tenant.UploadTTL, err = time.ParseDuration(*t.UploadTTL); err != nil {
+			return nil, fmt.Errorf("invalid upload ttl of tenant [%s] : %w", t.ID, err)
+		}
+	}
+
+	if t.Retention != nil {
+		if tenant.Retention, err = time.ParseDuration(*t.Retention); err != nil {
+			return nil, fmt.Errorf("invalid retention of tenant [%s] : %w", t.ID, err)
+		}
+	}
+
+	return tenant, nil
+}
+
+// Resolve finds tenant of request. caller bound to tenant always gets it, caller granted admin scope picks tenant by X-Tenant-ID header.
+// other callers, and every caller if authentication is disabled, belong to default tenant
+func (t *tenantService) Resolve(ctx context.Context, request entity.TenantRequestDTO) (*entity.Tenant, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	tenantID := request.TenantID
+	identity, authenticated := entity.IdentityFromContext(ctx)
+	switch {
+	case authenticated && identity.Tenant != "":
+		if tenantID != "" && tenantID != identity.Tenant {
+			err := entity.Errorf(entity.ErrCodeForbidden, "%s is bound to tenant [%s], not [%s]", identity.Subject, identity.Tenant, tenantID)
+			logger.Error(err)
+			return nil, err
+		}
+
+		tenantID = identity.Tenant
+	case tenantID != "" && tenantID != entity.DefaultTenant && !(authenticated && identity.HasScope(entity.ScopeAdmin)):
+		err := entity.Errorf(entity.ErrCodeForbidden, "tenant [%s] is only picked by caller bound to it or granted scope %s", tenantID, entity.ScopeAdmin)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if tenantID == "" {
+		tenantID = entity.DefaultTenant
+	}
+
+	tenant, ok := t.tenants[tenantID]
+	if !ok {
+		err := entity.Errorf(entity.ErrCodeForbidden, "unknown tenant [%s]", tenantID)
+		logger.Error(err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("tenant.id", tenant.ID))
+	return tenant, nil
+}
+
+// ListTenants lists every tenant ordered by ID, default tenant included
+func (t *tenantService) ListTenants() []*entity.Tenant {
+	tenants := make([]*entity.Tenant, 0, len(t.tenants))
+	for _, tenant := range t.tenants {
+		tenants = append(tenants, tenant)
+	}
+
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+
+	return tenants
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/internal/entity"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestTenantService(t *testing.T) entity.TenantService {
+	t.Helper()
+
+	tenantsFile := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(tenantsFile, []byte(`{"tenants": [{"id": "team-a"}, {"id": "team-b"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tenantService, err := NewTenantService(tenantsFile)
+	if err != nil {
+		t.Fatalf("NewTenantService() error = %v", err)
+	}
+
+	return tenantService
+}
+
+func TestTenantResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		identity   *entity.Identity
+		header     string
+		wantTenant string
+		wantCode   entity.ErrorCode
+	}{
+		{name: "authentication disabled", wantTenant: entity.DefaultTenant},
+		{name: "authentication disabled picks tenant", header: "team-a", wantCode: entity.ErrCodeForbidden},
+		{name: "authentication disabled picks default tenant", header: entity.DefaultTenant, wantTenant: entity.DefaultTenant},
+		{name: "bound caller", identity: &entity.Identity{Subject: "a", Scopes: []string{entity.ScopeUpload}, Tenant: "team-a"}, wantTenant: "team-a"},
+		{name: "bound caller sends own tenant", identity: &entity.Identity{Subject: "a", Scopes: []string{entity.ScopeUpload}, Tenant: "team-a"}, header: "team-a", wantTenant: "team-a"},
+		{name: "bound caller picks other tenant", identity: &entity.Identity{Subject: "a", Scopes: []string{entity.ScopeAdmin}, Tenant: "team-a"}, header: "team-b", wantCode: entity.ErrCodeForbidden},
+		{name: "unbound caller", identity: &entity.Identity{Subject: "c", Scopes: []string{entity.ScopeUpload}}, wantTenant: entity.DefaultTenant},
+		{name: "unbound caller picks tenant", identity: &entity.Identity{Subject: "c", Scopes: []string{entity.ScopeUpload, entity.ScopeRead}}, header: "team-b", wantCode: entity.ErrCodeForbidden},
+		{name: "unbound admin picks tenant", identity: &entity.Identity{Subject: "admin", Scopes: []string{entity.ScopeAdmin}}, header: "team-b", wantTenant: "team-b"},
+		{name: "unbound admin picks unknown tenant", identity: &entity.Identity{Subject: "admin", Scopes: []string{entity.ScopeAdmin}}, header: "team-c", wantCode: entity.ErrCodeForbidden},
+	}
+
+	tenantService := newTestTenantService(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.identity != nil {
+				ctx = entity.ContextWithIdentity(ctx, tt.identity)
+			}
+
+			tenant, err := tenantService.Resolve(ctx, entity.TenantRequestDTO{TenantID: tt.header})
+			if tt.wantCode != "" {
+				var appErr *entity.Error
+				if !errors.As(err, &appErr) || appErr.Code != tt.wantCode {
+					t.Fatalf("Resolve() error = %v, want code %s", err, tt.wantCode)
+				}
+
+				return
+			}
+
+			if err != nil || tenant.ID != tt.wantTenant {
+				t.Errorf("Resolve() = %+v, %v, want tenant %s", tenant, err, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	tenantStorage, err := storage.NewTenantStorage(func(tenantID string) (entity.Storage, error) {
+		return storage.NewMemoryStorage(), nil
+	})
+	if err != nil {
+		t.Fatalf("NewTenantStorage() error = %v", err)
+	}
+
+	validate := validator.New()
+	if err = RegisterFilenameValidation(validate); err != nil {
+		t.Fatalf("RegisterFilenameValidation() error = %v", err)
+	}
+
+	var (
+		service     = NewFileService(validate, tenantStorage, nil, nil, nil, NewUploadLocks(), UploadConfig{}).(*fileService)
+		ctxA        = entity.ContextWithTenant(context.Background(), &entity.Tenant{ID: "team-a"})
+		ctxB        = entity.ContextWithTenant(context.Background(), &entity.Tenant{ID: "team-b"})
+		checksum    = sha256.Sum256([]byte("hello"))
+		listRequest = entity.ListFileRequestDTO{Page: 1, PageSize: 20, SortBy: "time", Order: "desc"}
+	)
+
+	// tenant A completes one upload and keeps another open
+	completed, err := service.CreateSession(ctxA, entity.CreateSessionRequestDTO{Filename: "a.txt", TotalSize: 5, TotalChunk: 1, Uploader: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	if err = service.UploadChunk(ctxA, entity.UploadChunkRequestServiceDTO{
+		RequestHeader: entity.RequestHeaderDTO{UploadID: completed.ID, CheckSum: hex.EncodeToString(checksum[:]), ChunkIndex: 0},
+		Content:       strings.NewReader("hello"),
+	}); err != nil {
+		t.Fatalf("UploadChunk() error = %v", err)
+	}
+
+	open, err := service.CreateSession(ctxA, entity.CreateSessionRequestDTO{Filename: "b.txt", TotalSize: 5, TotalChunk: 1, Uploader: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	// tenant B knows upload IDs of tenant A, but never reaches them
+	if _, _, err = service.OpenFile(ctxB, completed.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenFile() of other tenant error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	if _, err = service.GetStatus(ctxB, open.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetStatus() of other tenant error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	if err = service.DeleteFile(ctxB, completed.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("DeleteFile() of other tenant error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	if err = service.AbortUpload(ctxB, open.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("AbortUpload() of other tenant error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	if files, err := service.ListFiles(ctxB, listRequest); err != nil || files.Total != 0 {
+		t.Errorf("ListFiles() of other tenant = %+v, %v, want none", files, err)
+	}
+
+	// uploads of tenant A are untouched
+	metadata, file, err := service.OpenFile(ctxA, completed.ID)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+
+	_ = file.Close()
+
+	if metadata.UploadID != completed.ID {
+		t.Errorf("OpenFile() metadata = %+v, want upload %s", metadata, completed.ID)
+	}
+
+	if _, err = service.GetStatus(ctxA, open.ID); err != nil {
+		t.Errorf("GetStatus() error = %v", err)
+	}
+
+	if files, err := service.ListFiles(ctxA, listRequest); err != nil || files.Total != 1 {
+		t.Errorf("ListFiles() = %+v, %v, want 1 file", files, err)
+	}
+}
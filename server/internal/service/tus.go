@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/utils"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// tusDefaultFilename names upload whose metadata has no filename
+const tusDefaultFilename = "upload"
+
+// tusService stores body of each PATCH request as next chunk of upload session, until upload length reached
+type tusService struct {
+	validate    *validator.Validate
+	storage     entity.Storage
+	fileService *fileService
+}
+
+// NewTusService creates new instance of tusService. it implements from interface TusService
+func NewTusService(validate *validator.Validate, storage entity.Storage, webhook entity.WebhookService, pipeline entity.PipelineService, scanner entity.Scanner, locks *UploadLocks, config UploadConfig) entity.TusService {
+	return &tusService{
+		validate: validate,
+		storage:  storage,
+		fileService: &fileService{
+			validate: validate,
+			storage:  storage,
+			webhook:  webhook,
+			pipeline: pipeline,
+			scanner:  scanner,
+			locks:    locks,
+			config:   config,
+		},
+	}
+}
+
+// CreateUpload creates new upload session for tus client
+func (t *tusService) CreateUpload(ctx context.Context, request entity.TusCreateRequestDTO) (*entity.TusUploadInfo, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// filename is optional in tus metadata, and upload may be empty
+	request.Filename = NormalizeFilename(request.Filename)
+	if request.Filename == "" {
+		request.Filename = tusDefaultFilename
+	}
+
+	// validate request, rejected filename is explained by rule it violated
+	if err := t.validate.Struct(request); err != nil {
+		err = FilenameError(err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	// total chunk is known once upload completed, empty upload has none
+	totalChunk := 1
+	if request.UploadLength == 0 {
+		totalChunk = 0
+	}
+
+	session, err := t.fileService.OpenSession(ctx, entity.CreateSessionRequestDTO{
+		Filename:   request.Filename,
+		TotalSize:  request.UploadLength,
+		TotalChunk: totalChunk,
+		CheckSum:   request.CheckSum,
+		Uploader:   request.Uploader,
+	})
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	// empty upload is complete once created, no PATCH request follows
+	if totalChunk == 0 && !session.Completed {
+		if err = t.fileService.CreateFinalFile(ctx, session); err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+	}
+
+	info := entity.TusUploadInfo{
+		Session:   session,
+		Offset:    0,
+		ExpiresAt: session.CreatedAt.Add(t.fileService.config.TusExpiration),
+	}
+
+	// file of declared checksum already stored, upload is complete
+	if session.Completed {
+		info.Offset = session.TotalSize
+	}
+
+	logger.Infof("success create tus upload [%s] 🎫", session.ID)
+	return &info, nil
+}
+
+// GetUpload retrieves tus upload and its current offset
+func (t *tusService) GetUpload(ctx context.Context, uploadID string) (*entity.TusUploadInfo, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	manifest, err := t.fileService.GetManifest(ctx, uploadID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = entity.ErrTusNotFound
+		}
+
+		logger.Error(err)
+		return nil, err
+	}
+
+	session := &manifest.Session
+	info := entity.TusUploadInfo{
+		Session:   session,
+		ExpiresAt: session.CreatedAt.Add(t.fileService.config.TusExpiration),
+	}
+
+	// file metadata exists, upload already completed
+	if _, err = t.storage.GetMetadata(ctx, session.ID, fileMetadataFilename); err == nil {
+		info.Offset = session.TotalSize
+		return &info, nil
+	}
+
+	// unfinished upload is removed after expired
+	if time.Now().After(info.ExpiresAt) {
+		if err = t.Terminate(ctx, uploadID); err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+
+		logger.Error(entity.ErrTusExpired)
+		return nil, entity.ErrTusExpired
+	}
+
+	// offset is total size of chunks recorded
+	info.Offset = manifest.BytesReceived()
+	info.Chunks = len(manifest.Chunks)
+	return &info, nil
+}
+
+// WriteChunk appends request content to tus upload at given offset
+func (t *tusService) WriteChunk(ctx context.Context, request entity.TusPatchRequestDTO) (*entity.TusUploadInfo, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// validate request
+	if err := t.validate.Struct(request); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	// PATCH requests of one upload are serialized, so offset check and write are atomic
+	unlock := t.fileService.locks.tusWrite.Lock(request.UploadID)
+	defer unlock()
+
+	info, err := t.GetUpload(ctx, request.UploadID)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if request.UploadOffset != info.Offset {
+		err = fmt.Errorf("%w : expected %d, got %d", entity.ErrTusOffsetMismatch, info.Offset, request.UploadOffset)
+		logger.Error(err)
+		return nil, err
+	}
+
+	// upload length already reached, nothing is left to write
+	if info.Offset == info.Session.TotalSize {
+		return info, nil
+	}
+
+	// never write more than declared upload length
+	content := io.LimitReader(request.Content, info.Session.TotalSize-info.Offset)
+
+	// first chunk tells content type of upload, it must carry enough bytes to detect it unless it is whole upload
+	if info.Chunks == 0 {
+		if content, err = t.fileService.SniffContent(ctx, info.Session, content, int(min(sniffLimit, info.Session.TotalSize))); err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+	}
+
+	// checksum is optional, "sha1 base64(hash)". chunk is discarded by storage if mismatch
+	if request.UploadChecksum != "" {
+		hashChecksum, expected, err := t.ParseChecksum(request.UploadChecksum)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+
+		content = utils.NewChecksumReader(content, hashChecksum, expected)
+	}
+
+	// manifest records sha256 of each chunk, whatever checksum algorithm used by client
+	hashChunk := sha256.New()
+	content = io.TeeReader(content, hashChunk)
+
+	written, err := t.storage.PutChunk(ctx, info.Session.ID, info.Chunks, content)
+	if err != nil {
+		if errors.Is(err, utils.ErrChecksumMismatch) {
+			err = entity.ErrTusChecksumMismatch
+		}
+
+		logger.Error(err)
+		return nil, err
+	}
+
+	// record chunk into manifest, total chunk is known once upload length reached
+	manifest, err := t.fileService.UpdateManifest(ctx, info.Session.ID, func(manifest *entity.UploadManifest) error {
+		if manifest.Rejected != "" {
+			return entity.Errorf(entity.ErrCodeUnsupportedMediaType, "upload [%s] rejected : %s", manifest.Session.ID, manifest.Rejected)
+		}
+
+		now := time.Now()
+		if manifest.Session.StartedAt.IsZero() {
+			manifest.Session.StartedAt = now
+		}
+
+		manifest.Chunks = append(manifest.Chunks, entity.ChunkManifest{
+			Index:      info.Chunks,
+			Size:       written,
+			CheckSum:   hex.EncodeToString(hashChunk.Sum(nil)),
+			ReceivedAt: now,
+		})
+
+		if manifest.BytesReceived() == manifest.Session.TotalSize {
+			manifest.Session.TotalChunk = len(manifest.Chunks)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.fileService.DiscardUnrecorded(ctx, info.Session.ID, err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	info.Session = &manifest.Session
+	info.Offset = manifest.BytesReceived()
+	info.Chunks = len(manifest.Chunks)
+
+	// upload length reached, create final file from all chunks
+	if manifest.Complete() {
+		if err = t.fileService.CreateFinalFile(ctx, info.Session); err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+	}
+
+	logger.Infof("success write %d bytes to tus upload [%s]", written, info.Session.ID)
+	return info, nil
+}
+
+// Terminate aborts tus upload which is not completed yet, completed upload is deleted by delete file endpoint only
+func (t *tusService) Terminate(ctx context.Context, uploadID string) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	if err := t.fileService.AbortUpload(ctx, uploadID); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = entity.ErrTusNotFound
+		}
+
+		logger.Error(err)
+		return err
+	}
+
+	logger.Infof("success terminate tus upload [%s] 🗑️", uploadID)
+	return nil
+}
+
+// ParseChecksum parses Upload-Checksum header into hash function and expected sum. algorithm is checked first,
+// so unsupported one is reported as such whatever its value
+func (t *tusService) ParseChecksum(uploadChecksum string) (hash.Hash, []byte, error) {
+	algorithm, value, _ := strings.Cut(uploadChecksum, " ")
+
+	var hashChecksum hash.Hash
+	switch algorithm {
+	case "md5":
+		hashChecksum = md5.New()
+	case "sha1":
+		hashChecksum = sha1.New()
+	case "sha256":
+		hashChecksum = sha256.New()
+	default:
+		return nil, nil, fmt.Errorf("%w : %s", entity.ErrTusChecksumAlgorithm, algorithm)
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(expected) != hashChecksum.Size() {
+		return nil, nil, entity.Errorf(entity.ErrCodeValidation, "invalid Upload-Checksum, expected %s of base64 encoded %d bytes", algorithm, hashChecksum.Size())
+	}
+
+	return hashChecksum, expected, nil
+}
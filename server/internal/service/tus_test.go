@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTusService(t *testing.T) (*tusService, entity.Storage) {
+	t.Helper()
+
+	validate := validator.New()
+	if err := RegisterFilenameValidation(validate); err != nil {
+		t.Fatalf("RegisterFilenameValidation() error = %v", err)
+	}
+
+	memoryStorage := storage.NewMemoryStorage()
+	return NewTusService(validate, memoryStorage, nil, nil, nil, NewUploadLocks(), UploadConfig{TusExpiration: time.Hour}).(*tusService), memoryStorage
+}
+
+func createTusUpload(t *testing.T, service *tusService, length int64, metadata map[string]string) *entity.TusUploadInfo {
+	t.Helper()
+
+	info, err := service.CreateUpload(context.Background(), entity.TusCreateRequestDTO{
+		UploadLength: length,
+		Filename:     metadata["filename"],
+		CheckSum:     metadata["checksum"],
+		Metadata:     metadata,
+		Uploader:     t.Name(),
+	})
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+
+	return info
+}
+
+func tusChecksum(algorithm, content string) string {
+	var sum []byte
+	switch algorithm {
+	case "sha1":
+		s := sha1.Sum([]byte(content))
+		sum = s[:]
+	default:
+		s := sha256.Sum256([]byte(content))
+		sum = s[:]
+	}
+
+	return algorithm + " " + base64.StdEncoding.EncodeToString(sum)
+}
+
+func TestTusWriteChunkOffset(t *testing.T) {
+	service, _ := newTestTusService(t)
+	ctx := context.Background()
+	// first chunk carries enough bytes to detect content type
+	head := strings.Repeat("hello ", sniffLimit/6)
+	info := createTusUpload(t, service, sniffLimit+5, map[string]string{"filename": "hello.txt"})
+
+	// PATCH requests of one upload in order, each is checked against offset recorded by server
+	patches := []struct {
+		name       string
+		offset     int64
+		content    string
+		wantErr    error
+		wantOffset int64
+	}{
+		{name: "first chunk", offset: 0, content: head, wantOffset: sniffLimit},
+		{name: "chunk sent again", offset: 0, content: head, wantErr: entity.ErrTusOffsetMismatch},
+		{name: "offset ahead", offset: sniffLimit + 2, content: "rld", wantErr: entity.ErrTusOffsetMismatch},
+		{name: "more than upload length", offset: sniffLimit, content: "world and more", wantOffset: sniffLimit + 5},
+		{name: "upload length reached", offset: sniffLimit + 5, content: "more", wantOffset: sniffLimit + 5},
+		{name: "offset after upload length", offset: sniffLimit + 6, content: "more", wantErr: entity.ErrTusOffsetMismatch},
+	}
+
+	for _, patch := range patches {
+		t.Run(patch.name, func(t *testing.T) {
+			got, err := service.WriteChunk(ctx, entity.TusPatchRequestDTO{
+				UploadID:     info.Session.ID,
+				UploadOffset: patch.offset,
+				Content:      strings.NewReader(patch.content),
+			})
+			if !errors.Is(err, patch.wantErr) {
+				t.Fatalf("WriteChunk() error = %v, want %v", err, patch.wantErr)
+			}
+
+			if err == nil && got.Offset != patch.wantOffset {
+				t.Errorf("WriteChunk() offset = %d, want %d", got.Offset, patch.wantOffset)
+			}
+		})
+	}
+
+	metadata, file, err := service.fileService.OpenFile(ctx, info.Session.ID)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+
+	defer file.Close()
+
+	if content, _ := io.ReadAll(file); string(content) != head+"world" || metadata.TotalChunk != 2 {
+		t.Errorf("final file = %q of %d chunks, want %q of 2 chunks", content, metadata.TotalChunk, head+"world")
+	}
+}
+
+func TestTusWriteChunkChecksum(t *testing.T) {
+	tests := []struct {
+		name       string
+		checksum   string
+		wantErr    error
+		wantCode   entity.ErrorCode
+		wantOffset int64
+	}{
+		{name: "without checksum", wantOffset: 5},
+		{name: "sha1", checksum: tusChecksum("sha1", "hello"), wantOffset: 5},
+		{name: "sha256", checksum: tusChecksum("sha256", "hello"), wantOffset: 5},
+		{name: "mismatch", checksum: tusChecksum("sha1", "other"), wantErr: entity.ErrTusChecksumMismatch},
+		{name: "unsupported algorithm", checksum: "crc32 AAAAAA==", wantErr: entity.ErrTusChecksumAlgorithm},
+		{name: "unsupported algorithm of malformed value", checksum: "crc32 not base64!", wantErr: entity.ErrTusChecksumAlgorithm},
+		{name: "malformed base64", checksum: "sha1 not base64!", wantCode: entity.ErrCodeValidation},
+		{name: "sum of other length", checksum: "sha256 " + base64.StdEncoding.EncodeToString([]byte("short")), wantCode: entity.ErrCodeValidation},
+		{name: "missing value", checksum: "sha1", wantCode: entity.ErrCodeValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestTusService(t)
+			ctx := context.Background()
+			info := createTusUpload(t, service, 5, map[string]string{"filename": "hello.txt"})
+
+			got, err := service.WriteChunk(ctx, entity.TusPatchRequestDTO{
+				UploadID:       info.Session.ID,
+				UploadOffset:   0,
+				UploadChecksum: tt.checksum,
+				Content:        strings.NewReader("hello"),
+			})
+
+			var appErr *entity.Error
+			switch {
+			case tt.wantCode != "":
+				if !errors.As(err, &appErr) || appErr.Code != tt.wantCode {
+					t.Fatalf("WriteChunk() error = %v, want code %s", err, tt.wantCode)
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("WriteChunk() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && got.Offset != tt.wantOffset {
+				t.Errorf("WriteChunk() offset = %d, want %d", got.Offset, tt.wantOffset)
+			}
+
+			// rejected chunk is never recorded, same chunk is sent again at same offset
+			if info, err = service.GetUpload(ctx, info.Session.ID); err != nil || info.Offset != tt.wantOffset {
+				t.Errorf("GetUpload() offset = %d, %v, want %d", info.Offset, err, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestTusCreateUpload(t *testing.T) {
+	emptySum := sha256.Sum256(nil)
+
+	tests := []struct {
+		name         string
+		length       int64
+		metadata     map[string]string
+		wantErr      bool
+		wantFilename string
+		wantComplete bool
+	}{
+		{name: "with filename", length: 5, metadata: map[string]string{"filename": "a.txt"}, wantFilename: "a.txt"},
+		{name: "without filename", length: 5, metadata: map[string]string{}, wantFilename: tusDefaultFilename},
+		{name: "empty upload", length: 0, metadata: map[string]string{"filename": "empty.txt"}, wantFilename: "empty.txt", wantComplete: true},
+		{name: "empty upload of declared checksum", length: 0, metadata: map[string]string{"checksum": hex.EncodeToString(emptySum[:])}, wantFilename: tusDefaultFilename, wantComplete: true},
+		{name: "deferred length", length: -1, metadata: map[string]string{"filename": "a.txt"}, wantErr: true},
+		{name: "path as filename", length: 5, metadata: map[string]string{"filename": "../a.txt"}, wantErr: true},
+		{name: "invalid checksum", length: 5, metadata: map[string]string{"filename": "a.txt", "checksum": "abc"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestTusService(t)
+			ctx := context.Background()
+
+			info, err := service.CreateUpload(ctx, entity.TusCreateRequestDTO{
+				UploadLength: tt.length,
+				Filename:     tt.metadata["filename"],
+				CheckSum:     tt.metadata["checksum"],
+				Metadata:     tt.metadata,
+				Uploader:     t.Name(),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateUpload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if info.Session.Filename != tt.wantFilename {
+				t.Errorf("CreateUpload() filename = %q, want %q", info.Session.Filename, tt.wantFilename)
+			}
+
+			// empty upload is complete without any PATCH request
+			_, err = service.fileService.GetFileMetadata(ctx, info.Session.ID)
+			if complete := err == nil; complete != tt.wantComplete {
+				t.Errorf("upload complete = %v, want %v", complete, tt.wantComplete)
+			}
+		})
+	}
+}
+
+func TestTusTerminate(t *testing.T) {
+	tests := []struct {
+		name     string
+		complete bool
+		unknown  bool
+		wantErr  error
+		wantCode entity.ErrorCode
+	}{
+		{name: "unfinished upload"},
+		{name: "completed upload", complete: true, wantCode: entity.ErrCodeConflict},
+		{name: "unknown upload", unknown: true, wantErr: entity.ErrTusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestTusService(t)
+			ctx := context.Background()
+			info := createTusUpload(t, service, sniffLimit+5, map[string]string{"filename": "a.txt"})
+
+			content := strings.Repeat("a", sniffLimit)
+			if tt.complete {
+				content = strings.Repeat("a", sniffLimit+5)
+			}
+
+			if _, err := service.WriteChunk(ctx, entity.TusPatchRequestDTO{UploadID: info.Session.ID, Content: strings.NewReader(content)}); err != nil {
+				t.Fatalf("WriteChunk() error = %v", err)
+			}
+
+			uploadID := info.Session.ID
+			if tt.unknown {
+				uploadID = "unknown"
+			}
+
+			err := service.Terminate(ctx, uploadID)
+
+			var appErr *entity.Error
+			switch {
+			case tt.wantCode != "":
+				if !errors.As(err, &appErr) || appErr.Code != tt.wantCode {
+					t.Fatalf("Terminate() error = %v, want code %s", err, tt.wantCode)
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("Terminate() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// terminated upload is gone, completed one is kept
+			_, err = service.GetUpload(ctx, info.Session.ID)
+			if gone, wantGone := errors.Is(err, entity.ErrTusNotFound), !tt.complete && !tt.unknown; gone != wantGone {
+				t.Errorf("GetUpload() after Terminate() error = %v, want upload gone %v", err, wantGone)
+			}
+		})
+	}
+}
+
+func TestTusExpiration(t *testing.T) {
+	service, _ := newTestTusService(t)
+	service.fileService.config.TusExpiration = time.Nanosecond
+
+	ctx := context.Background()
+	info := createTusUpload(t, service, 5, map[string]string{"filename": "a.txt"})
+
+	if _, err := service.WriteChunk(ctx, entity.TusPatchRequestDTO{UploadID: info.Session.ID, Content: strings.NewReader("he")}); !errors.Is(err, entity.ErrTusExpired) {
+		t.Fatalf("WriteChunk() of expired upload error = %v, want %v", err, entity.ErrTusExpired)
+	}
+
+	// expired upload is removed
+	if _, err := service.GetUpload(ctx, info.Session.ID); !errors.Is(err, entity.ErrTusNotFound) {
+		t.Errorf("GetUpload() of expired upload error = %v, want %v", err, entity.ErrTusNotFound)
+	}
+}
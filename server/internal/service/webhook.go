@@ -0,0 +1,472 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gootel "github.com/erajayatech/go-opentelemetry/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/internal/entity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	deadLetterFilename  = "dead-letter.jsonl"
+	webhookPollInterval = time.Second
+	webhookMaxBackoff   = time.Hour
+)
+
+// WebhookConfig is config of webhook deliveries, every event is sent to each URL
+type WebhookConfig struct {
+	URLs        []string
+	Secret      string
+	QueueFolder string
+	MaxAttempts int
+	Timeout     time.Duration
+}
+
+type webhookService struct {
+	config   WebhookConfig
+	client   *http.Client
+	mu       sync.Mutex
+	chanWake chan struct{}
+	chanQuit chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewWebhookService creates new instance of webhookService. it implements from interface WebhookService.
+// pending deliveries are files in queue folder, so they survive restart and are sent by next run.
+// URLs are trimmed and empty ones dropped, invalid URL fails here rather than being retried forever
+func NewWebhookService(config WebhookConfig) (entity.WebhookService, error) {
+	urls := []string{}
+	for _, rawURL := range config.URLs {
+		if rawURL = strings.TrimSpace(rawURL); rawURL == "" {
+			continue
+		}
+
+		parsed, err := url.ParseRequestURI(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook url [%s] : %w", rawURL, err)
+		}
+
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid webhook url [%s], expected http or https url", rawURL)
+		}
+
+		urls = append(urls, rawURL)
+	}
+
+	if len(urls) == 0 {
+		return nil, errors.New("webhook needs at least one url")
+	}
+
+	config.URLs = urls
+
+	if config.Secret == "" {
+		return nil, errors.New("webhook needs secret to sign requests")
+	}
+
+	if err := os.MkdirAll(config.QueueFolder, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &webhookService{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		chanWake: make(chan struct{}, 1),
+		chanQuit: make(chan struct{}),
+	}, nil
+}
+
+// WebhookSignature signs body of webhook request sent at timestamp, receiver compares it with X-Webhook-Signature
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookEvent creates event of file, trace context of ctx is attached so receiver can continue the trace
+func NewWebhookEvent(ctx context.Context, eventType string, metadata *entity.FileMetadata) *entity.WebhookEvent {
+	event := &entity.WebhookEvent{
+		ID:          uuid.NewString(),
+		Type:        eventType,
+		OccurredAt:  time.Now().UTC(),
+		Tenant:      TenantOf(ctx).ID,
+		UploadID:    metadata.UploadID,
+		Filename:    metadata.Filename,
+		Size:        metadata.Size,
+		CheckSum:    metadata.CheckSum,
+		ContentType: metadata.ContentType,
+	}
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		carrier := propagation.MapCarrier{}
+		propagation.TraceContext{}.Inject(ctx, carrier)
+
+		event.TraceID = spanContext.TraceID().String()
+		event.TraceParent = carrier.Get("traceparent")
+	}
+
+	return event
+}
+
+// Notify publishes event to webhooks if enabled. failure is only logged, upload never fails because of webhook
+func (f *fileService) Notify(ctx context.Context, event *entity.WebhookEvent) {
+	if f.webhook == nil {
+		return
+	}
+
+	if err := f.webhook.Publish(ctx, event); err != nil {
+		logrus.WithContext(ctx).Errorf("failed publish %s event of upload [%s] : %s", event.Type, event.UploadID, err.Error())
+	}
+}
+
+// Start spawns goroutine which sends due deliveries, woken by publish or every poll interval
+func (w *webhookService) Start() {
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		logrus.Infof("start webhook delivery to %d urls 📮", len(w.config.URLs))
+		for {
+			select {
+			case <-ticker.C:
+			case <-w.chanWake:
+			case <-w.chanQuit:
+				return
+			}
+
+			ctx, span := gootel.NewSpan(context.Background(), "webhook", "")
+			_, _ = w.DeliverDue(ctx)
+			span.End()
+		}
+	}()
+}
+
+// Stop stops delivery and waits until running delivery finished, pending deliveries stay in queue folder
+func (w *webhookService) Stop() {
+	close(w.chanQuit)
+	w.wg.Wait()
+	logrus.Infof("webhook delivery stopped 📮")
+}
+
+// Publish queues delivery of event to every webhook URL. delivery is written to queue folder before it returns
+func (w *webhookService) Publish(ctx context.Context, event *entity.WebhookEvent) error {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, webhookURL := range w.config.URLs {
+		delivery := &entity.WebhookDelivery{
+			ID:            fmt.Sprintf("%d-%s", now.UnixNano(), uuid.NewString()),
+			EventID:       event.ID,
+			EventType:     event.Type,
+			URL:           webhookURL,
+			Payload:       payload,
+			TraceParent:   event.TraceParent,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+
+		if err = w.PutDelivery(ctx, delivery); err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+
+	span.SetAttributes(
+		attribute.String("webhook.event_id", event.ID),
+		attribute.String("webhook.event_type", event.Type),
+	)
+
+	// wake worker, delivery is picked up by next poll if worker is busy
+	select {
+	case w.chanWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// DeliverDue sends every queued delivery whose next attempt is due, oldest first. returns number of delivered
+func (w *webhookService) DeliverDue(ctx context.Context) (int, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	// one delivery run at a time, so delivery is never sent twice concurrently
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entries, err := os.ReadDir(w.config.QueueFolder)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		// temporary file of delivery being written starts with dot
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || entry.Name() == deadLetterFilename || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	delivered := 0
+	for _, name := range names {
+		select {
+		case <-w.chanQuit:
+			return delivered, nil
+		default:
+		}
+
+		delivery, err := w.GetDelivery(ctx, strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			logger.Warnf("skip webhook delivery [%s] : %s ⚠️", name, err.Error())
+			continue
+		}
+
+		if time.Now().Before(delivery.NextAttemptAt) {
+			continue
+		}
+
+		ok, err := w.Deliver(ctx, delivery)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+
+		if ok {
+			delivered++
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("webhook.queued", len(names)),
+		attribute.Int("webhook.delivered", delivered),
+	)
+
+	return delivered, nil
+}
+
+// Deliver attempts delivery once. failed delivery is rescheduled with exponential backoff,
+// or appended to dead letter log once max attempts reached. returns whether receiver accepted it
+func (w *webhookService) Deliver(ctx context.Context, delivery *entity.WebhookDelivery) (bool, error) {
+	ctx, span := gootel.RecordSpan(ctx)
+	defer span.End()
+
+	logger := logrus.WithContext(ctx)
+
+	span.SetAttributes(
+		attribute.String("webhook.delivery_id", delivery.ID),
+		attribute.String("webhook.event_type", delivery.EventType),
+		attribute.Int("webhook.attempts", delivery.Attempts),
+	)
+
+	sendErr := w.Send(ctx, delivery)
+	if sendErr == nil {
+		if err := w.DeleteDelivery(delivery.ID); err != nil {
+			logger.Error(err)
+			return true, err
+		}
+
+		logger.Infof("success deliver %s event %s to %s 📮", delivery.EventType, delivery.EventID, delivery.URL)
+		return true, nil
+	}
+
+	delivery.Attempts++
+	delivery.LastError = sendErr.Error()
+
+	if delivery.Attempts >= w.config.MaxAttempts {
+		if err := w.DeadLetter(ctx, delivery); err != nil {
+			logger.Error(err)
+			return false, err
+		}
+
+		logger.Warnf("give up delivery of %s event %s to %s after %d attempts : %s ⚠️", delivery.EventType, delivery.EventID, delivery.URL, delivery.Attempts, sendErr.Error())
+		return false, nil
+	}
+
+	backoff := WebhookBackoff(delivery.Attempts)
+	delivery.NextAttemptAt = time.Now().UTC().Add(backoff)
+
+	if err := w.PutDelivery(ctx, delivery); err != nil {
+		logger.Error(err)
+		return false, err
+	}
+
+	logger.Warnf("failed deliver %s event %s to %s, retry in %s : %s ⚠️", delivery.EventType, delivery.EventID, delivery.URL, backoff, sendErr.Error())
+	return false, nil
+}
+
+// WebhookBackoff is wait before next attempt of delivery failed given times, doubled from one second up to max backoff
+func WebhookBackoff(attempts int) time.Duration {
+	// 2^16 seconds is beyond max backoff already, larger shift would overflow duration
+	if attempts > 16 {
+		return webhookMaxBackoff
+	}
+
+	return min(time.Second<<max(attempts-1, 0), webhookMaxBackoff)
+}
+
+// Send posts signed payload of delivery to its URL, any 2xx response means accepted
+func (w *webhookService) Send(ctx context.Context, delivery *entity.WebhookDelivery) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// event ID is same on every attempt and every URL, so receiver can drop duplicate delivery
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "go-upload-chunk-webhook")
+	request.Header.Set("X-Webhook-ID", delivery.EventID)
+	request.Header.Set("X-Webhook-Event", delivery.EventType)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", WebhookSignature(w.config.Secret, timestamp, delivery.Payload))
+	if delivery.TraceParent != "" {
+		request.Header.Set("traceparent", delivery.TraceParent)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", response.Status)
+	}
+
+	return nil
+}
+
+// GetDelivery reads queued delivery
+func (w *webhookService) GetDelivery(ctx context.Context, deliveryID string) (*entity.WebhookDelivery, error) {
+	content, err := os.ReadFile(w.deliveryPath(deliveryID))
+	if err != nil {
+		return nil, err
+	}
+
+	var delivery entity.WebhookDelivery
+	if err = json.Unmarshal(content, &delivery); err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// PutDelivery writes delivery into queue folder, replaced atomically so worker never reads partial delivery
+func (w *webhookService) PutDelivery(ctx context.Context, delivery *entity.WebhookDelivery) error {
+	content, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(w.config.QueueFolder, fmt.Sprintf(".%s-*.tmp", delivery.ID))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+
+	if _, err = tempFile.Write(content); err != nil {
+		return err
+	}
+
+	if err = tempFile.Sync(); err != nil {
+		return err
+	}
+
+	if err = tempFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), w.deliveryPath(delivery.ID))
+}
+
+// DeleteDelivery removes delivery from queue folder
+func (w *webhookService) DeleteDelivery(deliveryID string) error {
+	if err := os.Remove(w.deliveryPath(deliveryID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// DeadLetter appends delivery to dead letter log, then removes it from queue
+func (w *webhookService) DeadLetter(ctx context.Context, delivery *entity.WebhookDelivery) error {
+	content, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(w.config.QueueFolder, deadLetterFilename), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(content, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return w.DeleteDelivery(delivery.ID)
+}
+
+// deliveryPath returns file path of queued delivery
+func (w *webhookService) deliveryPath(deliveryID string) string {
+	return filepath.Join(w.config.QueueFolder, deliveryID+".json")
+}
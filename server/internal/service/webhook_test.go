@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"go-upload-chunk/server/internal/entity"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"event-1"}`)
+	want := "sha256=01017e2b3bf7b2f3c53c64a662fb4ee9c60a8a998e81d1b19dcfd0aa2de23880"
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		match     bool
+	}{
+		{name: "same request", secret: "secret", timestamp: "1700000000", body: body, match: true},
+		{name: "other secret", secret: "other", timestamp: "1700000000", body: body},
+		{name: "replayed at other time", secret: "secret", timestamp: "1700000001", body: body},
+		{name: "body changed", secret: "secret", timestamp: "1700000000", body: []byte(`{"id":"event-2"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WebhookSignature(tt.secret, tt.timestamp, tt.body); (got == want) != tt.match {
+				t.Errorf("WebhookSignature() = %s, match %s is %v", got, want, tt.match)
+			}
+		})
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: time.Second},
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 12, want: 2048 * time.Second},
+		{attempts: 13, want: webhookMaxBackoff},
+		{attempts: 17, want: webhookMaxBackoff},
+		{attempts: 64, want: webhookMaxBackoff},
+		{attempts: 1 << 30, want: webhookMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := WebhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("WebhookBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestNewWebhookServiceURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		urls    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "trimmed", urls: []string{" https://example.com/hook ", "", "http://localhost:8080"}, want: []string{"https://example.com/hook", "http://localhost:8080"}},
+		{name: "no url", urls: []string{" ", ""}, wantErr: true},
+		{name: "not http", urls: []string{"ftp://example.com"}, wantErr: true},
+		{name: "relative", urls: []string{"/hook"}, wantErr: true},
+		{name: "not url", urls: []string{"example.com hook"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook, err := NewWebhookService(WebhookConfig{URLs: tt.urls, Secret: "secret", QueueFolder: t.TempDir()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWebhookService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			urls := webhook.(*webhookService).config.URLs
+			if len(urls) != len(tt.want) {
+				t.Fatalf("urls = %q, want %q", urls, tt.want)
+			}
+
+			for i := range urls {
+				if urls[i] != tt.want[i] {
+					t.Errorf("urls = %q, want %q", urls, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // response of each attempt
+		wantAttempts int
+		wantBackoffs []time.Duration
+		dead         bool
+	}{
+		{name: "accepted", statuses: []int{http.StatusNoContent}},
+		{name: "accepted after retry", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, wantAttempts: 2, wantBackoffs: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "dead letter after max attempts", statuses: []int{http.StatusInternalServerError, http.StatusNotFound, http.StatusServiceUnavailable}, wantAttempts: 3, wantBackoffs: []time.Duration{time.Second, 2 * time.Second}, dead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				attempt  int
+				received []*http.Request
+			)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				body, _ := io.ReadAll(r.Body)
+				if r.Header.Get("X-Webhook-Signature") != WebhookSignature("secret", r.Header.Get("X-Webhook-Timestamp"), body) {
+					t.Errorf("attempt %d has invalid signature", attempt)
+				}
+
+				received = append(received, r)
+				w.WriteHeader(tt.statuses[attempt])
+				attempt++
+			}))
+			t.Cleanup(server.Close)
+
+			queueFolder := t.TempDir()
+			webhook, err := NewWebhookService(WebhookConfig{URLs: []string{server.URL}, Secret: "secret", QueueFolder: queueFolder, MaxAttempts: 3, Timeout: time.Second})
+			if err != nil {
+				t.Fatalf("NewWebhookService() error = %v", err)
+			}
+
+			w := webhook.(*webhookService)
+			ctx := context.Background()
+			delivery := &entity.WebhookDelivery{ID: "delivery-1", EventID: "event-1", EventType: entity.EventUploadCompleted, URL: server.URL, Payload: json.RawMessage(`{"id":"event-1"}`)}
+			if err = w.PutDelivery(ctx, delivery); err != nil {
+				t.Fatalf("PutDelivery() error = %v", err)
+			}
+
+			for i, status := range tt.statuses {
+				before := time.Now()
+				ok, err := w.Deliver(ctx, delivery)
+				if err != nil {
+					t.Fatalf("attempt %d: Deliver() error = %v", i, err)
+				}
+
+				if accepted := status < 300; ok != accepted {
+					t.Fatalf("attempt %d: Deliver() = %v, want %v", i, ok, accepted)
+				}
+
+				if ok || i == len(tt.statuses)-1 {
+					break
+				}
+
+				// retry of failed delivery is scheduled by backoff and survives in queue
+				queued, err := w.GetDelivery(ctx, delivery.ID)
+				if err != nil {
+					t.Fatalf("attempt %d: GetDelivery() error = %v", i, err)
+				}
+
+				if wait := queued.NextAttemptAt.Sub(before); wait < tt.wantBackoffs[i] || wait > tt.wantBackoffs[i]+time.Second {
+					t.Errorf("attempt %d: next attempt in %s, want %s", i, wait, tt.wantBackoffs[i])
+				}
+
+				delivery = queued
+			}
+
+			if delivery.Attempts != tt.wantAttempts {
+				t.Errorf("delivery attempts = %d, want %d", delivery.Attempts, tt.wantAttempts)
+			}
+
+			// same event ID on every attempt, so receiver drops duplicate
+			for _, r := range received {
+				if r.Header.Get("X-Webhook-ID") != "event-1" {
+					t.Errorf("X-Webhook-ID = %q, want event-1", r.Header.Get("X-Webhook-ID"))
+				}
+			}
+
+			if _, err = os.Stat(w.deliveryPath(delivery.ID)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("delivery still queued after last attempt, stat error = %v", err)
+			}
+
+			file, err := os.Open(filepath.Join(queueFolder, deadLetterFilename))
+			if tt.dead != (err == nil) {
+				t.Fatalf("dead letter log exists = %v, want %v", err == nil, tt.dead)
+			}
+
+			if err == nil {
+				defer file.Close()
+
+				var dead entity.WebhookDelivery
+				scanner := bufio.NewScanner(file)
+				if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &dead) != nil || dead.ID != delivery.ID || dead.LastError == "" {
+					t.Errorf("dead letter = %+v, want delivery %s with its last error", dead, delivery.ID)
+				}
+			}
+		})
+	}
+}
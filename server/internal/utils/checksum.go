@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumReader hashes everything read through it and returns ErrChecksumMismatch instead of io.EOF
+// when sum is not as expected, so writer of the content can discard it
+type ChecksumReader struct {
+	reader   io.Reader
+	hash     hash.Hash
+	expected []byte
+}
+
+func NewChecksumReader(reader io.Reader, hash hash.Hash, expected []byte) *ChecksumReader {
+	return &ChecksumReader{
+		reader:   reader,
+		hash:     hash,
+		expected: expected,
+	}
+}
+
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.hash.Write(p[:n])
+
+	if err == io.EOF && !bytes.Equal(c.hash.Sum(nil), c.expected) {
+		return n, ErrChecksumMismatch
+	}
+
+	return n, err
+}
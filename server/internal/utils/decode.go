@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+)
+
+// SupportedEncodings is content codings accepted by NewDecodeReader, sent as Accept-Encoding when coding is rejected
+const SupportedEncodings = "gzip, deflate, zstd"
+
+// decodeRatioFloor is decoded size below which ratio limit is not checked, small content may compress very well
+const decodeRatioFloor = 1 << 20
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrDecodeLimit         = errors.New("decoded content exceeds limit")
+	ErrCorruptEncoding     = errors.New("corrupt encoded content")
+)
+
+// NewDecodeReader returns reader of content decoded by given Content-Encoding, empty or identity returns content as is.
+// content is decoded while streamed, decoding fails with ErrDecodeLimit once decoded bytes exceed maxSize,
+// or exceed maxRatio times of encoded bytes read, so decompression bomb is stopped before it is inflated.
+// zero limit is unlimited
+func NewDecodeReader(encoding string, content io.Reader, maxSize, maxRatio int64) (io.ReadCloser, error) {
+	encoded := &encodedReader{reader: content}
+	reader := &DecodeReader{
+		encoded:  encoded,
+		maxSize:  maxSize,
+		maxRatio: maxRatio,
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		reader.decoder = io.NopCloser(encoded)
+	case "gzip", "x-gzip":
+		decoder, err := gzip.NewReader(encoded)
+		if err != nil {
+			return nil, reader.wrapError(err)
+		}
+
+		reader.decoder = decoder
+	case "deflate":
+		decoder, err := newDeflateReader(encoded)
+		if err != nil {
+			return nil, reader.wrapError(err)
+		}
+
+		reader.decoder = decoder
+	case "zstd":
+		options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if maxSize > 0 {
+			options = append(options, zstd.WithDecoderMaxMemory(uint64(maxSize)))
+		}
+
+		decoder, err := zstd.NewReader(encoded, options...)
+		if err != nil {
+			return nil, reader.wrapError(err)
+		}
+
+		reader.decoder = decoder.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("%w : %s", ErrUnsupportedEncoding, encoding)
+	}
+
+	return reader, nil
+}
+
+// newDeflateReader reads zlib stream as specified by http deflate coding, some clients send raw deflate stream instead
+func newDeflateReader(content io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(content)
+
+	header, err := buffered.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
+
+// DecodeReader reads decoded content and enforces limits of decoded size
+type DecodeReader struct {
+	decoder  io.ReadCloser
+	encoded  *encodedReader
+	decoded  int64
+	maxSize  int64
+	maxRatio int64
+}
+
+func (d *DecodeReader) Read(p []byte) (int, error) {
+	n, err := d.decoder.Read(p)
+	d.decoded += int64(n)
+
+	if d.maxSize > 0 && d.decoded > d.maxSize {
+		return n, fmt.Errorf("%w : more than %d bytes", ErrDecodeLimit, d.maxSize)
+	}
+
+	if d.maxRatio > 0 && d.decoded > decodeRatioFloor && d.decoded > d.encoded.n*d.maxRatio {
+		return n, fmt.Errorf("%w : %d bytes decoded from %d bytes, ratio is more than %d", ErrDecodeLimit, d.decoded, d.encoded.n, d.maxRatio)
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, d.wrapError(err)
+	}
+
+	return n, err
+}
+
+func (d *DecodeReader) Close() error {
+	return d.decoder.Close()
+}
+
+// wrapError tells error of decoder from error of reading encoded content, e.g. request body too large
+func (d *DecodeReader) wrapError(err error) error {
+	if d.encoded.err != nil && !errors.Is(d.encoded.err, io.EOF) {
+		return d.encoded.err
+	}
+
+	return fmt.Errorf("%w : %s", ErrCorruptEncoding, err.Error())
+}
+
+// encodedReader counts encoded bytes and keeps error of reading them
+type encodedReader struct {
+	reader io.Reader
+	n      int64
+	err    error
+}
+
+func (e *encodedReader) Read(p []byte) (int, error) {
+	n, err := e.reader.Read(p)
+	e.n += int64(n)
+	if err != nil {
+		e.err = err
+	}
+
+	return n, err
+}
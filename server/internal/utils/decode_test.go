@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"testing"
+)
+
+// encode compresses content by given content coding
+func encode(t *testing.T, encoding string, content []byte) []byte {
+	t.Helper()
+
+	var (
+		buffer bytes.Buffer
+		writer io.WriteCloser
+		err    error
+	)
+
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "deflate":
+		writer = zlib.NewWriter(&buffer)
+	case "raw deflate":
+		writer, err = flate.NewWriter(&buffer, flate.DefaultCompression)
+	case "zstd":
+		writer, err = zstd.NewWriter(&buffer)
+	default:
+		return content
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestDecodeReader(t *testing.T) {
+	content := []byte("chunk content compressed by client, chunk content compressed by client")
+	bomb := make([]byte, 8<<20)
+
+	tests := []struct {
+		name     string
+		encoding string // content coding header
+		encoder  string // coding content is compressed by, same as encoding if empty
+		content  []byte
+		maxSize  int64
+		maxRatio int64
+		wantErr  error
+	}{
+		{name: "identity", encoding: "identity", content: content},
+		{name: "no encoding", encoding: "", content: content},
+		{name: "gzip", encoding: "gzip", content: content},
+		{name: "x-gzip", encoding: "x-gzip", encoder: "gzip", content: content},
+		{name: "deflate", encoding: "deflate", content: content},
+		{name: "raw deflate", encoding: "deflate", encoder: "raw deflate", content: content},
+		{name: "zstd", encoding: "zstd", content: content},
+		{name: "coding is case insensitive", encoding: " GZIP ", encoder: "gzip", content: content},
+		{name: "within size limit", encoding: "gzip", content: content, maxSize: int64(len(content))},
+		{name: "size limit", encoding: "gzip", content: content, maxSize: int64(len(content)) - 1, wantErr: ErrDecodeLimit},
+		{name: "identity size limit", encoding: "identity", content: content, maxSize: 10, wantErr: ErrDecodeLimit},
+		{name: "gzip bomb by ratio", encoding: "gzip", content: bomb, maxRatio: 100, wantErr: ErrDecodeLimit},
+		{name: "zstd bomb by ratio", encoding: "zstd", content: bomb, maxRatio: 100, wantErr: ErrDecodeLimit},
+		{name: "deflate bomb by size", encoding: "deflate", content: bomb, maxSize: 1 << 20, wantErr: ErrDecodeLimit},
+		{name: "small content below ratio floor", encoding: "gzip", content: make([]byte, 1<<19), maxRatio: 2},
+		{name: "not gzip", encoding: "gzip", encoder: "identity", content: content, wantErr: ErrCorruptEncoding},
+		{name: "not zstd", encoding: "zstd", encoder: "identity", content: content, wantErr: ErrCorruptEncoding},
+		{name: "unsupported", encoding: "br", content: content, wantErr: ErrUnsupportedEncoding},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := tt.encoder
+			if encoder == "" {
+				encoder = tt.encoding
+			}
+
+			reader, err := NewDecodeReader(tt.encoding, bytes.NewReader(encode(t, encoder, tt.content)), tt.maxSize, tt.maxRatio)
+			var decoded []byte
+			if err == nil {
+				decoded, err = io.ReadAll(reader)
+				_ = reader.Close()
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decode error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && !bytes.Equal(decoded, tt.content) {
+				t.Errorf("decoded = %d bytes, want %d bytes", len(decoded), len(tt.content))
+			}
+		})
+	}
+}
+
+func TestDecodeReaderTruncated(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			encoded := encode(t, encoding, bytes.Repeat([]byte("truncated content "), 1000))
+
+			reader, err := NewDecodeReader(encoding, bytes.NewReader(encoded[:len(encoded)/2]), 0, 0)
+			if err == nil {
+				_, err = io.ReadAll(reader)
+			}
+
+			if !errors.Is(err, ErrCorruptEncoding) {
+				t.Errorf("decode error of truncated content = %v, want %v", err, ErrCorruptEncoding)
+			}
+		})
+	}
+}
+
+func TestDecodeReaderBodyError(t *testing.T) {
+	errBody := errors.New("request body too large")
+	encoded := encode(t, "gzip", bytes.Repeat([]byte("content "), 1000))
+
+	// error of reading encoded content is returned as is, so it is not reported as corrupt encoding
+	reader, err := NewDecodeReader("gzip", io.MultiReader(bytes.NewReader(encoded[:len(encoded)/2]), &errorReader{err: errBody}), 0, 0)
+	if err == nil {
+		_, err = io.ReadAll(reader)
+	}
+
+	if !errors.Is(err, errBody) {
+		t.Errorf("decode error = %v, want %v", err, errBody)
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encrypted content is header followed by segments. header is version and random salt, segment key is derived
+// from data key and salt, so data key is shared by many files without nonce reuse. each segment is sealed with
+// its index as nonce and flag of last segment, so segments can't be reordered or truncated
+const (
+	envelopeVersion     = 1
+	envelopeSaltSize    = 16
+	envelopeHeaderSize  = 1 + envelopeSaltSize
+	envelopeSegmentSize = 64 << 10
+	envelopeTagSize     = 16
+	DataKeySize         = 32
+)
+
+var ErrDecrypt = errors.New("failed decrypt content")
+
+// NewDataKey generates random AES-256 data key
+func NewDataKey() ([]byte, error) {
+	dataKey := make([]byte, DataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	return dataKey, nil
+}
+
+// EncryptedSize returns size of encrypted content of given plaintext size
+func EncryptedSize(size int64) int64 {
+	segments := (size + envelopeSegmentSize - 1) / envelopeSegmentSize
+	if segments == 0 {
+		segments = 1
+	}
+
+	return envelopeHeaderSize + size + segments*envelopeTagSize
+}
+
+// DecryptedSize returns plaintext size of encrypted content of given size
+func DecryptedSize(size int64) int64 {
+	size -= envelopeHeaderSize
+	if size <= 0 {
+		return 0
+	}
+
+	segments := (size + envelopeSegmentSize + envelopeTagSize - 1) / (envelopeSegmentSize + envelopeTagSize)
+	return max(size-segments*envelopeTagSize, 0)
+}
+
+// segmentCipher derives AEAD of one encrypted content from data key and its salt
+func segmentCipher(dataKey, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write(salt)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(index))
+	if last {
+		nonce[11] = 1
+	}
+
+	return nonce
+}
+
+// EncryptReader encrypts content read through it with AES-256-GCM segments
+type EncryptReader struct {
+	source  *bufio.Reader
+	dataKey []byte
+	aead    cipher.AEAD
+	segment []byte
+	out     []byte
+	index   int64
+	done    bool
+}
+
+func NewEncryptReader(content io.Reader, dataKey []byte) *EncryptReader {
+	return &EncryptReader{
+		source:  bufio.NewReaderSize(content, envelopeSegmentSize),
+		dataKey: dataKey,
+		segment: make([]byte, envelopeSegmentSize),
+	}
+}
+
+func (e *EncryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+
+		if err := e.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// next seals next segment of content, header is written before first segment
+func (e *EncryptReader) next() error {
+	if e.aead == nil {
+		header := make([]byte, envelopeHeaderSize)
+		header[0] = envelopeVersion
+		if _, err := rand.Read(header[1:]); err != nil {
+			return err
+		}
+
+		aead, err := segmentCipher(e.dataKey, header[1:])
+		if err != nil {
+			return err
+		}
+
+		e.aead = aead
+		e.out = header
+	}
+
+	n, err := io.ReadFull(e.source, e.segment)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		e.done = true
+	case err != nil:
+		return err
+	default:
+		// segment is last if nothing follows it
+		if _, err = e.source.Peek(1); errors.Is(err, io.EOF) {
+			e.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	e.out = e.aead.Seal(e.out, segmentNonce(e.index, e.done), e.segment[:n], nil)
+	e.index++
+	return nil
+}
+
+// DecryptReader decrypts content encrypted by EncryptReader, it reads content sequentially
+type DecryptReader struct {
+	source  *bufio.Reader
+	dataKey []byte
+	aead    cipher.AEAD
+	segment []byte
+	out     []byte
+	index   int64
+	done    bool
+}
+
+func NewDecryptReader(content io.Reader, dataKey []byte) *DecryptReader {
+	return &DecryptReader{
+		source:  bufio.NewReaderSize(content, envelopeSegmentSize+envelopeTagSize),
+		dataKey: dataKey,
+		segment: make([]byte, envelopeSegmentSize+envelopeTagSize),
+	}
+}
+
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// next opens next segment of content, header is read before first segment
+func (d *DecryptReader) next() error {
+	if d.aead == nil {
+		aead, err := readEnvelopeHeader(d.source, d.dataKey)
+		if err != nil {
+			return err
+		}
+
+		d.aead = aead
+	}
+
+	n, err := io.ReadFull(d.source, d.segment)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err = d.source.Peek(1); errors.Is(err, io.EOF) {
+			d.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plaintext, err := d.aead.Open(d.segment[:0], segmentNonce(d.index, d.done), d.segment[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w : segment %d", ErrDecrypt, d.index)
+	}
+
+	d.out = plaintext
+	d.index++
+	return nil
+}
+
+func readEnvelopeHeader(content io.Reader, dataKey []byte) (cipher.AEAD, error) {
+	header := make([]byte, envelopeHeaderSize)
+	if _, err := io.ReadFull(content, header); err != nil {
+		return nil, fmt.Errorf("%w : invalid header, %s", ErrDecrypt, err.Error())
+	}
+
+	if header[0] != envelopeVersion {
+		return nil, fmt.Errorf("%w : unknown version %d", ErrDecrypt, header[0])
+	}
+
+	return segmentCipher(dataKey, header[1:])
+}
+
+// DecryptReadSeeker decrypts content encrypted by EncryptReader, it seeks to segment of any offset,
+// so range of plaintext is read without decrypting content before it
+type DecryptReadSeeker struct {
+	source   io.ReadSeeker
+	aead     cipher.AEAD
+	size     int64
+	offset   int64
+	segment  []byte
+	current  int64
+	loaded   []byte
+	segments int64
+}
+
+func NewDecryptReadSeeker(content io.ReadSeeker, dataKey []byte) (*DecryptReadSeeker, error) {
+	encryptedSize, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	aead, err := readEnvelopeHeader(content, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	size := DecryptedSize(encryptedSize)
+	segments := max((size+envelopeSegmentSize-1)/envelopeSegmentSize, 1)
+
+	return &DecryptReadSeeker{
+		source:   content,
+		aead:     aead,
+		size:     size,
+		segment:  make([]byte, envelopeSegmentSize+envelopeTagSize),
+		current:  -1,
+		segments: segments,
+	}, nil
+}
+
+func (d *DecryptReadSeeker) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+
+	index := d.offset / envelopeSegmentSize
+	if index != d.current {
+		if err := d.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.loaded[d.offset-index*envelopeSegmentSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+// load reads and opens segment of given index
+func (d *DecryptReadSeeker) load(index int64) error {
+	if _, err := d.source.Seek(envelopeHeaderSize+index*(envelopeSegmentSize+envelopeTagSize), io.SeekStart); err != nil {
+		return err
+	}
+
+	length := min(d.size-index*envelopeSegmentSize, envelopeSegmentSize) + envelopeTagSize
+	if _, err := io.ReadFull(d.source, d.segment[:length]); err != nil {
+		return fmt.Errorf("%w : segment %d, %s", ErrDecrypt, index, err.Error())
+	}
+
+	plaintext, err := d.aead.Open(d.segment[:0], segmentNonce(index, index == d.segments-1), d.segment[:length], nil)
+	if err != nil {
+		d.current = -1
+		return fmt.Errorf("%w : segment %d", ErrDecrypt, index)
+	}
+
+	d.current = index
+	d.loaded = plaintext
+	return nil
+}
+
+func (d *DecryptReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	d.offset = offset
+	return offset, nil
+}
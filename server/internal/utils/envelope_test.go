@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func mustDataKey(t *testing.T) []byte {
+	t.Helper()
+
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dataKey
+}
+
+func encrypt(t *testing.T, plaintext, dataKey []byte) []byte {
+	t.Helper()
+
+	encrypted, err := io.ReadAll(NewEncryptReader(bytes.NewReader(plaintext), dataKey))
+	if err != nil {
+		t.Fatalf("EncryptReader error = %v", err)
+	}
+
+	return encrypted
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "one byte", size: 1},
+		{name: "less than segment", size: envelopeSegmentSize - 1},
+		{name: "one segment", size: envelopeSegmentSize},
+		{name: "more than segment", size: envelopeSegmentSize + 1},
+		{name: "many segments", size: 3*envelopeSegmentSize + 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataKey := mustDataKey(t)
+			plaintext := make([]byte, tt.size)
+			_, _ = rand.Read(plaintext)
+
+			encrypted := encrypt(t, plaintext, dataKey)
+			if int64(len(encrypted)) != EncryptedSize(int64(tt.size)) {
+				t.Errorf("encrypted size = %d, EncryptedSize() = %d", len(encrypted), EncryptedSize(int64(tt.size)))
+			}
+
+			if DecryptedSize(int64(len(encrypted))) != int64(tt.size) {
+				t.Errorf("DecryptedSize() = %d, want %d", DecryptedSize(int64(len(encrypted))), tt.size)
+			}
+
+			if tt.size > 16 && bytes.Contains(encrypted, plaintext[:16]) {
+				t.Errorf("encrypted content contains plaintext")
+			}
+
+			decrypted, err := io.ReadAll(NewDecryptReader(bytes.NewReader(encrypted), dataKey))
+			if err != nil || !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("DecryptReader = %d bytes, %v, want %d bytes", len(decrypted), err, tt.size)
+			}
+
+			readSeeker, err := NewDecryptReadSeeker(bytes.NewReader(encrypted), dataKey)
+			if err != nil {
+				t.Fatalf("NewDecryptReadSeeker() error = %v", err)
+			}
+
+			// range read from any offset, e.g. across segment boundary
+			for _, offset := range []int{0, tt.size / 2, max(tt.size-3, 0), tt.size} {
+				if _, err = readSeeker.Seek(int64(offset), io.SeekStart); err != nil {
+					t.Fatalf("Seek(%d) error = %v", offset, err)
+				}
+
+				decrypted, err = io.ReadAll(readSeeker)
+				if err != nil || !bytes.Equal(decrypted, plaintext[offset:]) {
+					t.Errorf("DecryptReadSeeker from %d = %d bytes, %v, want %d bytes", offset, len(decrypted), err, tt.size-offset)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvelopeSameKeyDifferentContent(t *testing.T) {
+	dataKey := mustDataKey(t)
+	plaintext := []byte("same content encrypted twice")
+
+	// random salt of each content derives its own segment key, so data key is shared without nonce reuse
+	if bytes.Equal(encrypt(t, plaintext, dataKey), encrypt(t, plaintext, dataKey)) {
+		t.Errorf("same content encrypted twice by same data key gives same ciphertext")
+	}
+}
+
+func TestEnvelopeRejectsTampering(t *testing.T) {
+	dataKey := mustDataKey(t)
+	plaintext := bytes.Repeat([]byte("0123456789abcdef"), envelopeSegmentSize/8)
+	encrypted := encrypt(t, plaintext, dataKey)
+	segment := envelopeSegmentSize + envelopeTagSize
+
+	tests := []struct {
+		name      string
+		dataKey   []byte
+		encrypted func() []byte
+	}{
+		{name: "other data key", dataKey: mustDataKey(t), encrypted: func() []byte { return bytes.Clone(encrypted) }},
+		{name: "flipped byte", dataKey: dataKey, encrypted: func() []byte {
+			b := bytes.Clone(encrypted)
+			b[envelopeHeaderSize+10] ^= 1
+			return b
+		}},
+		{name: "truncated after first segment", dataKey: dataKey, encrypted: func() []byte {
+			return bytes.Clone(encrypted[:envelopeHeaderSize+segment])
+		}},
+		{name: "segments reordered", dataKey: dataKey, encrypted: func() []byte {
+			b := bytes.Clone(encrypted[:envelopeHeaderSize])
+			b = append(b, encrypted[envelopeHeaderSize+segment:]...)
+			return append(b, encrypted[envelopeHeaderSize:envelopeHeaderSize+segment]...)
+		}},
+		{name: "unknown version", dataKey: dataKey, encrypted: func() []byte {
+			b := bytes.Clone(encrypted)
+			b[0] = envelopeVersion + 1
+			return b
+		}},
+		{name: "header only", dataKey: dataKey, encrypted: func() []byte { return bytes.Clone(encrypted[:5]) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := io.ReadAll(NewDecryptReader(bytes.NewReader(tt.encrypted()), tt.dataKey)); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("DecryptReader error = %v, want %v", err, ErrDecrypt)
+			}
+
+			readSeeker, err := NewDecryptReadSeeker(bytes.NewReader(tt.encrypted()), tt.dataKey)
+			if err == nil {
+				_, err = io.ReadAll(readSeeker)
+			}
+
+			if !errors.Is(err, ErrDecrypt) {
+				t.Errorf("DecryptReadSeeker error = %v, want %v", err, ErrDecrypt)
+			}
+		})
+	}
+}
@@ -11,3 +11,9 @@ var ByteBufferPool = &sync.Pool{New: func() any {
 	b := &bytes.Buffer{}
 	return b
 }}
+
+// CopyBufferPool holds fixed size buffers of io.CopyBuffer, so content is streamed without being held in memory
+var CopyBufferPool = &sync.Pool{New: func() any {
+	b := make([]byte, 32*1024)
+	return &b
+}}
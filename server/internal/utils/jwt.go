@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtLeeway tolerates clock skew between token issuer and server
+const jwtLeeway = 30 * time.Second
+
+var (
+	ErrJWTMalformed   = errors.New("malformed token")
+	ErrJWTAlgorithm   = errors.New("unsupported token algorithm")
+	ErrJWTKeyNotFound = errors.New("token key not found")
+	ErrJWTSignature   = errors.New("invalid token signature")
+	ErrJWTExpired     = errors.New("token expired or not valid yet")
+)
+
+// JWTKeySet is set of keys to verify JWT. HS256 uses "oct" key, RS256 uses "RSA" key
+type JWTKeySet struct {
+	keys []jwtKey
+}
+
+// Len returns number of keys in key set
+func (k *JWTKeySet) Len() int {
+	return len(k.keys)
+}
+
+type jwtKey struct {
+	id        string
+	algorithm string
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+// JWTClaims is registered claims of JWT used by server, scope is space separated
+type JWTClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  JWTAudience `json:"aud"`
+	ExpiresAt float64     `json:"exp"`
+	NotBefore float64     `json:"nbf"`
+	Scope     string      `json:"scope"`
+	Tenant    string      `json:"tenant"`
+}
+
+// JWTAudience is "aud" claim, either one string or array of strings
+type JWTAudience []string
+
+func (a *JWTAudience) UnmarshalJSON(b []byte) error {
+	var audience string
+	if err := json.Unmarshal(b, &audience); err == nil {
+		*a = JWTAudience{audience}
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// Contains checks whether audience contains given value
+func (a JWTAudience) Contains(value string) bool {
+	for _, audience := range a {
+		if audience == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseJWKS parses JSON Web Key Set, e.g. {"keys":[{"kty":"oct","kid":"k1","k":"base64url"}]}
+func ParseJWKS(content []byte) (*JWTKeySet, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, err
+	}
+
+	keySet := JWTKeySet{}
+	for _, key := range jwks.Keys {
+		switch key.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.K, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid oct key [%s] : %w", key.Kid, err)
+			}
+
+			if len(secret) < sha256.Size {
+				return nil, fmt.Errorf("oct key [%s] is shorter than %d bytes", key.Kid, sha256.Size)
+			}
+
+			keySet.keys = append(keySet.keys, jwtKey{id: key.Kid, algorithm: "HS256", secret: secret})
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.N, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid RSA key [%s] : %w", key.Kid, err)
+			}
+
+			e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.E, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid RSA key [%s] : %w", key.Kid, err)
+			}
+
+			publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			if publicKey.N.BitLen() < 2048 {
+				return nil, fmt.Errorf("RSA key [%s] is shorter than 2048 bits", key.Kid)
+			}
+
+			keySet.keys = append(keySet.keys, jwtKey{id: key.Kid, algorithm: "RS256", publicKey: publicKey})
+		default:
+			return nil, fmt.Errorf("unsupported key type %s of key [%s]", key.Kty, key.Kid)
+		}
+	}
+
+	return &keySet, nil
+}
+
+// Verify verifies signature and validity time of JWT, then returns its claims.
+// algorithm of token must match type of key, so RSA public key is never used as HMAC secret
+func (k *JWTKeySet) Verify(token string, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	if header.Alg != "HS256" && header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w : %s", ErrJWTAlgorithm, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	// token without kid is tried against every key of its algorithm
+	var (
+		signingInput = []byte(parts[0] + "." + parts[1])
+		verified     bool
+		found        bool
+	)
+
+	for _, key := range k.keys {
+		if key.algorithm != header.Alg || (header.Kid != "" && key.id != header.Kid) {
+			continue
+		}
+
+		found = true
+		if key.verify(signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+
+	switch {
+	case !found:
+		return nil, fmt.Errorf("%w : %s", ErrJWTKeyNotFound, header.Kid)
+	case !verified:
+		return nil, ErrJWTSignature
+	}
+
+	var claims JWTClaims
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	// expiration is required, not before is optional
+	if claims.ExpiresAt == 0 || now.After(time.Unix(int64(claims.ExpiresAt), 0).Add(jwtLeeway)) {
+		return nil, ErrJWTExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(int64(claims.NotBefore), 0)) {
+		return nil, ErrJWTExpired
+	}
+
+	return &claims, nil
+}
+
+func (k jwtKey) verify(signingInput, signature []byte) bool {
+	switch k.algorithm {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		hashed := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(k.publicKey, crypto.SHA256, hashed[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+func decodeJWTPart(part string, v any) error {
+	content, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+
+	if err = json.Unmarshal(content, v); err != nil {
+		return ErrJWTMalformed
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+	testNow        = time.Unix(1700000000, 0)
+)
+
+// signJWT creates token of given header and claims, signed by HMAC secret or RSA private key
+func signJWT(t *testing.T, header, claims map[string]any, key any) string {
+	t.Helper()
+
+	encode := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	signingInput := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		hashed := sha256.Sum256([]byte(signingInput))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testJWKS(t *testing.T, publicKey *rsa.PublicKey) *JWTKeySet {
+	t.Helper()
+
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"oct","kid":"hs","k":%q},
+		{"kty":"RSA","kid":"rs","n":%q,"e":%q}
+	]}`,
+		base64.RawURLEncoding.EncodeToString(testHMACSecret),
+		base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	)
+
+	keySet, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatalf("ParseJWKS() error = %v", err)
+	}
+
+	return keySet
+}
+
+func TestJWTKeySetVerify(t *testing.T) {
+	privateKey, otherKey := mustGenerateKey(t), mustGenerateKey(t)
+
+	keySet := testJWKS(t, &privateKey.PublicKey)
+	claims := map[string]any{"sub": "alice", "aud": "upload", "scope": "upload read", "exp": testNow.Add(time.Hour).Unix()}
+
+	// rsa public key encoded as HMAC secret, token of "alg" confusion
+	publicKeySecret := []byte(base64.RawURLEncoding.EncodeToString(privateKey.PublicKey.N.Bytes()))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "HS256", token: signJWT(t, map[string]any{"alg": "HS256", "kid": "hs"}, claims, testHMACSecret)},
+		{name: "HS256 without kid", token: signJWT(t, map[string]any{"alg": "HS256"}, claims, testHMACSecret)},
+		{name: "RS256", token: signJWT(t, map[string]any{"alg": "RS256", "kid": "rs"}, claims, privateKey)},
+		{name: "RS256 without kid", token: signJWT(t, map[string]any{"alg": "RS256"}, claims, privateKey)},
+		{name: "wrong HMAC secret", token: signJWT(t, map[string]any{"alg": "HS256", "kid": "hs"}, claims, []byte("another secret")), wantErr: ErrJWTSignature},
+		{name: "wrong RSA key", token: signJWT(t, map[string]any{"alg": "RS256", "kid": "rs"}, claims, otherKey), wantErr: ErrJWTSignature},
+		{name: "RSA key used as HMAC secret", token: signJWT(t, map[string]any{"alg": "HS256", "kid": "rs"}, claims, publicKeySecret), wantErr: ErrJWTKeyNotFound},
+		{name: "unknown kid", token: signJWT(t, map[string]any{"alg": "HS256", "kid": "other"}, claims, testHMACSecret), wantErr: ErrJWTKeyNotFound},
+		{name: "none algorithm", token: signJWT(t, map[string]any{"alg": "none"}, claims, nil), wantErr: ErrJWTAlgorithm},
+		{name: "expired", token: signJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "exp": testNow.Add(-time.Minute).Unix()}, testHMACSecret), wantErr: ErrJWTExpired},
+		{name: "expired within leeway", token: signJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "exp": testNow.Add(-10 * time.Second).Unix()}, testHMACSecret)},
+		{name: "without expiration", token: signJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice"}, testHMACSecret), wantErr: ErrJWTExpired},
+		{name: "not valid yet", token: signJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "exp": testNow.Add(time.Hour).Unix(), "nbf": testNow.Add(time.Minute).Unix()}, testHMACSecret), wantErr: ErrJWTExpired},
+		{name: "two parts", token: "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJhbGljZSJ9", wantErr: ErrJWTMalformed},
+		{name: "invalid header", token: "not-base64!.e30.c2ln", wantErr: ErrJWTMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keySet.Verify(tt.token, testNow)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && got.Subject != "alice" {
+				t.Errorf("Verify() subject = %q, want alice", got.Subject)
+			}
+		})
+	}
+}
+
+func TestJWTKeySetVerifyTampered(t *testing.T) {
+	keySet := testJWKS(t, &mustGenerateKey(t).PublicKey)
+	token := signJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "scope": "read", "exp": testNow.Add(time.Hour).Unix()}, testHMACSecret)
+
+	// claims changed after signing, e.g. scope raised
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"alice","scope":"admin","exp":%d}`, testNow.Add(time.Hour).Unix())))
+
+	if _, err := keySet.Verify(strings.Join(parts, "."), testNow); !errors.Is(err, ErrJWTSignature) {
+		t.Errorf("Verify() of tampered token error = %v, want %v", err, ErrJWTSignature)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	shortKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		jwks    string
+		wantErr bool
+	}{
+		{name: "oct key", jwks: fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"k1","k":%q}]}`, base64.RawURLEncoding.EncodeToString(testHMACSecret))},
+		{name: "short oct key", jwks: `{"keys":[{"kty":"oct","kid":"k1","k":"c2hvcnQ"}]}`, wantErr: true},
+		{name: "short RSA key", jwks: fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"k1","n":%q,"e":"AQAB"}]}`, base64.RawURLEncoding.EncodeToString(shortKey.N.Bytes())), wantErr: true},
+		{name: "unsupported key type", jwks: `{"keys":[{"kty":"EC","kid":"k1"}]}`, wantErr: true},
+		{name: "invalid json", jwks: `{"keys":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJWKS([]byte(tt.jwks)); (err != nil) != tt.wantErr {
+				t.Errorf("ParseJWKS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   string
+		contains bool
+	}{
+		{name: "string", claims: `{"aud":"upload"}`, contains: true},
+		{name: "array", claims: `{"aud":["other","upload"]}`, contains: true},
+		{name: "other audience", claims: `{"aud":"other"}`},
+		{name: "none", claims: `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims JWTClaims
+			if err := json.Unmarshal([]byte(tt.claims), &claims); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			if got := claims.Audience.Contains("upload"); got != tt.contains {
+				t.Errorf("Contains() = %v, want %v", got, tt.contains)
+			}
+		})
+	}
+}
+
+func mustGenerateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return privateKey
+}
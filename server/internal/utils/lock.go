@@ -0,0 +1,65 @@
+package utils
+
+import "sync"
+
+// KeyedMutex is mutex per key, e.g. per upload ID. unused key is removed from map
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: map[string]*keyedLock{}}
+}
+
+// Lock locks given key and returns function to unlock it
+func (k *KeyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		k.unlock(key, lock)
+	}
+}
+
+// TryLock locks given key only if it is not locked or awaited by others, it never blocks
+func (k *KeyedMutex) TryLock(key string) (func(), bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.locks[key]; ok {
+		return nil, false
+	}
+
+	lock := &keyedLock{refs: 1}
+	lock.mu.Lock()
+	k.locks[key] = lock
+
+	return func() {
+		k.unlock(key, lock)
+	}, true
+}
+
+func (k *KeyedMutex) unlock(key string, lock *keyedLock) {
+	lock.mu.Unlock()
+
+	k.mu.Lock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(k.locks, key)
+	}
+	k.mu.Unlock()
+}
@@ -0,0 +1,47 @@
+package utils
+
+import "time"
+
+// TokenBucket refills rate tokens per second up to burst. tokens can be charged after request finished,
+// so bucket may be in debt and callers wait until debt paid
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate, burst float64, now time.Time) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// Take takes n tokens if available, otherwise returns how long caller must wait
+func (b *TokenBucket) Take(n float64, now time.Time) time.Duration {
+	b.refill(now)
+
+	need := min(n, b.burst)
+	if b.tokens >= need {
+		b.tokens -= n
+		return 0
+	}
+
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Charge takes n tokens regardless of available tokens
+func (b *TokenBucket) Charge(n float64, now time.Time) {
+	b.refill(now)
+	b.tokens -= n
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
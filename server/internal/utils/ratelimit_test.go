@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	type take struct {
+		after time.Duration // since start
+		n     float64
+		wait  time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst float64
+		takes []take
+	}{
+		{
+			name: "burst then wait", rate: 2, burst: 4,
+			takes: []take{
+				{n: 4},
+				{n: 1, wait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "refill by elapsed time", rate: 2, burst: 4,
+			takes: []take{
+				{n: 4},
+				{after: time.Second, n: 2},
+				{after: time.Second, n: 1, wait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "refill never exceeds burst", rate: 10, burst: 5,
+			takes: []take{
+				{after: time.Hour, n: 5},
+				{after: time.Hour, n: 1, wait: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "partial tokens wait for rest", rate: 4, burst: 4,
+			takes: []take{
+				{n: 3},
+				{n: 3, wait: 500 * time.Millisecond},
+				{after: 500 * time.Millisecond, n: 3},
+			},
+		},
+		{
+			// larger than burst would never be available, it takes whole bucket and goes into debt
+			name: "more than burst", rate: 1, burst: 2,
+			takes: []take{
+				{n: 5},
+				{n: 1, wait: 4 * time.Second},
+				{after: 4 * time.Second, n: 1},
+			},
+		},
+		{
+			name: "clock going back is ignored", rate: 1, burst: 1,
+			takes: []take{
+				{after: time.Second, n: 1},
+				{n: 1, wait: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := NewTokenBucket(tt.rate, tt.burst, start)
+			for i, take := range tt.takes {
+				if wait := bucket.Take(take.n, start.Add(take.after)); wait != take.wait {
+					t.Errorf("take %d: Take(%v) wait = %v, want %v", i, take.n, wait, take.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketCharge(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		charge float64
+		after  time.Duration
+		wait   time.Duration
+	}{
+		{name: "within tokens", charge: 5, wait: 0},
+		{name: "debt is paid before next take", charge: 20, wait: 11 * time.Second},
+		{name: "debt paid by elapsed time", charge: 20, after: 11 * time.Second, wait: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := NewTokenBucket(1, 10, start)
+			bucket.Charge(tt.charge, start)
+
+			if wait := bucket.Take(1, start.Add(tt.after)); wait != tt.wait {
+				t.Errorf("Take() after Charge(%v) wait = %v, want %v", tt.charge, wait, tt.wait)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package utils
+
+import "sync"
+
+// KeyedSet is set of members per key, e.g. open upload IDs per client. member belongs to one key at most
+type KeyedSet struct {
+	mu      sync.Mutex
+	members map[string]map[string]struct{}
+	keys    map[string]string
+}
+
+func NewKeyedSet() *KeyedSet {
+	return &KeyedSet{
+		members: map[string]map[string]struct{}{},
+		keys:    map[string]string{},
+	}
+}
+
+// Add adds member into set of key if set has less than limit members, limit 0 means unlimited.
+// member already in set of key is always admitted
+func (s *KeyedSet) Add(key, member string, limit int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, ok := s.members[key]
+	if !ok {
+		members = map[string]struct{}{}
+		s.members[key] = members
+	}
+
+	if _, ok = members[member]; ok {
+		return true
+	}
+
+	if limit > 0 && len(members) >= limit {
+		return false
+	}
+
+	members[member] = struct{}{}
+	s.keys[member] = key
+	return true
+}
+
+// Remove removes member from set it belongs to, empty set is removed from map
+func (s *KeyedSet) Remove(member string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[member]
+	if !ok {
+		return
+	}
+
+	delete(s.keys, member)
+	delete(s.members[key], member)
+	if len(s.members[key]) == 0 {
+		delete(s.members, key)
+	}
+}
+
+// Len returns number of members of key
+func (s *KeyedSet) Len(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.members[key])
+}
@@ -0,0 +1,79 @@
+package utils
+
+import "testing"
+
+func TestKeyedSet(t *testing.T) {
+	type op struct {
+		remove string // member removed instead of added
+		key    string
+		member string
+		limit  int
+		added  bool
+	}
+
+	tests := []struct {
+		name string
+		ops  []op
+		lens map[string]int
+	}{
+		{
+			name: "limit per key",
+			ops: []op{
+				{key: "alice", member: "u1", limit: 2, added: true},
+				{key: "alice", member: "u2", limit: 2, added: true},
+				{key: "alice", member: "u3", limit: 2},
+				{key: "bob", member: "u4", limit: 2, added: true},
+			},
+			lens: map[string]int{"alice": 2, "bob": 1},
+		},
+		{
+			name: "member already added is admitted",
+			ops: []op{
+				{key: "alice", member: "u1", limit: 1, added: true},
+				{key: "alice", member: "u1", limit: 1, added: true},
+			},
+			lens: map[string]int{"alice": 1},
+		},
+		{
+			name: "removed member frees slot",
+			ops: []op{
+				{key: "alice", member: "u1", limit: 1, added: true},
+				{remove: "u1"},
+				{key: "alice", member: "u2", limit: 1, added: true},
+				{remove: "unknown"},
+			},
+			lens: map[string]int{"alice": 1},
+		},
+		{
+			name: "zero limit is unlimited",
+			ops: []op{
+				{key: "alice", member: "u1", added: true},
+				{key: "alice", member: "u2", added: true},
+				{key: "alice", member: "u3", added: true},
+			},
+			lens: map[string]int{"alice": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := NewKeyedSet()
+			for i, op := range tt.ops {
+				if op.remove != "" {
+					set.Remove(op.remove)
+					continue
+				}
+
+				if added := set.Add(op.key, op.member, op.limit); added != op.added {
+					t.Errorf("op %d: Add(%s, %s, %d) = %v, want %v", i, op.key, op.member, op.limit, added, op.added)
+				}
+			}
+
+			for key, want := range tt.lens {
+				if got := set.Len(key); got != want {
+					t.Errorf("Len(%s) = %d, want %d", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -8,8 +8,12 @@ import (
 	"github.com/sirupsen/logrus"
 	"go-upload-chunk/server/config"
 	"go-upload-chunk/server/drivers/logger"
+	"go-upload-chunk/server/drivers/scanner"
+	"go-upload-chunk/server/drivers/storage"
 	"go-upload-chunk/server/http/middleware"
 	"go-upload-chunk/server/http/router"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/service"
 	ioOtel "go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
@@ -28,6 +32,20 @@ import (
 func main() {
 	logger.SetupLogger()
 
+	// load .env once, config is read from env afterwards
+	if err := config.Load(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// run command instead of http server, e.g. "rotate-keys -generate"
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		if err := rotateKeys(os.Args[2:]); err != nil {
+			logrus.Fatal(err)
+		}
+
+		return
+	}
+
 	// connect to opentelemetry
 	traceProvider, err := NewTraceProvider(context.Background())
 	if err != nil {
@@ -50,9 +68,78 @@ func main() {
 
 	// init validator
 	validate := validator.New()
+	if err = service.RegisterFilenameValidation(validate); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// init tenants, each tenant has its own storage of chunk and final files
+	tenantService, err := service.NewTenantService(config.TenantsFile())
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// init storage of chunk and final files
+	fileStorage, err := storage.NewStorage(config.StorageDriver())
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// init locks of uploads and blobs, shared by every service of file storage
+	locks := service.NewUploadLocks()
+
+	// init webhooks, nil webhook service means upload events are not published.
+	// deliveries queued by previous run are sent once started
+	var webhookService entity.WebhookService
+	if config.WebhookURLs() != "" {
+		if webhookService, err = router.InitWebhookService(); err != nil {
+			logrus.Fatal(err)
+		}
+
+		webhookService.Start()
+	}
+
+	// init pipeline, nil pipeline service means final files are not processed.
+	// it is started before recovery, which submits files whose processing was interrupted
+	var pipelineService entity.PipelineService
+	if config.Processors() != "" {
+		if pipelineService, err = router.InitPipelineService(fileStorage, locks); err != nil {
+			logrus.Fatal(err)
+		}
+
+		pipelineService.Start()
+	}
+
+	// init malware scanner, nil scanner means final files are served unscanned
+	var fileScanner entity.Scanner
+	if config.Scanner() != "" {
+		if fileScanner, err = scanner.NewScanner(config.Scanner()); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	// finish uploads interrupted by previous run, manifest of each upload is source of truth
+	for _, tenant := range tenantService.ListTenants() {
+		if err = service.NewFileService(validate, fileStorage, webhookService, pipelineService, fileScanner, locks, router.InitUploadConfig()).Recover(entity.ContextWithTenant(context.Background(), tenant)); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	// init authentication, nil auth service means every request is allowed
+	var authService entity.AuthService
+	if config.AuthEnabled() {
+		if authService, err = service.NewAuthService(config.AuthAPIKeys(), config.AuthJWKSFile(), config.AuthJWTIssuer(), config.AuthJWTAudience()); err != nil {
+			logrus.Fatal(err)
+		}
+	} else if tenants := tenantService.ListTenants(); len(tenants) > 1 {
+		logrus.Warnf("authentication is disabled, requests belong to default tenant and other %d tenants can't be picked ⚠️", len(tenants)-1)
+	}
 
 	// Setup Router
-	router.SetupRouter(&app.RouterGroup, validate)
+	router.SetupRouter(&app.RouterGroup, validate, fileStorage, authService, tenantService, webhookService, pipelineService, fileScanner, locks)
+
+	// start janitor : removes abandoned chunk files and expired files of every tenant in background
+	janitor := service.NewJanitorService(fileStorage, webhookService, tenantService.ListTenants(), config.JanitorInterval(), locks)
+	janitor.Start()
 
 	// create http server
 	httpServer := &http.Server{
@@ -72,12 +159,12 @@ func main() {
 			select {
 			case <-chanSignal:
 				logrus.Warn("receive interrupt signal ⚠️")
-				gracefullShutdown(httpServer)
+				gracefullShutdown(httpServer, janitor, webhookService, pipelineService)
 				chanQuit <- struct{}{}
 				return
 			case e := <-chanErr:
 				logrus.Errorf("receive error signal : %s", e.Error())
-				gracefullShutdown(httpServer)
+				gracefullShutdown(httpServer, janitor, webhookService, pipelineService)
 				chanQuit <- struct{}{}
 				return
 			}
@@ -104,7 +191,21 @@ func main() {
 	logrus.Infof("Server Has Exited 🛑")
 }
 
-func gracefullShutdown(httpServer *http.Server) {
+func gracefullShutdown(httpServer *http.Server, janitor entity.JanitorService, webhookService entity.WebhookService, pipelineService entity.PipelineService) {
+	// stopped after http server, so events of last requests are queued. undelivered ones are sent by next run
+	if webhookService != nil {
+		defer webhookService.Stop()
+	}
+
+	// stopped after http server too, files waiting for worker are processed by next run
+	if pipelineService != nil {
+		defer pipelineService.Stop()
+	}
+
+	if janitor != nil {
+		janitor.Stop()
+	}
+
 	if httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
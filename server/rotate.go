@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"github.com/sirupsen/logrus"
+	"go-upload-chunk/server/config"
+	"go-upload-chunk/server/drivers/keyprovider"
+	"go-upload-chunk/server/drivers/storage"
+	"go-upload-chunk/server/internal/entity"
+	"go-upload-chunk/server/internal/service"
+)
+
+// rotateKeys re-wraps data keys of stored uploads and blobs of every tenant by current master key, file contents are not rewritten.
+// with -generate new master key is generated first and becomes current, older master keys are kept in key provider
+func rotateKeys(args []string) error {
+	flagSet := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	generate := flagSet.Bool("generate", false, "generate new master key before rotation")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if *generate {
+		keyProvider, err := keyprovider.NewKeyProvider(config.EncryptionKeyProvider())
+		if err != nil {
+			return err
+		}
+
+		if _, err = keyProvider.GenerateKey(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !config.EncryptionEnabled() {
+		logrus.Warn("encryption is disabled, no data key to rotate ⚠️")
+		return nil
+	}
+
+	fileStorage, err := storage.NewStorage(config.StorageDriver())
+	if err != nil {
+		return err
+	}
+
+	encryptedStorage, ok := fileStorage.(entity.EncryptedStorage)
+	if !ok {
+		return errors.New("storage is not encrypted")
+	}
+
+	tenantService, err := service.NewTenantService(config.TenantsFile())
+	if err != nil {
+		return err
+	}
+
+	// data keys of each tenant are stored in storage of that tenant
+	for _, tenant := range tenantService.ListTenants() {
+		if _, err = encryptedStorage.RotateKeys(entity.ContextWithTenant(ctx, tenant)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}